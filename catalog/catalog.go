@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"encoding/json"
+
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+)
+
+// Catalog persists databases and table definitions across restarts.
+type Catalog interface {
+	// Load returns table definitions by database and table name,
+	// DefaultDatabase is always there.
+	Load() (map[string]map[string]*util.Schema, error)
+	// Changes below are added to batch, they are made when it's
+	// written into storage with other writes of the statement.
+	Save(batch *storage.WriteBatch, schema *util.Schema) error
+	Remove(batch *storage.WriteBatch, db, table string) error
+
+	SaveDatabase(batch *storage.WriteBatch, name string) error
+	// RemoveDatabase removes database name with definitions of
+	// all its tables.
+	RemoveDatabase(batch *storage.WriteBatch, name string) error
+}
+
+var _ Catalog = &storeCatalog{}
+
+// storeCatalog keeps every table definition as a row in the reserved
+// catalog key range of the storage, next to the data it describes.
+type storeCatalog struct {
+	store storage.Storage
+}
+
+func NewCatalog(store storage.Storage) Catalog {
+	return &storeCatalog{
+		store: store,
+	}
+}
+
+func (c *storeCatalog) Load() (map[string]map[string]*util.Schema, error) {
+	schemas := map[string]map[string]*util.Schema{
+		util.DefaultDatabase: {},
+	}
+	low, up := util.DatabaseKeyRange()
+	itr := c.store.Scan(low, up)
+	for itr.Next() {
+		schemas[string(itr.Key()[len(low):])] = make(map[string]*util.Schema)
+	}
+	itr.Release()
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+
+	itr = c.store.Scan(util.CatalogRange())
+	defer itr.Release()
+	for itr.Next() {
+		schema := &util.Schema{}
+		if err := json.Unmarshal(itr.Value(), schema); err != nil {
+			return nil, err
+		}
+		upgradeSchema(schema)
+		if schemas[schema.Database] == nil {
+			schemas[schema.Database] = make(map[string]*util.Schema)
+		}
+		schemas[schema.Database][schema.TableName] = schema
+	}
+	return schemas, itr.Error()
+}
+
+func (c *storeCatalog) Save(batch *storage.WriteBatch, schema *util.Schema) error {
+	buf, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	batch.Put(util.CatalogKey(schema.Database, schema.TableName), buf)
+	return nil
+}
+
+func (c *storeCatalog) Remove(batch *storage.WriteBatch, db, table string) error {
+	batch.Delete(util.CatalogKey(db, table))
+	return nil
+}
+
+func (c *storeCatalog) SaveDatabase(batch *storage.WriteBatch, name string) error {
+	batch.Put(util.DatabaseKey(name), []byte{1})
+	return nil
+}
+
+func (c *storeCatalog) RemoveDatabase(batch *storage.WriteBatch, name string) error {
+	low := util.CatalogKey(name, "")
+	if err := storage.BatchDeleteRange(c.store, batch, low, util.PrefixEnd(low)); err != nil {
+		return err
+	}
+	batch.Delete(util.DatabaseKey(name))
+	return nil
+}
+
+// upgradeSchema fills what definitions saved by older versions lack.
+func upgradeSchema(schema *util.Schema) {
+	// There used to be only one database
+	if schema.Database == "" {
+		schema.Database = util.DefaultDatabase
+	}
+	schema.AssignColumnIDs()
+	// The first column used to be the primary key
+	if len(schema.PrimaryKey) == 0 && len(schema.Columns) > 0 {
+		schema.PrimaryKey = []string{schema.Columns[0].Name}
+	}
+	for _, c := range schema.Columns {
+		if schema.IsPrimaryKey(c.Name) {
+			c.NotNull = true
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	assert := assert.New(t)
+
+	store := storage.NewMemStorage()
+	c := NewCatalog(store)
+	schemas, err := c.Load()
+	assert.Equal(err, nil)
+	assert.Equal(len(schemas), 1)
+	assert.Equal(len(schemas[util.DefaultDatabase]), 0)
+
+	s := &util.Schema{
+		Database:   "d",
+		TableName:  "t",
+		PrimaryKey: []string{"pk"},
+		Columns: []*util.Column{
+			{
+				ID:      1,
+				Type:    util.ColumnInt64,
+				Name:    "pk",
+				NotNull: true,
+			},
+			{
+				ID:     2,
+				Type:   util.ColumnFixedString,
+				Name:   "str",
+				Strlen: 3,
+			},
+		},
+	}
+	batch := new(storage.WriteBatch)
+	assert.Equal(c.SaveDatabase(batch, "d"), nil)
+	assert.Equal(c.Save(batch, s), nil)
+	schemas, err = c.Load()
+	assert.Equal(err, nil)
+	assert.Equal(len(schemas), 1)
+	assert.Equal(store.Write(batch), nil)
+
+	schemas, err = c.Load()
+	assert.Equal(err, nil)
+	assert.Equal(schemas["d"]["t"], s)
+
+	batch.Reset()
+	assert.Equal(c.Remove(batch, "d", "t"), nil)
+	assert.Equal(store.Write(batch), nil)
+	schemas, err = c.Load()
+	assert.Equal(err, nil)
+	assert.Equal(len(schemas["d"]), 0)
+
+	// Tables are removed with database
+	batch.Reset()
+	assert.Equal(c.Save(batch, s), nil)
+	assert.Equal(store.Write(batch), nil)
+	batch.Reset()
+	assert.Equal(c.RemoveDatabase(batch, "d"), nil)
+	assert.Equal(store.Write(batch), nil)
+	schemas, err = c.Load()
+	assert.Equal(err, nil)
+	assert.Equal(len(schemas), 1)
+}
+
+func TestImportSchemaDir(t *testing.T) {
+	defer os.RemoveAll("tmp-schema")
+	assert := assert.New(t)
+
+	os.MkdirAll("tmp-schema", 0755)
+	ioutil.WriteFile(filepath.Join("tmp-schema", "t"), []byte("Int64 pk\nFixedString str 3\n"), 0644)
+
+	store := storage.NewMemStorage()
+	c := NewCatalog(store)
+	assert.Equal(ImportSchemaDir(c, store, "tmp-schema"), nil)
+
+	schemas, _ := c.Load()
+	assert.Equal(schemas[util.DefaultDatabase]["t"].String(), "Int64 pk\nFixedString str 3\n")
+
+	// Dropped tables must not come back on next start
+	batch := new(storage.WriteBatch)
+	c.Remove(batch, util.DefaultDatabase, "t")
+	store.Write(batch)
+	assert.Equal(ImportSchemaDir(c, store, "tmp-schema"), nil)
+	schemas, _ = c.Load()
+	assert.Equal(len(schemas[util.DefaultDatabase]), 0)
+}
+
+func TestParseLegacyKey(t *testing.T) {
+	assert := assert.New(t)
+	s := &util.Schema{
+		TableName:  "t",
+		PrimaryKey: []string{"s", "i"},
+		Columns: []*util.Column{
+			{
+				Type:   util.ColumnFixedString,
+				Name:   "s",
+				Strlen: 3,
+			},
+			{
+				Type: util.ColumnInt64,
+				Name: "i",
+			},
+		},
+	}
+
+	pk, err := parseLegacyKey(`a\:b:-10`, s)
+	assert.Equal(err, nil)
+	assert.Equal(pk, []interface{}{"a:b", int64(-10)})
+
+	// Keys are in the layout before databases
+	key := append(util.EncodeBytes([]byte{'t'}, []byte("t")), 'r')
+	key = util.EncodeInt(util.EncodeBytes(key, []byte("a:b")), -10)
+	assert.Equal(legacyRowKey("t", pk), key)
+}
+
+func TestResolveOriginDefaults(t *testing.T) {
+	assert := assert.New(t)
+	store := storage.NewMemStorage()
+	id := &util.Column{ID: 1, Type: util.ColumnInt64, Name: "id", NotNull: true}
+	s := &util.Schema{
+		Database:   util.DefaultDatabase,
+		TableName:  "t",
+		PrimaryKey: []string{"id"},
+		Columns:    []*util.Column{id},
+	}
+	s = s.NextVersion()
+	s.Columns = append(s.Columns, &util.Column{ID: 2, Type: util.ColumnInt32, Name: "x", NotNull: true})
+	schemas := map[string]map[string]*util.Schema{util.DefaultDatabase: {"t": s}}
+
+	assert.Equal(Upgrade(store, schemas), nil)
+	assert.Equal(s.Columns[0].OriginDefault, (*string)(nil))
+	assert.Equal(*s.Columns[1].OriginDefault, "0")
+	loaded, err := NewCatalog(store).Load()
+	assert.Equal(err, nil)
+	assert.Equal(*loaded[util.DefaultDatabase]["t"].Columns[1].OriginDefault, "0")
+}
+
+func TestIDGenerator(t *testing.T) {
+	assert := assert.New(t)
+
+	store := storage.NewMemStorage()
+	key := util.AutoIDKey(util.DefaultDatabase, "t")
+	g := NewIDGenerator(store, key, 10)
+
+	var wg sync.WaitGroup
+	ids := make(chan util.Int64, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := g.Gen()
+			assert.Equal(err, nil)
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[util.Int64]bool)
+	for id := range ids {
+		assert.True(id >= 1 && id <= 100)
+		assert.False(seen[id])
+		seen[id] = true
+	}
+
+	assert.Equal(g.Rebase(205), nil)
+	id, _ := g.Gen()
+	assert.Equal(id, util.Int64(206))
+
+	// Reserved IDs are skipped after restart, used ones never come back
+	g = NewIDGenerator(store, key, 10)
+	id, _ = g.Gen()
+	assert.True(id > 206)
+}
@@ -0,0 +1,94 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+)
+
+// IDBatch is the number of IDs reserved by one write to storage.
+const IDBatch = 1000
+
+// storeIDGenerator keeps the upper bound of reserved IDs in storage,
+// IDs below it are handed out from memory. IDs reserved but not used
+// before a restart are skipped, so there may be gaps between IDs.
+type storeIDGenerator struct {
+	mu    sync.Mutex
+	store storage.Storage
+	key   []byte
+	batch util.Int64
+
+	loaded bool
+	// next is the next ID to hand out, IDs up to end are reserved
+	next util.Int64
+	end  util.Int64
+}
+
+// NewIDGenerator returns an IDGenerator persisting its state under key.
+func NewIDGenerator(store storage.Storage, key []byte, batch util.Int64) util.IDGenerator {
+	return &storeIDGenerator{
+		store: store,
+		key:   key,
+		batch: batch,
+	}
+}
+
+func (g *storeIDGenerator) Gen() (util.Int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.load(); err != nil {
+		return 0, err
+	}
+	if g.next > g.end {
+		if err := g.reserve(g.next - 1 + g.batch); err != nil {
+			return 0, err
+		}
+	}
+	id := g.next
+	g.next++
+	return id, nil
+}
+
+func (g *storeIDGenerator) Rebase(id util.Int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.load(); err != nil {
+		return err
+	}
+	if id < g.next {
+		return nil
+	}
+	g.next = id + 1
+	// Persist it, or the ID may be generated again after a restart
+	if id > g.end {
+		return g.reserve(id)
+	}
+	return nil
+}
+
+func (g *storeIDGenerator) load() error {
+	if g.loaded {
+		return nil
+	}
+	v, err := g.store.Get(g.key)
+	if err == nil {
+		g.end = util.Int64(binary.BigEndian.Uint64(v))
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	g.next = g.end + 1
+	g.loaded = true
+	return nil
+}
+
+func (g *storeIDGenerator) reserve(end util.Int64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(end))
+	if err := g.store.Put(g.key, buf); err != nil {
+		return err
+	}
+	g.end = end
+	return nil
+}
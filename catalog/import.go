@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+)
+
+var schemaImportedKey = util.MetaKey("schema-imported")
+
+// ImportSchemaDir moves schema files written for older versions,
+// see $PROJECT_ROOT/examples/schema/my_table, into the catalog.
+// It only runs once, the files are left untouched afterwards.
+func ImportSchemaDir(c Catalog, store storage.Storage, path string) error {
+	if _, err := store.Get(schemaImportedKey); err == nil {
+		return nil
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+
+	schemas, err := c.Load()
+	if err != nil {
+		return err
+	}
+	batch := new(storage.WriteBatch)
+	if _, err := os.Stat(path); err == nil {
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			buff, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			schema := util.NewSchemaFromBytes(buff)
+			schema.TableName = filepath.Base(path)
+			if _, ok := schemas[util.DefaultDatabase][schema.TableName]; ok {
+				return nil
+			}
+			upgradeSchema(schema)
+			return c.Save(batch, schema)
+		})
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	batch.Put(schemaImportedKey, []byte{1})
+	return store.Write(batch)
+}
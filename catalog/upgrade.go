@@ -0,0 +1,235 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+)
+
+var formatKey = util.MetaKey("format")
+
+type upgrade func(store storage.Storage, batch *storage.WriteBatch, schemas map[string]map[string]*util.Schema) error
+
+// upgrades[i] converts data in format i into format i+1, writes of it
+// are added to batch and committed with the new format at once.
+var upgrades = []upgrade{
+	legacy(addRowHeaders),
+	legacy(encodeMemcomparableKeys),
+	legacy(moveIntoDefaultDatabase),
+	resolveOriginDefaults,
+}
+
+// legacy makes an upgrade of data older than databases, which only
+// has tables of DefaultDatabase.
+func legacy(step func(storage.Storage, *storage.WriteBatch, map[string]*util.Schema) error) upgrade {
+	return func(store storage.Storage, batch *storage.WriteBatch, schemas map[string]map[string]*util.Schema) error {
+		return step(store, batch, schemas[util.DefaultDatabase])
+	}
+}
+
+// Upgrade converts data written by older versions into the current format.
+func Upgrade(store storage.Storage, schemas map[string]map[string]*util.Schema) error {
+	var format int
+	if v, err := store.Get(formatKey); err == nil {
+		format = int(binary.BigEndian.Uint32(v))
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	for ; format < len(upgrades); format++ {
+		batch := new(storage.WriteBatch)
+		if err := upgrades[format](store, batch, schemas); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, uint32(format+1))
+		batch.Put(formatKey, buf)
+		if err := store.Write(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRowHeaders prepends the header introduced by versioned rows,
+// no table could have been altered before it.
+func addRowHeaders(store storage.Storage, batch *storage.WriteBatch, schemas map[string]*util.Schema) error {
+	// [format][uvarint of schema version 0]
+	header := []byte{util.RowFormatVarint, 0}
+	for table := range schemas {
+		itr := store.Scan(legacyTableRange(table))
+		for itr.Next() {
+			batch.Put(itr.Key(), append(append([]byte{}, header...), itr.Value()...))
+		}
+		itr.Release()
+		if err := itr.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Before memcomparable keys, a row was stored at `table:pk1:pk2`
+// with key columns rendered as text and ':' escaped in strings.
+func legacyTableRange(table string) (low, up []byte) {
+	low = []byte(table + ":")
+	up = []byte(table + ":")
+	up[len(up)-1]++
+	return
+}
+
+// New keys are written after all tables are scanned, so they never
+// show up in legacy range of another table.
+func encodeMemcomparableKeys(store storage.Storage, batch *storage.WriteBatch, schemas map[string]*util.Schema) error {
+	for table, schema := range schemas {
+		if err := encodeTableKeys(store, batch, table, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeTableKeys(store storage.Storage, batch *storage.WriteBatch, table string, schema *util.Schema) error {
+	itr := store.Scan(legacyTableRange(table))
+	defer itr.Release()
+	prefix := len(table) + 1
+	for itr.Next() {
+		pk, err := parseLegacyKey(string(itr.Key()[prefix:]), schema)
+		if err != nil {
+			return fmt.Errorf("upgrade key %q of table %s: %v", itr.Key(), table, err)
+		}
+		batch.Delete(itr.Key())
+		batch.Put(legacyRowKey(table, pk), itr.Value())
+	}
+	return itr.Error()
+}
+
+// legacyRowKey returns the key of row as of memcomparable keys, it's
+// `t[table name]r[primary key]` and moved into a database by the next
+// upgrade. It's kept apart from GenerateKey, which follows the current
+// layout and types.
+func legacyRowKey(table string, pk []interface{}) []byte {
+	key := append(util.EncodeBytes([]byte{'t'}, []byte(table)), 'r')
+	for _, v := range pk {
+		switch value := v.(type) {
+		case util.Int32:
+			key = util.EncodeInt(key, int64(value))
+		case util.Int64:
+			key = util.EncodeInt(key, value)
+		case util.UInt32:
+			key = util.EncodeUint(key, uint64(value))
+		case util.UInt64:
+			key = util.EncodeUint(key, value)
+		case util.FixedString:
+			key = util.EncodeBytes(key, []byte(value))
+		case util.Date:
+			key = util.EncodeInt(key, time.Time(value).Unix())
+		}
+	}
+	return key
+}
+
+// Before databases, keys of a table were prefixed by `t[table name]`
+// and its definition was stored at `\x00catalog:[table name]`.
+func moveIntoDefaultDatabase(store storage.Storage, batch *storage.WriteBatch, schemas map[string]*util.Schema) error {
+	cat := NewCatalog(store)
+	for table, schema := range schemas {
+		legacy := util.EncodeBytes([]byte{'t'}, []byte(table))
+		prefix := util.TablePrefix(util.DefaultDatabase, table)
+		itr := store.Scan(legacy, util.PrefixEnd(legacy))
+		for itr.Next() {
+			key := append(append([]byte{}, prefix...), itr.Key()[len(legacy):]...)
+			batch.Put(key, itr.Value())
+			batch.Delete(itr.Key())
+		}
+		itr.Release()
+		if err := itr.Error(); err != nil {
+			return err
+		}
+		batch.Delete([]byte("\x00catalog:" + table))
+		if err := cat.Save(batch, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseLegacyKey(key string, schema *util.Schema) ([]interface{}, error) {
+	// Split by unescaped ':'
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key):
+			i++
+			part.WriteByte(key[i])
+		case key[i] == ':':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(key[i])
+		}
+	}
+	parts = append(parts, part.String())
+	if len(parts) != len(schema.PrimaryKey) {
+		return nil, fmt.Errorf("expect %d key columns, got %d", len(schema.PrimaryKey), len(parts))
+	}
+
+	pk := make([]interface{}, len(parts))
+	for i, name := range schema.PrimaryKey {
+		col, _ := schema.GetColumnByName(name)
+		switch col.Type {
+		case util.ColumnInt32, util.ColumnInt64, util.ColumnUInt32, util.ColumnUInt64:
+			pk[i] = util.Cast(parts[i], col.Type)
+		case util.ColumnFixedString:
+			pk[i] = parts[i]
+		case util.ColumnDate:
+			ts, err := strconv.ParseInt(parts[i], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			pk[i] = util.Date(time.Unix(ts, 0))
+		default:
+			return nil, fmt.Errorf("unsupported key type %s", col.Type)
+		}
+	}
+	return pk, nil
+}
+
+// Columns added by ALTER TABLE with NOT NULL and no DEFAULT used to
+// get the zero value in older rows by NOT NULL of the column, which
+// MODIFY COLUMN may change. It's kept in OriginDefault now.
+func resolveOriginDefaults(store storage.Storage, batch *storage.WriteBatch, schemas map[string]map[string]*util.Schema) error {
+	cat := NewCatalog(store)
+	for _, tables := range schemas {
+		for _, schema := range tables {
+			if len(schema.History) == 0 {
+				continue
+			}
+			created := make(map[int]bool)
+			for _, c := range schema.History[0].Columns {
+				created[c.ID] = true
+			}
+			changed := false
+			for _, c := range schema.Columns {
+				if created[c.ID] || !c.NotNull || c.OriginDefault != nil {
+					continue
+				}
+				origin := util.Cast(c.ZeroValue(), util.ColumnFixedString).(string)
+				c.OriginDefault = &origin
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+			if err := cat.Save(batch, schema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
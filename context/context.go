@@ -1,31 +1,332 @@
 package context
 
 import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leiysky/a-database/catalog"
 	"github.com/leiysky/a-database/storage"
 	"github.com/leiysky/a-database/util"
 )
 
 type Context interface {
-	Schemas() map[string]*util.Schema
+	// Databases returns names of all databases in order.
+	Databases() []string
+	// Schemas returns table definitions of db, nil if db doesn't exist.
+	Schemas(db string) map[string]*util.Schema
 	Store() storage.Storage
+	Options() *Options
+
+	// Methods below change definitions with other writes of the
+	// statement in batch, all of them are committed at once. batch
+	// may be nil if there is none.
+	CreateDatabase(batch *storage.WriteBatch, name string) error
+	// DropDatabase forgets db and its tables, caller deletes keys
+	// of them in batch.
+	DropDatabase(batch *storage.WriteBatch, name string) error
+
+	CreateTable(batch *storage.WriteBatch, schema *util.Schema) error
+	AlterTable(batch *storage.WriteBatch, schema *util.Schema) error
+	DropTable(batch *storage.WriteBatch, db, name string) error
+
+	// WriteLock serializes statements writing rows, so a read-modify-write
+	// of a row never races with another writer.
+	WriteLock() sync.Locker
+
+	// IDGenerator returns the AUTO_INCREMENT allocator of table.
+	IDGenerator(db, table string) util.IDGenerator
+	// Session returns states of the client session, it's nil
+	// unless the context is returned by NewSession.
+	Session() *Session
+	// Snapshot returns the storage as of the start of statement,
+	// which reads of rows are from. It's nil unless the context is
+	// returned by NewStatement.
+	Snapshot() storage.Snapshot
+	// StartTime returns the time statement starts at, which NOW() and
+	// alike return for all rows. It's zero unless the context is
+	// returned by NewStatement.
+	StartTime() time.Time
+}
+
+// Session keeps states of a client across statements.
+type Session struct {
+	database     string
+	lastInsertID int64
+}
+
+// Database returns the current database, an empty string means
+// it has been dropped.
+func (s *Session) Database() string {
+	if s == nil {
+		return util.DefaultDatabase
+	}
+	return s.database
+}
+
+// Use changes the current database.
+func (s *Session) Use(db string) {
+	if s != nil {
+		s.database = db
+	}
+}
+
+// LastInsertID returns the first ID generated by the latest INSERT.
+func (s *Session) LastInsertID() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.lastInsertID
+}
+
+func (s *Session) SetLastInsertID(id int64) {
+	if s != nil {
+		s.lastInsertID = id
+	}
+}
+
+type sessionContext struct {
+	Context
+	session *Session
+}
+
+func (c *sessionContext) Session() *Session {
+	return c.session
+}
+
+// NewSession returns a context sharing everything with ctx except
+// states of session.
+func NewSession(ctx Context) Context {
+	return &sessionContext{
+		Context: ctx,
+		session: &Session{database: util.DefaultDatabase},
+	}
+}
+
+// Statement is the context of a running statement, all its reads are
+// from one snapshot so they are consistent with each other.
+type Statement struct {
+	Context
+	snapshot storage.Snapshot
+	start    time.Time
+}
+
+// NewStatement returns a context sharing everything with ctx, and takes
+// a snapshot for the statement. It must be released after the statement.
+func NewStatement(ctx Context) (*Statement, error) {
+	snapshot, err := ctx.Store().Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Statement{
+		Context:  ctx,
+		snapshot: snapshot,
+		start:    time.Now(),
+	}, nil
+}
+
+func (s *Statement) Snapshot() storage.Snapshot {
+	return s.snapshot
+}
+
+func (s *Statement) StartTime() time.Time {
+	return s.start
+}
+
+func (s *Statement) Release() {
+	s.snapshot.Release()
+}
+
+type Options struct {
+	// RewriteOnAlter upgrades rows written with an older schema
+	// version in background after ALTER TABLE.
+	RewriteOnAlter bool
 }
 
 type context struct {
-	schemas map[string]*util.Schema
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+	// Table definitions by database and table name
+	schemas map[string]map[string]*util.Schema
+	catalog catalog.Catalog
 	store   storage.Storage
+	opts    *Options
+	idGens  map[string]util.IDGenerator
 }
 
-func (c *context) Schemas() map[string]*util.Schema {
-	return c.schemas
+func (c *context) Databases() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	dbs := make([]string, 0, len(c.schemas))
+	for db := range c.schemas {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	return dbs
+}
+
+// Schemas returns a copy of table definitions, it's safe to
+// iterate over it while other sessions run DDL.
+func (c *context) Schemas(db string) map[string]*util.Schema {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tables, ok := c.schemas[db]
+	if !ok {
+		return nil
+	}
+	schemas := make(map[string]*util.Schema, len(tables))
+	for k, v := range tables {
+		schemas[k] = v
+	}
+	return schemas
 }
 
 func (c *context) Store() storage.Storage {
 	return c.store
 }
 
-func NewContext(schemas map[string]*util.Schema, store storage.Storage) Context {
+func (c *context) Options() *Options {
+	return c.opts
+}
+
+func (c *context) WriteLock() sync.Locker {
+	return &c.writeMu
+}
+
+func (c *context) IDGenerator(db, table string) util.IDGenerator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(util.AutoIDKey(db, table))
+	g, ok := c.idGens[key]
+	if !ok {
+		g = catalog.NewIDGenerator(c.store, []byte(key), catalog.IDBatch)
+		c.idGens[key] = g
+	}
+	return g
+}
+
+func (c *context) Session() *Session {
+	return nil
+}
+
+func (c *context) Snapshot() storage.Snapshot {
+	return nil
+}
+
+func (c *context) StartTime() time.Time {
+	return time.Time{}
+}
+
+// commit writes batch of a statement changing definitions.
+func (c *context) commit(batch *storage.WriteBatch, change func(batch *storage.WriteBatch) error) error {
+	if batch == nil {
+		batch = new(storage.WriteBatch)
+	}
+	if err := change(batch); err != nil {
+		return err
+	}
+	return c.store.Write(batch)
+}
+
+func (c *context) CreateDatabase(batch *storage.WriteBatch, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.schemas[name]; ok {
+		return fmt.Errorf("database %s already exists", name)
+	}
+	err := c.commit(batch, func(batch *storage.WriteBatch) error {
+		return c.catalog.SaveDatabase(batch, name)
+	})
+	if err != nil {
+		return err
+	}
+	c.schemas[name] = make(map[string]*util.Schema)
+	return nil
+}
+
+func (c *context) DropDatabase(batch *storage.WriteBatch, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables, ok := c.schemas[name]
+	if !ok {
+		return fmt.Errorf("database %s doesn't exist", name)
+	}
+	err := c.commit(batch, func(batch *storage.WriteBatch) error {
+		return c.catalog.RemoveDatabase(batch, name)
+	})
+	if err != nil {
+		return err
+	}
+	for table := range tables {
+		delete(c.idGens, string(util.AutoIDKey(name, table)))
+	}
+	delete(c.schemas, name)
+	return nil
+}
+
+func (c *context) CreateTable(batch *storage.WriteBatch, schema *util.Schema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables, ok := c.schemas[schema.Database]
+	if !ok {
+		return fmt.Errorf("database %s doesn't exist", schema.Database)
+	}
+	if _, ok := tables[schema.TableName]; ok {
+		return fmt.Errorf("table %s already exists", schema.TableName)
+	}
+	err := c.commit(batch, func(batch *storage.WriteBatch) error {
+		return c.catalog.Save(batch, schema)
+	})
+	if err != nil {
+		return err
+	}
+	tables[schema.TableName] = schema
+	delete(c.idGens, string(util.AutoIDKey(schema.Database, schema.TableName)))
+	return nil
+}
+
+func (c *context) AlterTable(batch *storage.WriteBatch, schema *util.Schema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables := c.schemas[schema.Database]
+	if _, ok := tables[schema.TableName]; !ok {
+		return fmt.Errorf("table %s doesn't exist", schema.TableName)
+	}
+	err := c.commit(batch, func(batch *storage.WriteBatch) error {
+		return c.catalog.Save(batch, schema)
+	})
+	if err != nil {
+		return err
+	}
+	tables[schema.TableName] = schema
+	return nil
+}
+
+func (c *context) DropTable(batch *storage.WriteBatch, db, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables := c.schemas[db]
+	if _, ok := tables[name]; !ok {
+		return fmt.Errorf("table %s doesn't exist", name)
+	}
+	err := c.commit(batch, func(batch *storage.WriteBatch) error {
+		return c.catalog.Remove(batch, db, name)
+	})
+	if err != nil {
+		return err
+	}
+	delete(tables, name)
+	delete(c.idGens, string(util.AutoIDKey(db, name)))
+	return nil
+}
+
+func NewContext(schemas map[string]map[string]*util.Schema, cat catalog.Catalog, store storage.Storage, opts *Options) Context {
 	return &context{
 		schemas: schemas,
+		catalog: cat,
 		store:   store,
+		opts:    opts,
+		idGens:  make(map[string]util.IDGenerator),
 	}
 }
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"fmt"
+
 	"github.com/leiysky/a-database/context"
 	"github.com/leiysky/a-database/util"
 
@@ -18,14 +20,63 @@ func NewDB(ctx context.Context) *DB {
 	}
 }
 
-func (db *DB) ExecuteQuery(sql string) []*util.Row {
-	// Step 1: parse sql into ast
-	parser := parser.New()
-	stmt := parser.Parse(sql)
+// ExecuteQuery runs sql in a new session.
+func (db *DB) ExecuteQuery(sql string) (rows []*util.Row, err error) {
+	return db.NewSession().ExecuteQuery(sql)
+}
+
+// Session runs statements of a client, states like LAST_INSERT_ID()
+// are kept across them.
+type Session struct {
+	ctx context.Context
+}
+
+func (db *DB) NewSession() *Session {
+	return &Session{
+		ctx: context.NewSession(db.ctx),
+	}
+}
+
+// LastInsertID returns the first AUTO_INCREMENT ID generated by
+// the latest INSERT of session, 0 if there is none.
+func (s *Session) LastInsertID() int64 {
+	return s.ctx.Session().LastInsertID()
+}
+
+func (s *Session) ExecuteQuery(sql string) (rows []*util.Row, err error) {
+	return s.run(func() executor.Executor {
+		// Step 1: parse sql into ast
+		parser := parser.New()
+		stmt := parser.Parse(sql)
+
+		// Step 2: compile ast into executor
+		return executor.Compile(stmt)
+	})
+}
+
+// Use changes the current database of session like USE statement.
+func (s *Session) Use(database string) error {
+	_, err := s.run(func() executor.Executor {
+		return &executor.Use{Database: database}
+	})
+	return err
+}
+
+func (s *Session) run(compile func() executor.Executor) (rows []*util.Row, err error) {
+	// Executors panic on failure, report it to client as an error
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			rows = nil
+		}
+	}()
 
-	// Step 2: compile ast into executor
-	exec := executor.Compile(stmt)
+	exec := compile()
 
 	// Step 3: execute query
-	return executor.Exec(exec, db.ctx)
+	return executor.Exec(exec, s.ctx), nil
 }
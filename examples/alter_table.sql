@@ -0,0 +1 @@
+alter table my_table add column note varchar(8) default "none"
\ No newline at end of file
@@ -0,0 +1 @@
+create table my_table2 (pk bigint primary key, name varchar(8))
\ No newline at end of file
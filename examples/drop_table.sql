@@ -0,0 +1 @@
+drop table my_table2
\ No newline at end of file
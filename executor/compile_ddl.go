@@ -0,0 +1,357 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/parser"
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Values of sqlparser.ColumnKeyOption, which are not exported
+const (
+	colKeyPrimary   sqlparser.ColumnKeyOption = 1
+	colKeyUnique    sqlparser.ColumnKeyOption = 3
+	colKeyUniqueKey sqlparser.ColumnKeyOption = 4
+)
+
+// lobSizes are max lengths of TEXT and BLOB types by their prefixes.
+var lobSizes = map[string]int{
+	"tiny":   1<<8 - 1,
+	"":       1<<16 - 1,
+	"medium": 1<<24 - 1,
+	"long":   1<<32 - 1,
+}
+
+// intWidths are bytes of integer types narrower than INT.
+var intWidths = map[string]int{
+	"tinyint":   1,
+	"smallint":  2,
+	"mediumint": 3,
+}
+
+func compileDDL(stmt *sqlparser.DDL) Executor {
+	switch stmt.Action {
+	case sqlparser.CreateStr:
+		return compileCreateTable(stmt)
+	case sqlparser.DropStr:
+		return &DropTable{
+			TableName: &stmt.Table,
+			IfExists:  stmt.IfExists,
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported DDL: %s", stmt.Action))
+	}
+}
+
+func compileDBDDL(stmt *sqlparser.DBDDL) Executor {
+	switch stmt.Action {
+	case sqlparser.CreateStr:
+		return &CreateDatabase{
+			Database:    stmt.DBName,
+			IfNotExists: stmt.IfExists,
+		}
+	case sqlparser.DropStr:
+		return &DropDatabase{
+			Database: stmt.DBName,
+			IfExists: stmt.IfExists,
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported DDL: %s", stmt.Action))
+	}
+}
+
+func compileCreateTable(stmt *sqlparser.DDL) Executor {
+	if stmt.TableSpec == nil {
+		panic("Invalid table definition")
+	}
+	schema := &util.Schema{
+		TableName: stmt.NewName.Name.String(),
+	}
+	for _, def := range stmt.TableSpec.Columns {
+		if c, _ := schema.GetColumnByName(def.Name.Lowered()); c.Name != "" {
+			panic(fmt.Sprintf("Duplicate column name %s", def.Name.String()))
+		}
+		schema.Columns = append(schema.Columns, compileColumnDefinition(def))
+		if def.Type.KeyOpt == colKeyPrimary {
+			if schema.PrimaryKey != nil {
+				panic("Multiple primary key defined")
+			}
+			schema.PrimaryKey = []string{def.Name.Lowered()}
+		}
+	}
+	var uniques []*util.Index
+	for _, def := range stmt.TableSpec.Columns {
+		if def.Type.KeyOpt == colKeyUnique || def.Type.KeyOpt == colKeyUniqueKey {
+			uniques = append(uniques, &util.Index{
+				Columns: []string{def.Name.Lowered()},
+			})
+		}
+	}
+	for _, idx := range stmt.TableSpec.Indexes {
+		if !idx.Info.Primary && !idx.Info.Unique {
+			continue
+		}
+		var columns []string
+		for _, c := range idx.Columns {
+			name := c.Column.Lowered()
+			if _, offset := schema.GetColumnByName(name); offset < 0 {
+				panic(fmt.Sprintf("Key column %s doesn't exist in table", name))
+			}
+			columns = append(columns, name)
+		}
+		if !idx.Info.Primary {
+			uniques = append(uniques, &util.Index{
+				Name:    idx.Info.Name.Lowered(),
+				Columns: columns,
+			})
+			continue
+		}
+		if schema.PrimaryKey != nil {
+			panic("Multiple primary key defined")
+		}
+		schema.PrimaryKey = columns
+	}
+	if schema.PrimaryKey == nil {
+		panic(fmt.Sprintf("Table %s must have a primary key", schema.TableName))
+	}
+	// Named indexes go first, so generated names never take theirs
+	for _, named := range []bool{true, false} {
+		for _, idx := range uniques {
+			if (idx.Name != "") != named {
+				continue
+			}
+			// Primary key is unique already
+			if strings.Join(idx.Columns, ",") == strings.Join(schema.PrimaryKey, ",") {
+				continue
+			}
+			for _, i := range schema.Indexes {
+				if i.Name == idx.Name {
+					panic(fmt.Sprintf("Duplicate key name %s", idx.Name))
+				}
+			}
+			schema.AddIndex(idx)
+		}
+	}
+	checkAutoIncrement(schema)
+	for _, name := range schema.PrimaryKey {
+		checkKeyColumn(schema, name)
+	}
+	for _, idx := range schema.Indexes {
+		for _, name := range idx.Columns {
+			checkKeyColumn(schema, name)
+		}
+	}
+	// Columns of primary key are implicitly NOT NULL
+	for _, c := range schema.Columns {
+		if schema.IsPrimaryKey(c.Name) {
+			c.NotNull = true
+		}
+	}
+	schema.AssignColumnIDs()
+	return &CreateTable{
+		TableName: &stmt.NewName,
+		Schema:    schema,
+	}
+}
+
+func compileAlterTable(stmt *parser.AlterTable) Executor {
+	if stmt.Err != nil {
+		panic(stmt.Err)
+	}
+	alter := &AlterTable{
+		TableName: &stmt.Table,
+		Action:    stmt.Action,
+	}
+	switch stmt.Action {
+	case "add":
+		alter.Column = compileColumnDefinition(stmt.Column)
+		if alter.Column.AutoIncrement {
+			panic("Unsupported adding AUTO_INCREMENT column")
+		}
+		keyOpt := stmt.Column.Type.KeyOpt
+		alter.Unique = keyOpt == colKeyUnique || keyOpt == colKeyUniqueKey
+		if alter.Unique && alter.Column.Type == util.ColumnJSON {
+			panic(fmt.Sprintf("JSON column %s can't be used in key", alter.Column.Name))
+		}
+	case "modify":
+		alter.Column = compileColumnDefinition(stmt.Column)
+		alter.ColumnName = alter.Column.Name
+	case "drop":
+		alter.ColumnName = stmt.ColumnName.Lowered()
+	}
+	return alter
+}
+
+func compileColumnDefinition(def *sqlparser.ColumnDefinition) *util.Column {
+	c := &util.Column{
+		Name:    def.Name.Lowered(),
+		NotNull: bool(def.Type.NotNull),
+	}
+	switch def.Type.Type {
+	case "tinyint", "smallint", "mediumint", "int", "integer":
+		c.Type = util.ColumnInt32
+		if def.Type.Unsigned {
+			c.Type = util.ColumnUInt32
+		}
+		c.Width = intWidths[def.Type.Type]
+		// BOOL and BOOLEAN are rewritten by parser, TINYINT(1) is
+		// still an integer
+		if def.Type.Type == "tinyint" && def.Type.Length != nil && string(def.Type.Length.Val) == parser.BoolTypeLength {
+			c.Type = util.ColumnBoolean
+			c.Width = 0
+		}
+	case "bigint":
+		c.Type = util.ColumnInt64
+		if def.Type.Unsigned {
+			c.Type = util.ColumnUInt64
+		}
+	case "char":
+		c.Type = util.ColumnFixedString
+		c.Strlen = 1
+		if def.Type.Length != nil {
+			c.Strlen, _ = strconv.Atoi(string(def.Type.Length.Val))
+		}
+	case "varchar":
+		if def.Type.Length == nil {
+			panic(fmt.Sprintf("Column %s of VARCHAR must have a length", c.Name))
+		}
+		c.Type = util.ColumnVarchar
+		c.Strlen, _ = strconv.Atoi(string(def.Type.Length.Val))
+	case "tinytext", "text", "mediumtext", "longtext":
+		c.Type = util.ColumnText
+		c.Strlen = lobSizes[strings.TrimSuffix(def.Type.Type, "text")]
+	case "tinyblob", "blob", "mediumblob", "longblob":
+		c.Type = util.ColumnBlob
+		c.Strlen = lobSizes[strings.TrimSuffix(def.Type.Type, "blob")]
+	case "date":
+		c.Type = util.ColumnDateOnly
+	case "datetime", "timestamp":
+		c.Type = util.ColumnDatetime
+		if def.Type.Type == "timestamp" {
+			c.Type = util.ColumnTimestamp
+		}
+		if def.Type.Length != nil {
+			c.Fsp, _ = strconv.Atoi(string(def.Type.Length.Val))
+		}
+		if c.Fsp > util.MaxFsp {
+			panic(fmt.Sprintf("Too big precision %d for column %s, maximum is %d", c.Fsp, c.Name, util.MaxFsp))
+		}
+	case "enum":
+		c.Type = util.ColumnEnum
+		if len(def.Type.EnumValues) > math.MaxUint16 {
+			panic(fmt.Sprintf("Too many elements for column %s", c.Name))
+		}
+		for _, v := range def.Type.EnumValues {
+			// Elements are quoted by sqlparser
+			elem := strings.TrimRight(v[1:len(v)-1], " ")
+			if (util.Enum{Elems: c.Elems}).IndexOf(elem) > 0 {
+				panic(fmt.Sprintf("Column %s has duplicated value '%s' in ENUM", c.Name, elem))
+			}
+			c.Elems = append(c.Elems, elem)
+		}
+	case "json":
+		c.Type = util.ColumnJSON
+		c.Strlen = lobSizes["long"]
+	case "float":
+		c.Type = util.ColumnFloat
+	case "double", "real":
+		c.Type = util.ColumnDouble
+	case "decimal", "numeric":
+		c.Type = util.ColumnDecimal
+		c.Precision = 10
+		if def.Type.Length != nil {
+			c.Precision, _ = strconv.Atoi(string(def.Type.Length.Val))
+		}
+		if def.Type.Scale != nil {
+			c.Scale, _ = strconv.Atoi(string(def.Type.Scale.Val))
+		}
+		if c.Precision < 1 || c.Precision > util.MaxDecimalPrecision {
+			panic(fmt.Sprintf("Precision of column %s must be between 1 and %d", c.Name, util.MaxDecimalPrecision))
+		}
+		if c.Scale > c.Precision {
+			panic(fmt.Sprintf("Scale of column %s must not be greater than precision", c.Name))
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported column type %s", def.Type.Type))
+	}
+	if def.Type.Autoincrement {
+		switch c.Type {
+		case util.ColumnInt32, util.ColumnInt64, util.ColumnUInt32, util.ColumnUInt64:
+		default:
+			panic(fmt.Sprintf("Incorrect column specifier for column %s", c.Name))
+		}
+		if def.Type.Default != nil {
+			panic(fmt.Sprintf("Invalid default value for %s", c.Name))
+		}
+		c.AutoIncrement = true
+	}
+	if val := def.Type.Default; val != nil {
+		if val.Type == sqlparser.ValArg && strings.ToLower(string(val.Val)) == "null" {
+			if c.NotNull {
+				panic(fmt.Sprintf("Invalid default value for %s", c.Name))
+			}
+		} else {
+			expr := sqlparser.String(val)
+			c.Default = &expr
+			// Fail early if the expression can't be evaluated
+			defaultValue(nil, c)
+		}
+	}
+	return c
+}
+
+// checkAutoIncrement makes sure there is at most one AUTO_INCREMENT
+// column, and it's the first column of primary key or an unique index.
+func checkAutoIncrement(schema *util.Schema) {
+	var auto []string
+	for _, c := range schema.Columns {
+		if c.AutoIncrement {
+			auto = append(auto, c.Name)
+		}
+	}
+	if len(auto) == 0 {
+		return
+	}
+	if len(auto) == 1 {
+		if schema.PrimaryKey[0] == auto[0] {
+			return
+		}
+		for _, idx := range schema.Indexes {
+			if idx.Columns[0] == auto[0] {
+				return
+			}
+		}
+	}
+	panic("Incorrect table definition; there can be only one auto column and it must be defined as a key")
+}
+
+// checkKeyColumn panics if column name of schema can't be in a key.
+func checkKeyColumn(schema *util.Schema, name string) {
+	if c, _ := schema.GetColumnByName(name); c.Type == util.ColumnJSON {
+		panic(fmt.Sprintf("JSON column %s can't be used in key", name))
+	}
+}
+
+// defaultValue evaluates DEFAULT of column c in statement of ctx, it
+// returns nil for columns without default.
+func defaultValue(ctx context.Context, c *util.Column) interface{} {
+	if c.Default == nil {
+		return nil
+	}
+	stmt, err := sqlparser.Parse("select " + *c.Default)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid default value for %s", c.Name))
+	}
+	expr := stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr
+	e := rewriteExpr(expr)
+	if e == nil {
+		panic(fmt.Sprintf("Invalid default value for %s", c.Name))
+	}
+	bindContext(e, ctx)
+	return util.CastColumn(e.Eval(nil), c)
+}
@@ -1,19 +1,14 @@
 package executor
 
 import (
-	"strconv"
-
 	"github.com/leiysky/a-database/util"
 	"github.com/xwb1989/sqlparser"
 )
 
 func compileInsert(stmt *sqlparser.Insert) Executor {
-	var cols []*util.Column
+	var cols []string
 	for _, c := range stmt.Columns {
-		// Type will be filled in `Executor.Open`
-		cols = append(cols, &util.Column{
-			Name: c.String(),
-		})
+		cols = append(cols, c.Lowered())
 	}
 	rows := extractInsertRows(stmt.Rows)
 	rr := make([]*util.Row, len(rows))
@@ -24,25 +19,72 @@ func compileInsert(stmt *sqlparser.Insert) Executor {
 		rr[i] = row
 	}
 	insert := &Insert{
+		Columns:   cols,
 		Values:    rr,
 		TableName: &stmt.Table,
 	}
 	return insert
 }
 
+// insertValue rewrites a value of INSERT which isn't a literal, only
+// functions like NOW() or DATE('2020-01-01') are supported. It's
+// evaluated when the statement runs.
+func insertValue(expr sqlparser.Expr) Expression {
+	e := rewriteExpr(expr)
+	if !withoutColumns(e) {
+		panic("Unkown value type")
+	}
+	return e
+}
+
+// withoutColumns tells whether expr can be evaluated without a row.
+func withoutColumns(expr Expression) bool {
+	switch e := expr.(type) {
+	case *SQLValue, *Now:
+		return true
+	case *DateFunc:
+		for _, arg := range e.Args {
+			if !withoutColumns(arg) {
+				return false
+			}
+		}
+		return true
+	case *JSONFunc:
+		for _, arg := range e.Args {
+			if !withoutColumns(arg) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 func extractInsertRows(rows sqlparser.InsertRows) [][]interface{} {
 	var rr [][]interface{}
 	values := rows.(sqlparser.Values)
 	for _, tuple := range values {
 		var row []interface{}
 		for _, expr := range tuple {
-			val := expr.(*sqlparser.SQLVal)
+			if _, ok := expr.(*sqlparser.NullVal); ok {
+				row = append(row, nil)
+				continue
+			}
+			val, ok := foldNegative(expr).(*sqlparser.SQLVal)
+			if !ok {
+				row = append(row, insertValue(expr))
+				continue
+			}
 			switch val.Type {
 			case sqlparser.IntVal:
-				v, _ := strconv.Atoi(string(val.Val))
-				row = append(row, v)
+				row = append(row, parseIntVal(val.Val))
+			case sqlparser.FloatVal:
+				row = append(row, parseFloatVal(val.Val))
 			case sqlparser.StrVal:
 				row = append(row, string(val.Val))
+			case sqlparser.HexVal, sqlparser.HexNum:
+				row = append(row, parseHexVal(val))
 			default:
 				panic("Unkown value type")
 			}
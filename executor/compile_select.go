@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/xwb1989/sqlparser"
@@ -9,18 +10,15 @@ import (
 func compileSelectStmt(stmt *sqlparser.Select) Executor {
 	var exec Executor
 
-	var cols []*sqlparser.ColName
 	projection := &Projection{}
 	for _, expr := range stmt.SelectExprs {
 		if _, ok := expr.(*sqlparser.StarExpr); ok {
 			projection = nil
 			break
 		}
-		cols = append(cols, extractField(expr))
-	}
-	if projection != nil {
-		projection.from = cols
-		projection.to = cols
+		field := extractField(expr)
+		projection.exprs = append(projection.exprs, rewriteExpr(field.Expr))
+		projection.names = append(projection.names, fieldName(field))
 	}
 
 	datasource := compileFrom(stmt.From)
@@ -29,6 +27,9 @@ func compileSelectStmt(stmt *sqlparser.Select) Executor {
 
 	if stmt.Where != nil {
 		selection := compileWhere(stmt.Where)
+		if ts, ok := datasource.(*TableScan); ok {
+			ts.filter = selection.predicate
+		}
 		selection.children = append(selection.children, exec)
 		exec = selection
 	}
@@ -47,39 +48,50 @@ func compileSelectStmt(stmt *sqlparser.Select) Executor {
 	return exec
 }
 
-func extractField(expr sqlparser.SelectExpr) *sqlparser.ColName {
+func extractField(expr sqlparser.SelectExpr) *sqlparser.AliasedExpr {
 	switch e := expr.(type) {
 	case *sqlparser.AliasedExpr:
-		colName := e.Expr.(*sqlparser.ColName)
-		return colName
+		return e
 	default:
 		panic("Unknown AST")
 	}
 }
 
+// fieldName returns name of the result column of field.
+func fieldName(field *sqlparser.AliasedExpr) string {
+	if !field.As.IsEmpty() {
+		return field.As.String()
+	}
+	if col, ok := field.Expr.(*sqlparser.ColName); ok {
+		return col.Name.Lowered()
+	}
+	return sqlparser.String(field.Expr)
+}
+
 func compileFrom(exprs sqlparser.TableExprs) Executor {
 	var tableNames []*sqlparser.TableName
 	for _, expr := range exprs {
 		tableNames = append(tableNames, extractTableName(expr))
 	}
-	if len(tableNames) == 1 {
-		return &TableScan{
-			table: tableNames[0],
-		}
+	if len(tableNames) == 1 && tableNames[0].Qualifier.IsEmpty() && tableNames[0].Name.String() == "dual" {
+		return &Dual{}
 	}
-	join := &Join{}
-	for i, name := range tableNames {
-		ts := &TableScan{
-			table: name,
-		}
-		join.children = append(join.children, ts)
-		if i > 0 {
-			newJoin := &Join{}
-			newJoin.children = append(newJoin.children, join)
-			join = newJoin
-		}
+	// Join tables from left to right
+	exec := compileTableScan(tableNames[0])
+	for _, name := range tableNames[1:] {
+		join := &Join{}
+		join.children = append(join.children, exec, compileTableScan(name))
+		exec = join
+	}
+	return exec
+}
+
+// compileTableScan returns a scan of table name, tables of
+// information_schema are read by InfoSchemaScan in it.
+func compileTableScan(name *sqlparser.TableName) Executor {
+	return &TableScan{
+		table: name,
 	}
-	return join
 }
 
 func compileWhere(expr *sqlparser.Where) *Selection {
@@ -110,8 +122,24 @@ func compileLimit(limit *sqlparser.Limit) *Limit {
 }
 
 func compileShow(show *sqlparser.Show) Executor {
-	if show.Type == "tables" {
-		return &ShowTables{}
+	switch show.Type {
+	case "databases":
+		return &ShowDatabases{}
+	case "tables":
+		tables := &ShowTables{}
+		if show.ShowTablesOpt != nil {
+			tables.Database = show.ShowTablesOpt.DbName
+		}
+		return tables
+	case "columns", "fields":
+		return &DescribeTable{
+			TableName: &show.OnTable,
+		}
+	case "create table":
+		return &ShowCreateTable{
+			TableName: &show.OnTable,
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported SHOW %s", show.Type))
 	}
-	return nil
 }
@@ -1,9 +1,17 @@
 package executor
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/leiysky/a-database/catalog"
+	"github.com/leiysky/a-database/context"
 	"github.com/leiysky/a-database/parser"
+	"github.com/leiysky/a-database/storage"
 	"github.com/leiysky/a-database/util"
 	"github.com/leiysky/go-utils/assert"
 	"github.com/xwb1989/sqlparser"
@@ -61,3 +69,641 @@ func TestExpression(t *testing.T) {
 		assert.Equal(expr.Eval(row), true)
 	}
 }
+
+func TestCompileCreateTable(t *testing.T) {
+	assert := assert.New(t)
+	p := parser.New()
+	stmt := p.Parse(`create table t (a bigint, b int unsigned, c varchar(10), primary key (c, a))`)
+	create := Compile(stmt).(*CreateTable)
+
+	assert.Equal(create.Schema.TableName, "t")
+	assert.Equal(create.Schema.String(), "Int64 a\nUInt32 b\nVarchar c 10\n")
+	assert.Equal(create.Schema.PrimaryKey, []string{"c", "a"})
+
+	stmt = p.Parse(`drop table if exists t`)
+	drop := Compile(stmt).(*DropTable)
+	assert.Equal(drop.TableName.Name.String(), "t")
+	assert.True(drop.IfExists)
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	assert := assert.New(t)
+	schema := &util.Schema{
+		Columns: []*util.Column{
+			{Name: "a", Type: util.ColumnInt32},
+			{Name: "b", Type: util.ColumnInt32},
+		},
+	}
+	row := &util.Row{
+		Schema: schema,
+		Values: []interface{}{1, nil},
+	}
+
+	cases := map[string]interface{}{
+		"b = 1":                    nil,
+		"b <> 1":                   nil,
+		"not b = 1":                nil,
+		"a = 1 and b = 1":          nil,
+		"a = 2 and b = 1":          false,
+		"a = 1 or b = 1":           true,
+		"a = 2 or b = 1":           nil,
+		"b is null":                true,
+		"b is not null":            false,
+		"a is not null":            true,
+		"b = 1 is unknown":         nil,
+		"(b = 1) is not true":      true,
+		"not (a = 2 or b is null)": false,
+		"b = null":                 nil,
+	}
+	for cond, expected := range cases {
+		stmt, err := sqlparser.Parse("select * from t where " + cond)
+		if err != nil {
+			// IS UNKNOWN is not supported by parser
+			continue
+		}
+		expr := rewriteExpr(stmt.(*sqlparser.Select).Where.Expr)
+		assert.Equal(expr.Eval(row), expected)
+		assert.Equal(expr.EvalBool(row), expected == true)
+	}
+}
+
+func TestCompileDefault(t *testing.T) {
+	assert := assert.New(t)
+	p := parser.New()
+	stmt := p.Parse(`create table t (a int primary key, b int default -1, c varchar(4) default 'x', d datetime default now(), e int)`)
+	schema := Compile(stmt).(*CreateTable).Schema
+
+	assert.Equal(defaultValue(nil, schema.Columns[0]), nil)
+	assert.Equal(defaultValue(nil, schema.Columns[1]), -1)
+	assert.Equal(defaultValue(nil, schema.Columns[2]), "x")
+	_, ok := defaultValue(nil, schema.Columns[3]).(util.Date)
+	assert.True(ok)
+	assert.Equal(defaultValue(nil, schema.Columns[4]), nil)
+
+	stmt = p.Parse(`insert into t(b, a) values (1, 2)`)
+	insert := Compile(stmt).(*Insert)
+	assert.Equal(insert.columnOffsets(schema), []int{1, 0})
+	assert.Equal(fillRow(nil, schema, []int{1, 0}, []interface{}{1, 2})[:3], []interface{}{2, 1, "x"})
+}
+
+func TestCompileUnique(t *testing.T) {
+	assert := assert.New(t)
+	p := parser.New()
+	stmt := p.Parse(`create table t (a int primary key, b int unique, c int unique key, unique key c (a, c), unique key pk (a))`)
+	schema := Compile(stmt).(*CreateTable).Schema
+	assert.Equal(schema.Indexes, []*util.Index{
+		{ID: 1, Name: "c", Columns: []string{"a", "c"}},
+		{ID: 2, Name: "b", Columns: []string{"b"}},
+		{ID: 3, Name: "c_2", Columns: []string{"c"}},
+	})
+}
+
+func TestShowCreateTable(t *testing.T) {
+	assert := assert.New(t)
+	p := parser.New()
+	sql := "create table t (id bigint auto_increment primary key, s varchar(8) default 'x', n int not null default -1, " +
+		"d datetime default now(), u int unsigned unique, a int, b int, unique key ab (a, b))"
+	schema := Compile(p.Parse(sql)).(*CreateTable).Schema
+
+	// The statement builds the same table
+	ddl := createTableSQL(schema)
+	replayed := Compile(p.Parse(ddl)).(*CreateTable).Schema
+	assert.Equal(replayed, schema)
+	assert.Equal(createTableSQL(replayed), ddl)
+
+	show := Compile(p.Parse("show create table `t`")).(*ShowCreateTable)
+	assert.Equal(show.TableName.Name.String(), "t")
+	for _, sql := range []string{"describe t", "desc t;", "show columns from t", "show full fields in t"} {
+		desc := Compile(p.Parse(sql)).(*DescribeTable)
+		assert.Equal(desc.TableName.Name.String(), "t")
+	}
+
+	assert.Equal(columnKey(schema, "id"), "PRI")
+	assert.Equal(columnKey(schema, "u"), "UNI")
+	assert.Equal(columnKey(schema, "a"), "MUL")
+	assert.Equal(columnKey(schema, "b"), "")
+	assert.Equal(defaultText(schema.Columns[1]), "x")
+	assert.Equal(defaultText(schema.Columns[3]), "CURRENT_TIMESTAMP")
+}
+
+func TestShowCreateTableReplay(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create table t (id bigint unsigned auto_increment primary key, a tinyint not null, b smallint unsigned, "+
+		"c mediumint default -1, d boolean default true, e tinyint(1), f decimal(6,2), g datetime(3) default now(), "+
+		"h enum('x','y'), i json, j varchar(8) unique, k text)")
+	ddl := execSQL(ctx, "show create table t")[0].Values[1].(string)
+	describe := execSQL(ctx, "describe t")
+
+	execSQL(ctx, "drop table t")
+	execSQL(ctx, ddl)
+	assert.Equal(execSQL(ctx, "show create table t")[0].Values[1], ddl)
+	assert.Equal(execSQL(ctx, "describe t"), describe)
+	assert.True(strings.Contains(ddl, "`a` tinyint NOT NULL, `b` smallint unsigned DEFAULT NULL, `c` mediumint DEFAULT"))
+
+	// Values are in range of the type
+	assert.Equal(execError(ctx, "insert into t (a) values (128)"), "column a: value 128 is out of range of 1-byte integer")
+	assert.Equal(execError(ctx, "insert into t (a, b) values (1, 65536)"), "column b: value 65536 is out of range of 2-byte unsigned integer")
+	execSQL(ctx, "insert into t (a, b) values (-128, 65535)")
+}
+
+func newTestContext() context.Context {
+	store := storage.NewMemStorage()
+	schemas := map[string]map[string]*util.Schema{util.DefaultDatabase: {}}
+	return context.NewSession(context.NewContext(schemas, catalog.NewCatalog(store), store, &context.Options{}))
+}
+
+func execSQL(ctx context.Context, sql string) []*util.Row {
+	return Exec(Compile(parser.New().Parse(sql)), ctx)
+}
+
+// execError returns what executing sql panics with.
+func execError(ctx context.Context, sql string) (err string) {
+	defer func() {
+		err = fmt.Sprint(recover())
+	}()
+	execSQL(ctx, sql)
+	return
+}
+
+func TestInfoSchema(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create table t (id int primary key, name varchar(8) unique)")
+	execSQL(ctx, "create table s (k int primary key)")
+	execSQL(ctx, "insert into t values (1, 'a'), (2, 'b')")
+
+	rows := execSQL(ctx, "select table_name, table_rows from information_schema.tables")
+	assert.Equal(len(rows), 2)
+	assert.Equal(rows[0].Values, []interface{}{"s", util.Int64(0)})
+	assert.Equal(rows[1].Values, []interface{}{"t", util.Int64(2)})
+
+	rows = execSQL(ctx, "select column_name, is_nullable, column_key from information_schema.columns where table_name = 't'")
+	assert.Equal(len(rows), 2)
+	assert.Equal(rows[0].Values, []interface{}{"id", "NO", "PRI"})
+	assert.Equal(rows[1].Values, []interface{}{"name", "YES", "UNI"})
+
+	rows = execSQL(ctx, "select k, index_name from s, information_schema.statistics where column_name = 'name'")
+	assert.Equal(len(rows), 0)
+	execSQL(ctx, "insert into s values (1)")
+	rows = execSQL(ctx, "select k, index_name from s, information_schema.statistics where column_name = 'name'")
+	assert.Equal(len(rows), 1)
+	assert.Equal(rows[0].Values, []interface{}{1, "name"})
+}
+
+func TestDatabases(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create database d")
+	execSQL(ctx, "create database if not exists d")
+	execSQL(ctx, "create table t (id int primary key)")
+	execSQL(ctx, "create table d.t (id int primary key, name varchar(8))")
+	execSQL(ctx, "insert into t values (1)")
+	execSQL(ctx, "insert into d.t values (2, 'a')")
+
+	rows := execSQL(ctx, "show databases")
+	assert.Equal(len(rows), 3)
+	assert.Equal(rows[0].Values, []interface{}{"d"})
+
+	// Tables of the same name don't share rows
+	rows = execSQL(ctx, "select * from t")
+	assert.Equal(len(rows), 1)
+	assert.Equal(rows[0].Values, []interface{}{1})
+	execSQL(ctx, "use d")
+	rows = execSQL(ctx, "select * from t, main.t")
+	assert.Equal(len(rows), 1)
+	assert.Equal(rows[0].Values, []interface{}{2, "a", 1})
+
+	// Columns are told apart by database
+	rows = execSQL(ctx, "select main.t.id, d.t.id from t, main.t where d.t.id = 2")
+	assert.Equal(len(rows), 1)
+	assert.Equal(rows[0].Values, []interface{}{1, 2})
+	assert.Equal(execError(ctx, "select t.id from t, main.t"), "Column t.id is ambiguous")
+
+	rows = execSQL(ctx, "select table_schema, table_name from information_schema.tables")
+	assert.Equal(len(rows), 2)
+	assert.Equal(rows[0].Values, []interface{}{"d", "t"})
+	assert.Equal(rows[1].Values, []interface{}{"main", "t"})
+
+	execSQL(ctx, "drop database d")
+	assert.Equal(ctx.Session().Database(), "")
+	execSQL(ctx, "create database d")
+	execSQL(ctx, "use d")
+	execSQL(ctx, "create table t (id int primary key)")
+	assert.Equal(len(execSQL(ctx, "select * from t")), 0)
+	execSQL(ctx, "drop database if exists x")
+}
+
+func TestNumericComparison(t *testing.T) {
+	assert := assert.New(t)
+	schema := &util.Schema{
+		Columns: []*util.Column{
+			{Name: "i", Type: util.ColumnInt32},
+			{Name: "f", Type: util.ColumnDouble},
+			{Name: "d", Type: util.ColumnDecimal, Precision: 5, Scale: 2},
+		},
+	}
+	row := &util.Row{
+		Schema: schema,
+		Values: []interface{}{1, 0.5, util.Decimal{Unscaled: 150, Scale: 2}},
+	}
+
+	cases := map[string]bool{
+		"1 = 1.1":    false,
+		"1 = 1.0":    true,
+		"i < 1.5":    true,
+		"f = 0.5":    true,
+		"f < -0.5e1": false,
+		"d = 1.5":    true,
+		"d > i":      true,
+		"d < f":      false,
+		"d = '1.50'": true,
+		"-1.5 < i":   true,
+		// Strings are compared with integers as numbers on both sides
+		"'10' > 9":    true,
+		"9 < '10'":    true,
+		"'10.5' > 10": true,
+		"10 = '10.4'": false,
+		"'1e1' = 10":  true,
+		"i = ' 1'":    true,
+	}
+	for cond, expected := range cases {
+		stmt := parser.New().Parse("select * from t where " + cond)
+		expr := rewriteExpr(stmt.(*sqlparser.Select).Where.Expr)
+		assert.Equal(expr.EvalBool(row), expected)
+	}
+
+	create := Compile(parser.New().Parse("create table t (a decimal(5,2) primary key, b float, c double)")).(*CreateTable)
+	col := create.Schema.Columns[0]
+	assert.Equal(col.String(), "Decimal a 5 2")
+	assert.Equal(util.CastColumn(1.005, col), util.Decimal{Unscaled: 101, Scale: 2})
+	ctx := newTestContext()
+	execSQL(ctx, "create table f (id int primary key, v float)")
+	assert.Equal(execError(ctx, "insert into f values (1, 1e40)"), "column v: value 1e+40 is out of range of Float")
+	assert.Equal(execError(ctx, "insert into f values (1, '-1e39')"), "column v: value -1e39 is out of range of Float")
+	execSQL(ctx, "insert into f values (1, 3.4e38)")
+	assert.Equal(columnTypeSQL(col), "decimal(5,2)")
+
+	// Signed and unsigned integers are compared by sign first
+	assert.Equal(tryCompare(uint64(math.MaxUint64), int64(-1)), 1)
+	assert.Equal(tryCompare(int64(-1), uint64(0)), -1)
+	assert.Equal(tryCompare(uint64(3), int64(3)), 0)
+	execSQL(ctx, "create table u (id bigint unsigned primary key)")
+	execSQL(ctx, "insert into u values (0), (18446744073709551615)")
+	assert.Equal(len(execSQL(ctx, "select * from u where id > -1")), 2)
+	assert.Equal(len(execSQL(ctx, "select * from u where id >= -5")), 2)
+	assert.Equal(len(execSQL(ctx, "select * from u where id < -1")), 0)
+	assert.Equal(len(execSQL(ctx, "select * from u where id = -1")), 0)
+}
+
+func TestIntegerRange(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create table t (id bigint primary key, u bigint unsigned, i int)")
+	assert.Equal(execError(ctx, "insert into t values (1, -1, 0)"), "column u: value -1 is out of range of UInt64")
+	assert.Equal(execError(ctx, "insert into t values (9223372036854775808, 0, 0)"),
+		"column id: value 9223372036854775808 is out of range of Int64")
+	assert.Equal(execError(ctx, "insert into t values (1, 0, 'abc')"), "column i: incorrect integer value 'abc'")
+	assert.Equal(execError(ctx, "insert into t values (1, 0, 2147483648)"), "column i: value 2147483648 is out of range of Int32")
+	assert.Equal(len(execSQL(ctx, "select * from t")), 0)
+
+	// Values in range are converted
+	execSQL(ctx, "insert into t values ('-9223372036854775808', 18446744073709551615, ' 12 '), (2, 1.5, 2.4)")
+	rows := execSQL(ctx, "select * from t")
+	assert.Equal(rows[0].Values, []interface{}{int64(math.MinInt64), uint64(math.MaxUint64), 12})
+	assert.Equal(rows[1].Values, []interface{}{int64(2), uint64(2), 2})
+	assert.Equal(len(execSQL(ctx, "select * from t where id = 'abc'")), 0)
+	assert.Equal(len(execSQL(ctx, "select * from t where id > 9223372036854775808")), 0)
+	execSQL(ctx, "create table a (id int auto_increment primary key)")
+	assert.Equal(execError(ctx, "insert into a values ('abc')"), "column id: incorrect integer value 'abc'")
+}
+
+func TestDatetime(t *testing.T) {
+	assert := assert.New(t)
+	defer func(loc *time.Location) { util.TimeZone = loc }(util.TimeZone)
+	util.TimeZone = time.UTC
+
+	dt, _ := util.ParseDate("2024-01-31 10:20:30.5")
+	row := &util.Row{
+		Schema: &util.Schema{
+			Columns: []*util.Column{
+				{Name: "d", Type: util.ColumnDateOnly},
+				{Name: "dt", Type: util.ColumnDatetime, Fsp: 3},
+			},
+		},
+		Values: []interface{}{dt.Day(), dt},
+	}
+	cases := map[string]interface{}{
+		"d":                    "2024-01-31 00:00:00",
+		"d + interval 1 month": "2024-02-29 00:00:00",
+		"date_sub(dt, interval '1 1:30' day_minute)": "2024-01-30 08:50:30.5",
+		"adddate(d, -31)":                       "2023-12-31 00:00:00",
+		"timestamp '2024-01-31 10:20:30.5'":     "2024-01-31 10:20:30.5",
+		"extract(year_month from dt)":           int64(202401),
+		"quarter(d)":                            int64(1),
+		"datediff(dt, '2024-01-01 23:00')":      int64(30),
+		"timestampdiff(month, '2023-12-31', d)": int64(1),
+		"timestampdiff(second, d, dt)":          int64(37230),
+		"unix_timestamp('1970-01-02')":          int64(86400),
+		"unix_timestamp(dt)":                    util.Decimal{Unscaled: 1706696430500000, Scale: 6},
+		"date('2024-02-30')":                    nil,
+	}
+	for expr, expected := range cases {
+		stmt := parser.New().Parse("select " + expr + " from t")
+		v := rewriteExpr(stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr).Eval(row)
+		if d, ok := v.(util.Date); ok {
+			v = d.String()
+		}
+		assert.Equal(v, expected)
+	}
+
+	conds := map[string]bool{
+		"d = '2024-01-31'":             true,
+		"dt > d":                       true,
+		"dt < date '2024-01-31'":       false,
+		"'2024-01-31 10:20:30.5' = dt": true,
+	}
+	for cond, expected := range conds {
+		stmt := parser.New().Parse("select * from t where " + cond)
+		expr := rewriteExpr(stmt.(*sqlparser.Select).Where.Expr)
+		assert.Equal(expr.EvalBool(row), expected)
+	}
+
+	create := Compile(parser.New().Parse("create table t (a date primary key, b datetime(3) default current_timestamp(3), c timestamp)")).(*CreateTable)
+	assert.Equal(create.Schema.String(), "DateOnly a\nDatetime b 3\nTimestamp c 0\n")
+	assert.Equal(columnTypeSQL(create.Schema.Columns[1]), "datetime(3)")
+	assert.Equal(util.CastColumn("2024-01-31 10:20:30.4567", create.Schema.Columns[1]).(util.Date).String(), "2024-01-31 10:20:30.457")
+
+	// Strings which are not dates are refused
+	ctx := newTestContext()
+	execSQL(ctx, "create table d (a date primary key, b datetime)")
+	for _, sql := range []string{
+		"insert into d values ('garbage', null)",
+		"insert into d values ('2020-01-01', '2020-13-45')",
+	} {
+		assert.True(strings.Contains(execError(ctx, sql), "incorrect datetime value"))
+	}
+
+	// A DATE plus days or longer is still a DATE
+	for expr, tp := range map[string]util.ColumnType{
+		"d + interval 1 month":        util.ColumnDateOnly,
+		"date_sub(d, interval 1 day)": util.ColumnDateOnly,
+		"d + interval 1 hour":         util.ColumnDatetime,
+		"dt + interval 1 day":         util.ColumnDatetime,
+	} {
+		stmt := parser.New().Parse("select " + expr + " from t")
+		col, ok := columnOf(rewriteExpr(stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr), row)
+		if !ok {
+			col.Type = util.ColumnDatetime
+		}
+		assert.Equal(col.Type, tp)
+	}
+
+	// All rows of a statement see the same time
+	execSQL(ctx, "create table n (id int primary key, a datetime(6), b datetime(6) default current_timestamp(6))")
+	var values []string
+	for i := 0; i < 50; i++ {
+		values = append(values, fmt.Sprintf("(%d, now(6))", i))
+	}
+	execSQL(ctx, "insert into n (id, a) values "+strings.Join(values, ", "))
+	rows := execSQL(ctx, "select a, b, now(6) from n")
+	assert.Equal(len(rows), 50)
+	for _, r := range rows {
+		assert.Equal(r.Values[0].(util.Date).String(), rows[0].Values[0].(util.Date).String())
+		assert.Equal(r.Values[1].(util.Date).String(), rows[0].Values[1].(util.Date).String())
+		assert.Equal(r.Values[2].(util.Date).String(), rows[0].Values[2].(util.Date).String())
+	}
+}
+
+func TestBoolean(t *testing.T) {
+	assert := assert.New(t)
+	create := Compile(parser.New().Parse("create table t (a int primary key, b boolean default true, c bool not null, d tinyint(1), e tinyint)")).(*CreateTable)
+	assert.Equal(create.Schema.String(), "Int32 a\nBoolean b\nBoolean c\nInt32 d\nInt32 e\n")
+	assert.Equal(*create.Schema.Columns[1].Default, "1")
+	assert.Equal(defaultValue(nil, create.Schema.Columns[1]), true)
+	assert.Equal(columnTypeSQL(create.Schema.Columns[1]), "boolean")
+	quoted := Compile(parser.New().Parse("CREATE TABLE `t` (`id` int NOT NULL, `d` boolean, `e` bool DEFAULT 'a bool', PRIMARY KEY (`id`))")).(*CreateTable)
+	assert.Equal(quoted.Schema.String(), "Int32 id\nBoolean d\nBoolean e\n")
+	assert.Equal(*quoted.Schema.Columns[2].Default, "'a bool'")
+
+	row := &util.Row{
+		Schema: create.Schema,
+		Values: []interface{}{3, true, false, nil, 0},
+	}
+	cases := map[string]interface{}{
+		"a > 2":       true,
+		"b and c":     false,
+		"b or d":      true,
+		"c or d":      nil,
+		"b = 1":       true,
+		"c = false":   true,
+		"(a = 3) = b": true,
+		"not e":       true,
+	}
+	for expr, expected := range cases {
+		stmt := parser.New().Parse("select " + expr + " from t")
+		e := rewriteExpr(stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr)
+		assert.Equal(e.Eval(row), expected)
+	}
+	for cond, expected := range map[string]bool{"b": true, "c": false, "d": false, "a": true, "e": false, "true": true} {
+		stmt := parser.New().Parse("select * from t where " + cond)
+		assert.Equal(rewriteExpr(stmt.(*sqlparser.Select).Where.Expr).EvalBool(row), expected)
+	}
+	assert.Equal(util.Cast("FALSE", util.ColumnBoolean), false)
+	assert.Equal(util.Cast(2, util.ColumnBoolean), true)
+	assert.Equal(util.Cast(true, util.ColumnInt64), int64(1))
+
+	// TINYINT(1) is an integer unless it's declared as BOOL
+	ctx := newTestContext()
+	execSQL(ctx, "create table i (id int primary key, v tinyint(1))")
+	execSQL(ctx, "insert into i values (1, 5)")
+	assert.Equal(execSQL(ctx, "select v from i")[0].Values[0], 5)
+}
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+	create := Compile(parser.New().Parse("create table t (a int primary key, j json)")).(*CreateTable)
+	assert.Equal(columnTypeSQL(create.Schema.Columns[1]), "json")
+	row := &util.Row{
+		Schema: create.Schema,
+		Values: []interface{}{1, util.JSON(`{"n":2,"s":"x","l":[true,{"k":1.5}]}`)},
+	}
+	cases := map[string]interface{}{
+		"j->'$.n'":                           util.JSON("2"),
+		"j->>'$.s'":                          "x",
+		"j->'$.l[*].k'":                      util.JSON("[1.5]"),
+		"j->'$.m'":                           nil,
+		"json_extract(j, '$.n', '$.s')":      util.JSON(`[2,"x"]`),
+		"json_extract('[1,2]', '$[1]')":      util.JSON("2"),
+		"json_type(j->'$.l')":                "ARRAY",
+		"json_valid('{\"a\":}')":             false,
+		"json_array(1, 'a', null, j->'$.n')": util.JSON(`[1,"a",null,2]`),
+		"json_object('k', true)":             util.JSON(`{"k":true}`),
+	}
+	for expr, expected := range cases {
+		stmt := parser.New().Parse("select " + expr + " from t")
+		e := rewriteExpr(stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr)
+		assert.Equal(e.Eval(row), expected)
+	}
+
+	conds := map[string]bool{
+		"j->'$.n' = 2":                      true,
+		"j->'$.l[1].k' > 1":                 true,
+		"j->'$.s' = 'x'":                    true,
+		"j->'$.l[0]'":                       true,
+		"j->'$.l' = '[true, {\"k\": 1.5}]'": true,
+		"j->'$.m' = 1":                      false,
+	}
+	for cond, expected := range conds {
+		stmt := parser.New().Parse("select * from t where " + cond)
+		assert.Equal(rewriteExpr(stmt.(*sqlparser.Select).Where.Expr).EvalBool(row), expected)
+	}
+
+	assert.Equal(util.CastColumn(`{"b":1, "a":2}`, create.Schema.Columns[1]), util.JSON(`{"a":2,"b":1}`))
+	assert.NEqual(util.NewRawBuilder().AppendValue(create.Schema.Columns[1], util.CastColumn("{", create.Schema.Columns[1])), nil)
+}
+
+func TestEnum(t *testing.T) {
+	assert := assert.New(t)
+	create := Compile(parser.New().Parse("create table t (a int primary key, e enum('low', 'it''s high ') default 'low')")).(*CreateTable)
+	col := create.Schema.Columns[1]
+	assert.Equal(col.Elems, []string{"low", "it's high"})
+	assert.Equal(col.String(), `Enum e ["low","it's high"]`)
+	assert.Equal(util.NewColumnFromBytes([]byte(col.String())).Elems, col.Elems)
+	assert.Equal(columnTypeSQL(col), "enum('low','it''s high')")
+
+	high := util.Enum{Index: 2, Elems: col.Elems}
+	assert.Equal(util.CastColumn("IT'S HIGH", col), high)
+	assert.Equal(util.CastColumn(1, col), util.Enum{Index: 1, Elems: col.Elems})
+	assert.Equal(util.CastColumn("none", col), "none")
+	assert.Equal(util.Cast(high, util.ColumnInt64), int64(2))
+	assert.Equal(util.Cast(high, util.ColumnVarchar), "it's high")
+	assert.NEqual(util.NewRawBuilder().AppendValue(col, "none"), nil)
+
+	row := &util.Row{
+		Schema: create.Schema,
+		Values: []interface{}{1, high},
+	}
+	conds := map[string]bool{
+		"e > 'low'":        true,
+		"e = 2":            true,
+		"e = 'it''s high'": true,
+		"e < 'zzz'":        true,
+		"e > 'a'":          true,
+	}
+	for cond, expected := range conds {
+		stmt := parser.New().Parse("select * from t where " + cond)
+		assert.Equal(rewriteExpr(stmt.(*sqlparser.Select).Where.Expr).EvalBool(row), expected)
+	}
+}
+
+func TestStatementSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create table t (id int primary key)")
+	execSQL(ctx, "insert into t values (1)")
+
+	// Tables opened after a write of another statement are still
+	// read as of the start of statement
+	stmt, err := context.NewStatement(ctx)
+	assert.Equal(err, nil)
+	defer stmt.Release()
+	execSQL(ctx, "insert into t values (2)")
+	exec := Compile(parser.New().Parse("select * from t a, t b"))
+	exec.Open(stmt)
+	defer exec.Close()
+	var rows int
+	for exec.Next() != nil {
+		rows++
+	}
+	assert.Equal(rows, 1)
+	assert.Equal(len(execSQL(ctx, "select * from t a, t b")), 4)
+}
+
+func TestAlterTable(t *testing.T) {
+	assert := assert.New(t)
+	ctx := newTestContext()
+	execSQL(ctx, "create table d (id decimal(5,1) primary key, t datetime(2) unique)")
+	execSQL(ctx, "insert into d values (1.5, '2020-01-02 03:04:05.06')")
+
+	// Keys are encoded with precision, scale and fsp
+	assert.Equal(execError(ctx, "alter table d modify id decimal(6,2)"), "Can't modify column id of primary key")
+	assert.Equal(execError(ctx, "alter table d modify t datetime(3)"), "Can't modify column t used by index t")
+	assert.Equal(len(execSQL(ctx, "select * from d where id = 1.5")), 1)
+
+	// IDs are generated after values in existing rows
+	execSQL(ctx, "create table a (id int primary key, v int)")
+	execSQL(ctx, "insert into a values (7, 1)")
+	execSQL(ctx, "alter table a modify id int auto_increment")
+	execSQL(ctx, "insert into a (v) values (2)")
+	assert.Equal(ctx.Session().LastInsertID(), int64(8))
+
+	// Existing rows must satisfy NOT NULL
+	execSQL(ctx, "create table n (id int primary key, v int)")
+	execSQL(ctx, "insert into n values (1, 1), (2, null)")
+	assert.Equal(execError(ctx, "alter table n modify v int not null"), "Invalid use of NULL value")
+	execSQL(ctx, "create table m (id int primary key, v int)")
+	execSQL(ctx, "insert into m values (1, 1)")
+	execSQL(ctx, "alter table m modify v int not null")
+	assert.True(ctx.Schemas(util.DefaultDatabase)["m"].Columns[1].NotNull)
+
+	// Older rows keep the implicit default of an added column
+	execSQL(ctx, "alter table m add x int not null")
+	assert.Equal(execSQL(ctx, "select x from m")[0].Values[0], 0)
+	execSQL(ctx, "alter table m modify x varchar(3)")
+	assert.Equal(execSQL(ctx, "select x from m")[0].Values[0], "0")
+
+	// Column definitions out of grammar are syntax errors
+	assert.True(strings.Contains(execError(ctx, "alter table m add column u int unique default 3"), "syntax error"))
+}
+
+// failingStorage fails every batch written while fail is set.
+type failingStorage struct {
+	storage.Storage
+	fail bool
+}
+
+func (s *failingStorage) Write(batch *storage.WriteBatch) error {
+	if s.fail {
+		return fmt.Errorf("write failed")
+	}
+	return s.Storage.Write(batch)
+}
+
+func TestAtomicStatements(t *testing.T) {
+	assert := assert.New(t)
+	store := &failingStorage{Storage: storage.NewMemStorage()}
+	schemas := map[string]map[string]*util.Schema{util.DefaultDatabase: {}}
+	ctx := context.NewSession(context.NewContext(schemas, catalog.NewCatalog(store), store, &context.Options{}))
+	execSQL(ctx, "create database d")
+	execSQL(ctx, "create table d.t (id int primary key, v int)")
+	execSQL(ctx, "insert into d.t values (1, 1), (2, 2)")
+
+	// Nothing is changed by a statement failing to commit
+	store.fail = true
+	for _, sql := range []string{
+		"insert into d.t values (3, 3), (4, 4)",
+		"alter table d.t add u int unique",
+		"drop table d.t",
+		"drop database d",
+	} {
+		assert.Equal(execError(ctx, sql), "write failed")
+	}
+	store.fail = false
+	assert.Equal(len(execSQL(ctx, "select * from d.t")), 2)
+	assert.Equal(len(ctx.Schemas("d")["t"].Indexes), 0)
+
+	// Rows and definitions are gone together
+	execSQL(ctx, "alter table d.t add u int unique")
+	execSQL(ctx, "drop database d")
+	itr := store.ScanAll()
+	for itr.Next() {
+		assert.False(bytes.HasPrefix(itr.Key(), util.CatalogKey("d", "")))
+		assert.False(bytes.HasPrefix(itr.Key(), util.DatabaseKey("d")))
+	}
+	itr.Release()
+	low, up := util.DatabaseRange("d")
+	itr = store.Scan(low, up)
+	assert.False(itr.Next())
+	itr.Release()
+}
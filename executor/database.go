@@ -0,0 +1,171 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// currentDatabase returns the database selected by USE.
+func currentDatabase(ctx context.Context) string {
+	db := ctx.Session().Database()
+	if db == "" {
+		panic("no database selected")
+	}
+	return db
+}
+
+// databaseOf returns the database table name belongs to, which is
+// the current one if it's not qualified.
+func databaseOf(ctx context.Context, name *sqlparser.TableName) string {
+	db := name.Qualifier.String()
+	if db == "" {
+		db = currentDatabase(ctx)
+	}
+	if strings.ToLower(db) == InfoSchema {
+		return InfoSchema
+	}
+	return db
+}
+
+// writableDatabase is databaseOf, but it refuses information_schema.
+func writableDatabase(ctx context.Context, name *sqlparser.TableName) string {
+	db := databaseOf(ctx, name)
+	if db == InfoSchema {
+		panic(fmt.Sprintf("access denied to database %s", InfoSchema))
+	}
+	if ctx.Schemas(db) == nil {
+		panic(fmt.Sprintf("database %s doesn't exist", db))
+	}
+	return db
+}
+
+func lookupTable(ctx context.Context, name *sqlparser.TableName) *util.Schema {
+	db := databaseOf(ctx, name)
+	table := name.Name.String()
+	tables := ctx.Schemas(db)
+	if db == InfoSchema {
+		tables, table = infoSchemaTableMap, strings.ToLower(table)
+	} else if tables == nil {
+		panic(fmt.Sprintf("database %s doesn't exist", db))
+	}
+	schema := tables[table]
+	if schema == nil {
+		panic(fmt.Sprintf("table %s doesn't exist", name.Name.String()))
+	}
+	return schema
+}
+
+// writableTable is lookupTable, but it refuses tables of information_schema.
+func writableTable(ctx context.Context, name *sqlparser.TableName) *util.Schema {
+	writableDatabase(ctx, name)
+	return lookupTable(ctx, name)
+}
+
+type CreateDatabase struct {
+	baseExecutor
+
+	Database    string
+	IfNotExists bool
+}
+
+func (e *CreateDatabase) Open(ctx context.Context) {
+	e.ctx = ctx
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	if strings.ToLower(e.Database) == InfoSchema {
+		panic(fmt.Sprintf("access denied to database %s", InfoSchema))
+	}
+	if ctx.Schemas(e.Database) != nil {
+		if e.IfNotExists {
+			return
+		}
+		panic(fmt.Sprintf("database %s already exists", e.Database))
+	}
+	if err := ctx.CreateDatabase(nil, e.Database); err != nil {
+		panic(err)
+	}
+}
+
+type DropDatabase struct {
+	baseExecutor
+
+	Database string
+	IfExists bool
+}
+
+func (e *DropDatabase) Open(ctx context.Context) {
+	e.ctx = ctx
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	switch {
+	case strings.ToLower(e.Database) == InfoSchema:
+		panic(fmt.Sprintf("access denied to database %s", InfoSchema))
+	case e.Database == util.DefaultDatabase:
+		panic(fmt.Sprintf("can't drop database %s", util.DefaultDatabase))
+	}
+	if ctx.Schemas(e.Database) == nil && e.IfExists {
+		return
+	}
+	// Rows are deleted with definitions
+	batch := new(storage.WriteBatch)
+	low, up := util.DatabaseRange(e.Database)
+	if err := storage.BatchDeleteRange(ctx.Store(), batch, low, up); err != nil {
+		panic(err)
+	}
+	if err := ctx.DropDatabase(batch, e.Database); err != nil {
+		panic(err)
+	}
+	if ctx.Session().Database() == e.Database {
+		ctx.Session().Use("")
+	}
+}
+
+// Use changes the current database of session.
+type Use struct {
+	baseExecutor
+
+	Database string
+}
+
+func (e *Use) Open(ctx context.Context) {
+	e.ctx = ctx
+	db := e.Database
+	if strings.ToLower(db) == InfoSchema {
+		db = InfoSchema
+	} else if ctx.Schemas(db) == nil {
+		panic(fmt.Sprintf("database %s doesn't exist", db))
+	}
+	ctx.Session().Use(db)
+}
+
+type ShowDatabases struct {
+	staticRows
+}
+
+func (e *ShowDatabases) Open(ctx context.Context) {
+	e.ctx = ctx
+	dbs := append(ctx.Databases(), InfoSchema)
+	sort.Strings(dbs)
+	schema := &util.Schema{
+		Columns: []*util.Column{{
+			Type: util.ColumnFixedString,
+			Name: "database",
+		}},
+	}
+	for _, db := range dbs {
+		e.rows = append(e.rows, &util.Row{
+			Schema: schema,
+			Values: []interface{}{db},
+		})
+	}
+}
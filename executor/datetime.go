@@ -0,0 +1,464 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// DateFunc is a function on temporal values. NULL or invalid dates
+// in arguments make the result NULL.
+type DateFunc struct {
+	baseExpression
+
+	Name string
+	Args []Expression
+	// Unit of INTERVAL, EXTRACT and TIMESTAMPDIFF
+	Unit string
+	// Sign of INTERVAL, -1 for DATE_SUB
+	Sign int
+
+	ctx context.Context
+}
+
+// microsOfUnit is the length of units of fixed length.
+var microsOfUnit = map[string]int64{
+	"microsecond": 1,
+	"second":      1e6,
+	"minute":      60e6,
+	"hour":        3600e6,
+	"day":         86400e6,
+	"week":        7 * 86400e6,
+}
+
+// fieldsOfUnit is the fields of value of INTERVAL in each unit,
+// e.g. '1:30' HOUR_MINUTE is 1 hour and 30 minutes.
+var fieldsOfUnit = map[string][]string{
+	"microsecond":        {"microsecond"},
+	"second":             {"second"},
+	"minute":             {"minute"},
+	"hour":               {"hour"},
+	"day":                {"day"},
+	"week":               {"week"},
+	"month":              {"month"},
+	"quarter":            {"quarter"},
+	"year":               {"year"},
+	"second_microsecond": {"second", "microsecond"},
+	"minute_microsecond": {"minute", "second", "microsecond"},
+	"minute_second":      {"minute", "second"},
+	"hour_microsecond":   {"hour", "minute", "second", "microsecond"},
+	"hour_second":        {"hour", "minute", "second"},
+	"hour_minute":        {"hour", "minute"},
+	"day_microsecond":    {"day", "hour", "minute", "second", "microsecond"},
+	"day_second":         {"day", "hour", "minute", "second"},
+	"day_minute":         {"day", "hour", "minute"},
+	"day_hour":           {"day", "hour"},
+	"year_month":         {"year", "month"},
+}
+
+// dateUnits is the units of INTERVAL without parts of time.
+var dateUnits = map[string]bool{
+	"day":        true,
+	"week":       true,
+	"month":      true,
+	"quarter":    true,
+	"year":       true,
+	"year_month": true,
+}
+
+var extractUnits = map[string]bool{
+	"microsecond": true,
+	"second":      true,
+	"minute":      true,
+	"hour":        true,
+	"day":         true,
+	"week":        true,
+	"month":       true,
+	"quarter":     true,
+	"year":        true,
+	"year_month":  true,
+	"dayofweek":   true,
+	"dayofyear":   true,
+}
+
+func rewriteDateFunc(name string, args []sqlparser.Expr) Expression {
+	e := &DateFunc{Name: name, Sign: 1}
+	switch name {
+	case "curdate", "current_date", "utc_date":
+		checkArgs(name, args, 0, 0)
+	case "date", "timestamp", "from_unixtime":
+		checkArgs(name, args, 1, 1)
+	case "unix_timestamp":
+		checkArgs(name, args, 0, 1)
+	case "datediff":
+		checkArgs(name, args, 2, 2)
+	case "convert_tz":
+		checkArgs(name, args, 3, 3)
+	case "year", "quarter", "month", "week", "day", "dayofmonth", "hour",
+		"minute", "second", "microsecond", "dayofweek", "dayofyear":
+		checkArgs(name, args, 1, 1)
+		e.Name = "extract"
+		e.Unit = strings.TrimPrefix(name, "dayof")
+		if name == "dayofweek" || name == "dayofyear" {
+			e.Unit = name
+		}
+	case "extract", "timestampdiff":
+		if name == "extract" {
+			checkArgs(name, args, 2, 2)
+		} else {
+			checkArgs(name, args, 3, 3)
+		}
+		unit, ok := args[0].(*sqlparser.ColName)
+		if !ok {
+			panic(fmt.Sprintf("Invalid unit of %s", strings.ToUpper(name)))
+		}
+		e.Unit = unit.Name.Lowered()
+		if name == "extract" && !extractUnits[e.Unit] ||
+			name == "timestampdiff" && fieldsOfUnit[e.Unit] == nil {
+			panic(fmt.Sprintf("Unsupported unit %s", strings.ToUpper(e.Unit)))
+		}
+		args = args[1:]
+	case "date_add", "adddate", "date_sub", "subdate":
+		checkArgs(name, args, 2, 2)
+		e.Name = "date_add"
+		if name == "date_sub" || name == "subdate" {
+			e.Sign = -1
+		}
+		e.Unit = "day"
+		if interval, ok := args[1].(*sqlparser.IntervalExpr); ok {
+			e.Unit = strings.ToLower(interval.Unit)
+			args = []sqlparser.Expr{args[0], interval.Expr}
+		}
+		if fieldsOfUnit[e.Unit] == nil {
+			panic(fmt.Sprintf("Unsupported unit %s", strings.ToUpper(e.Unit)))
+		}
+	default:
+		return nil
+	}
+	for _, arg := range args {
+		e.Args = append(e.Args, rewriteExpr(arg))
+	}
+	return e
+}
+
+// rewriteIntervalExpr rewrites `date + INTERVAL n unit` and
+// `date - INTERVAL n unit`, nil is returned for other arithmetic.
+func rewriteIntervalExpr(expr *sqlparser.BinaryExpr) Expression {
+	left, right := expr.Left, expr.Right
+	if _, ok := left.(*sqlparser.IntervalExpr); ok && expr.Operator == sqlparser.PlusStr {
+		left, right = right, left
+	}
+	interval, ok := right.(*sqlparser.IntervalExpr)
+	if !ok {
+		return nil
+	}
+	name := "date_add"
+	switch expr.Operator {
+	case sqlparser.PlusStr:
+	case sqlparser.MinusStr:
+		name = "date_sub"
+	default:
+		return nil
+	}
+	return rewriteDateFunc(name, []sqlparser.Expr{left, interval})
+}
+
+func checkArgs(name string, args []sqlparser.Expr, min, max int) {
+	if len(args) < min || len(args) > max {
+		panic(fmt.Sprintf("Incorrect parameter count in the call to %s", strings.ToUpper(name)))
+	}
+}
+
+// funcArgs returns the arguments of function call expr.
+func funcArgs(expr *sqlparser.FuncExpr) []sqlparser.Expr {
+	var args []sqlparser.Expr
+	for _, arg := range expr.Exprs {
+		aliased, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			panic(fmt.Sprintf("Invalid argument of %s", expr.Name.String()))
+		}
+		args = append(args, aliased.Expr)
+	}
+	return args
+}
+
+// fspOf returns the precision given to functions like NOW(3).
+func fspOf(name string, args []sqlparser.Expr) int {
+	checkArgs(name, args, 0, 1)
+	if len(args) == 0 {
+		return 0
+	}
+	val, ok := args[0].(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.IntVal {
+		panic(fmt.Sprintf("Invalid precision of %s", strings.ToUpper(name)))
+	}
+	fsp, _ := strconv.Atoi(string(val.Val))
+	if fsp > util.MaxFsp {
+		panic(fmt.Sprintf("Too big precision %d specified for %s, maximum is %d", fsp, strings.ToUpper(name), util.MaxFsp))
+	}
+	return fsp
+}
+
+func (e *DateFunc) resultColumn(row *util.Row) (util.Column, bool) {
+	switch e.Name {
+	case "curdate", "current_date", "utc_date", "date":
+		return util.Column{Type: util.ColumnDateOnly}, true
+	case "date_add":
+		// A DATE plus days or longer is still a DATE
+		if col, ok := columnOf(e.Args[0], row); ok && col.Type == util.ColumnDateOnly && dateUnits[e.Unit] {
+			return util.Column{Type: util.ColumnDateOnly}, true
+		}
+		return util.Column{}, false
+	default:
+		return util.Column{}, false
+	}
+}
+
+// isConstant tells whether result of e only depends on its arguments,
+// which are all literals.
+func (e *DateFunc) isConstant() bool {
+	switch e.Name {
+	case "curdate", "current_date", "utc_date":
+		return false
+	case "unix_timestamp":
+		if len(e.Args) == 0 {
+			return false
+		}
+	}
+	for _, arg := range e.Args {
+		if _, ok := constantValue(arg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *DateFunc) Eval(row *util.Row) interface{} {
+	args := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		if args[i] = arg.Eval(row); args[i] == nil {
+			return nil
+		}
+	}
+	now := statementTime(e.ctx)
+	switch e.Name {
+	case "curdate", "current_date":
+		return util.Date(now).Day()
+	case "utc_date":
+		return util.FromWallClock(now.UTC(), util.TimeZone).Day()
+	case "from_unixtime":
+		return util.Cast(args[0], util.ColumnDate)
+	case "unix_timestamp":
+		if len(args) == 0 {
+			return now.Unix()
+		}
+		dt, ok := toDate(args[0])
+		if !ok {
+			return nil
+		}
+		if micros := dt.Micros(); micros%1e6 != 0 {
+			return util.Decimal{Unscaled: micros, Scale: util.MaxFsp}
+		}
+		return dt.Timestamp()
+	}
+
+	// Arguments after them are not dates
+	n := len(args)
+	if e.Name == "date_add" || e.Name == "convert_tz" {
+		n = 1
+	}
+	dates := make([]util.Date, n)
+	for i := range dates {
+		var ok bool
+		if dates[i], ok = toDate(args[i]); !ok {
+			return nil
+		}
+	}
+	switch e.Name {
+	case "date":
+		return dates[0].Day()
+	case "timestamp":
+		return dates[0]
+	case "datediff":
+		return (dates[0].Day().WallMicros() - dates[1].Day().WallMicros()) / microsOfUnit["day"]
+	case "extract":
+		return extract(dates[0], e.Unit)
+	case "timestampdiff":
+		return timestampDiff(dates[0], dates[1], e.Unit)
+	case "date_add":
+		months, micros, ok := parseInterval(args[1], e.Unit)
+		if !ok {
+			return nil
+		}
+		return addInterval(dates[0], int64(e.Sign)*months, int64(e.Sign)*micros)
+	case "convert_tz":
+		from, ferr := util.ParseTimeZone(util.Cast(args[1], util.ColumnFixedString).(string))
+		to, terr := util.ParseTimeZone(util.Cast(args[2], util.ColumnFixedString).(string))
+		if ferr != nil || terr != nil {
+			return nil
+		}
+		in := util.FromWallClock(wallClock(dates[0]), from)
+		return util.FromWallClock(time.Time(in).In(to), util.TimeZone)
+	default:
+		return nil
+	}
+}
+
+func (e *DateFunc) EvalBool(row *util.Row) bool {
+	return truth(e.Eval(row)) == true
+}
+
+// toDate converts v into a Date, ok is false if v isn't a valid date.
+func toDate(v interface{}) (util.Date, bool) {
+	switch value := v.(type) {
+	case util.Date:
+		return value, true
+	case string:
+		dt, err := util.ParseDate(value)
+		return dt, err == nil
+	default:
+		return util.Date{}, false
+	}
+}
+
+// wallClock returns the time shown by wall clock in TimeZone at dt.
+func wallClock(dt util.Date) time.Time {
+	return time.Time(dt).In(util.TimeZone)
+}
+
+func extract(dt util.Date, unit string) int64 {
+	t := wallClock(dt)
+	switch unit {
+	case "microsecond":
+		return int64(t.Nanosecond() / 1e3)
+	case "second":
+		return int64(t.Second())
+	case "minute":
+		return int64(t.Minute())
+	case "hour":
+		return int64(t.Hour())
+	case "day":
+		return int64(t.Day())
+	case "week":
+		_, week := t.ISOWeek()
+		return int64(week)
+	case "month":
+		return int64(t.Month())
+	case "quarter":
+		return int64(t.Month()+2) / 3
+	case "year":
+		return int64(t.Year())
+	case "year_month":
+		return int64(t.Year())*100 + int64(t.Month())
+	case "dayofweek":
+		// 1 is Sunday
+		return int64(t.Weekday()) + 1
+	default:
+		return int64(t.YearDay())
+	}
+}
+
+// parseInterval parses value of INTERVAL in unit into months and
+// microseconds. A number is the value of the last field of unit,
+// a string like '1:30' or '-1 2' gives values of all fields.
+func parseInterval(v interface{}, unit string) (months, micros int64, ok bool) {
+	fields := fieldsOfUnit[unit]
+	var values []int64
+	neg := false
+	switch value := v.(type) {
+	case string:
+		s := strings.TrimSpace(value)
+		neg = strings.HasPrefix(s, "-")
+		parts := strings.FieldsFunc(strings.TrimLeft(s, "+-"), func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if len(parts) == 0 || len(parts) > len(fields) {
+			return 0, 0, false
+		}
+		for _, p := range parts {
+			n, err := strconv.ParseInt(p, 10, 64)
+			if err != nil {
+				return 0, 0, false
+			}
+			values = append(values, n)
+		}
+	default:
+		if len(fields) == 1 && unit == "second" {
+			// Fractional seconds are kept
+			f := util.Cast(v, util.ColumnDouble).(float64)
+			return 0, int64(math.Round(f * 1e6)), true
+		}
+		n := util.Cast(v, util.ColumnInt64).(int64)
+		neg = n < 0
+		if neg {
+			n = -n
+		}
+		values = []int64{n}
+	}
+	// Values are aligned to the last fields
+	fields = fields[len(fields)-len(values):]
+	for i, f := range fields {
+		switch f {
+		case "year":
+			months += values[i] * 12
+		case "quarter":
+			months += values[i] * 3
+		case "month":
+			months += values[i]
+		default:
+			micros += values[i] * microsOfUnit[f]
+		}
+	}
+	if neg {
+		months, micros = -months, -micros
+	}
+	return months, micros, true
+}
+
+// addInterval adds months and microseconds to wall clock of dt, the
+// day is clamped to the end of month, e.g. 01-31 + 1 month is 02-28.
+func addInterval(dt util.Date, months, micros int64) util.Date {
+	t := wallClock(dt)
+	if months != 0 {
+		total := int64(t.Year())*12 + int64(t.Month()) - 1 + months
+		year, month := int(total/12), time.Month(total%12+1)
+		if total%12 < 0 {
+			year, month = year-1, month+12
+		}
+		day := t.Day()
+		if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+			day = last
+		}
+		t = time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), util.TimeZone)
+	}
+	return util.DateFromWallMicros(util.Date(t).WallMicros() + micros)
+}
+
+// timestampDiff returns b - a in whole units.
+func timestampDiff(a, b util.Date, unit string) int64 {
+	if size, ok := microsOfUnit[unit]; ok {
+		return (b.WallMicros() - a.WallMicros()) / size
+	}
+	ta, tb := wallClock(a), wallClock(b)
+	months := int64(tb.Year()-ta.Year())*12 + int64(tb.Month()-ta.Month())
+	// The last month isn't complete
+	if end := addInterval(a, months, 0); months > 0 && tryCompare(end, b) > 0 {
+		months--
+	} else if months < 0 && tryCompare(end, b) < 0 {
+		months++
+	}
+	switch unit {
+	case "quarter":
+		return months / 3
+	case "year":
+		return months / 12
+	default:
+		return months
+	}
+}
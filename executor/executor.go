@@ -1,9 +1,14 @@
 package executor
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
 
 	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/parser"
 	"github.com/leiysky/a-database/util"
 
 	"github.com/leiysky/a-database/storage"
@@ -13,10 +18,21 @@ import (
 var (
 	_ Executor = &Selection{}
 	_ Executor = &Projection{}
+	_ Executor = &Dual{}
 	_ Executor = &Limit{}
 	_ Executor = &TableScan{}
 	_ Executor = &Insert{}
 	_ Executor = &Join{}
+	_ Executor = &CreateTable{}
+	_ Executor = &AlterTable{}
+	_ Executor = &DropTable{}
+	_ Executor = &DescribeTable{}
+	_ Executor = &ShowCreateTable{}
+	_ Executor = &InfoSchemaScan{}
+	_ Executor = &CreateDatabase{}
+	_ Executor = &DropDatabase{}
+	_ Executor = &Use{}
+	_ Executor = &ShowDatabases{}
 )
 
 func Compile(stmt sqlparser.Statement) Executor {
@@ -31,13 +47,27 @@ func compile(stmt sqlparser.Statement) Executor {
 		return compileInsert(v)
 	case *sqlparser.Show:
 		return compileShow(v)
+	case *sqlparser.DDL:
+		return compileDDL(v)
+	case *parser.AlterTable:
+		return compileAlterTable(v)
+	case *sqlparser.DBDDL:
+		return compileDBDDL(v)
+	case *sqlparser.Use:
+		return &Use{Database: v.DBName.String()}
 	default:
 		panic("Unknown AST")
 	}
 }
 
 func Exec(exec Executor, ctx context.Context) []*util.Row {
-	exec.Open(ctx)
+	stmt, err := context.NewStatement(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Release()
+	defer exec.Close()
+	exec.Open(stmt)
 	var results []*util.Row
 	for {
 		r := exec.Next()
@@ -89,18 +119,30 @@ func (e *Selection) Next() *util.Row {
 		if r == nil {
 			return nil
 		}
-		if e.predicate.Eval(r) == true {
+		if e.predicate.EvalBool(r) {
 			return r
 		}
 	}
 }
 
+func (e *Selection) Open(ctx context.Context) {
+	e.baseExecutor.Open(ctx)
+	bindContext(e.predicate, ctx)
+}
+
 type Projection struct {
 	baseExecutor
 
-	// Project column from `from[i]` to `to[i]`
-	from []*sqlparser.ColName
-	to   []*sqlparser.ColName
+	// Column `names[i]` of result is `exprs[i]`
+	exprs []Expression
+	names []string
+}
+
+func (e *Projection) Open(ctx context.Context) {
+	e.baseExecutor.Open(ctx)
+	for _, expr := range e.exprs {
+		bindContext(expr, ctx)
+	}
 }
 
 func (e *Projection) Next() *util.Row {
@@ -113,11 +155,21 @@ func (e *Projection) Next() *util.Row {
 	}
 	var cols []*util.Column
 	var vals []interface{}
-	for i, c := range e.from {
-		col, offset := row.Schema.GetColumnByName(c.Name.Lowered())
-		col.Name = e.to[i].Name.Lowered()
+	for i, expr := range e.exprs {
+		v := expr.Eval(row)
+		var col util.Column
+		if c, ok := expr.(*ColumnValue); ok {
+			col = *row.Schema.Columns[columnOffset(row, c.Name)]
+		} else if t, ok := expr.(typedExpression); ok && v != nil {
+			if col, ok = t.resultColumn(row); !ok {
+				col.Type = typeOfValue(v)
+			}
+		} else {
+			col.Type = typeOfValue(v)
+		}
+		col.Name = e.names[i]
 		cols = append(cols, &col)
-		vals = append(vals, row.Values[offset])
+		vals = append(vals, v)
 	}
 	schema.Columns = cols
 	row.Values = vals
@@ -125,30 +177,132 @@ func (e *Projection) Next() *util.Row {
 	return row
 }
 
+// Dual is the table of `SELECT` without `FROM`, which has one empty row.
+type Dual struct {
+	baseExecutor
+
+	done bool
+}
+
+func (e *Dual) Next() *util.Row {
+	if e.done {
+		return nil
+	}
+	e.done = true
+	return &util.Row{
+		Schema: &util.Schema{TableName: "dual"},
+	}
+}
+
 type TableScan struct {
 	baseExecutor
 
 	table  *sqlparser.TableName
 	itr    storage.Iterator
 	schema *util.Schema
+	// filter is the WHERE clause on this table, it's only used to
+	// narrow down the scan and rows are still checked by Selection.
+	filter Expression
+	// infoSchema reads the table instead if it's in information_schema
+	infoSchema *InfoSchemaScan
 }
 
 func (e *TableScan) Open(ctx context.Context) {
 	e.ctx = ctx
-	prefix := []byte(e.table.Name.String() + ":")
-	prefixBound := []byte(e.table.Name.String() + ":")
-	prefixBound[len(prefixBound)-1]++
-	e.itr = e.ctx.Store().Scan(prefix, prefixBound)
-	e.schema = e.ctx.Schemas()[e.table.Name.String()]
-	if e.schema == nil {
-		panic("Invalid context")
+	if databaseOf(ctx, e.table) == InfoSchema {
+		e.infoSchema = &InfoSchemaScan{table: e.table}
+		e.infoSchema.Open(ctx)
+		return
+	}
+	e.schema = lookupTable(ctx, e.table)
+	// Tables of a statement, like both sides of a join, are read
+	// from the same snapshot
+	e.itr = ctx.Snapshot().Scan(e.scanRange())
+}
+
+// scanRange narrows down the scan with conditions on primary key
+// columns in filter. Since keys are memcomparable, rows matching
+// `pk1 = 1 and pk2 > 2` are all in [enc(1) enc(2)+1, enc(1)+1).
+func (e *TableScan) scanRange() (low, up []byte) {
+	conds := make(map[string][]*keyCond)
+	collectKeyConds(e.filter, conds)
+
+	prefix := util.RowPrefix(e.schema.Database, e.schema.TableName)
+	for _, name := range e.schema.PrimaryKey {
+		col, _ := e.schema.GetColumnByName(name)
+		var eq interface{}
+		for _, c := range conds[name] {
+			if v, exact := keyValue(c.val, &col); c.op == OpEq && exact {
+				eq = v
+			}
+		}
+		if eq == nil {
+			return rangeOfConds(prefix, conds[name], &col)
+		}
+		prefix = util.EncodeKey(prefix, []interface{}{eq}, []*util.Column{&col})
+	}
+	return prefix, util.PrefixEnd(prefix)
+}
+
+// keyValue casts v into the type of key column c, exact is false if
+// the type can't hold v, e.g. 1.5 for an integer column or -1 for an
+// unsigned one. Such values are not used to narrow down the scan.
+func keyValue(v interface{}, c *util.Column) (key interface{}, exact bool) {
+	key = util.CastColumn(v, c)
+	if _, ok := key.(string); ok && (c.Type.IsTemporal() || c.Type == util.ColumnEnum) {
+		// Casting failed
+		return key, false
+	}
+	if _, ok := util.CastInteger(v, c.Type); c.Type.IsInteger() && !ok {
+		return key, false
+	}
+	return key, tryCompare(key, v) == 0
+}
+
+func rangeOfConds(prefix []byte, conds []*keyCond, col *util.Column) (low, up []byte) {
+	low, up = prefix, util.PrefixEnd(prefix)
+	for _, c := range conds {
+		v, exact := keyValue(c.val, col)
+		if !exact {
+			continue
+		}
+		key := util.EncodeKey(prefix, []interface{}{v}, []*util.Column{col})
+		switch c.op {
+		case OpGt:
+			key = util.PrefixEnd(key)
+			fallthrough
+		case OpGe:
+			if bytes.Compare(key, low) > 0 {
+				low = key
+			}
+		case OpLe:
+			key = util.PrefixEnd(key)
+			fallthrough
+		case OpLt:
+			if bytes.Compare(key, up) < 0 {
+				up = key
+			}
+		}
+	}
+	return low, up
+}
+
+func (e *TableScan) Close() {
+	if e.itr != nil {
+		e.itr.Release()
 	}
 }
 
 func (e *TableScan) Next() *util.Row {
+	if e.infoSchema != nil {
+		return e.infoSchema.Next()
+	}
 	if e.itr.Next() {
-		raw := e.itr.Value()
-		return util.ReadRow(raw, e.schema)
+		row, err := util.ReadRow(e.itr.Key(), e.itr.Value(), e.schema)
+		if err != nil {
+			panic(err)
+		}
+		return row
 	}
 	return nil
 }
@@ -206,71 +360,462 @@ func (e *Join) Open(ctx context.Context) {
 	}
 }
 
+func (e *Join) Next() *util.Row {
+	if len(e.rowBuff) == 0 {
+		return nil
+	}
+	r := e.rowBuff[0]
+	e.rowBuff = e.rowBuff[1:]
+	return r
+}
+
 func join(l, r *util.Row) *util.Row {
 	newSchema := &util.Schema{
 		TableName: fmt.Sprintf("(%s,%s)", l.Schema.TableName, r.Schema.TableName),
 	}
-	var newCols []*util.Column
-	for _, c := range l.Schema.Columns {
-		newC := &util.Column{
-			Type:   c.Type,
-			Name:   fmt.Sprintf("%s.%s", l.Schema.TableName, c.Name),
-			Strlen: c.Strlen,
-		}
-		newCols = append(newCols, newC)
-	}
-
-	for _, c := range r.Schema.Columns {
-		newC := &util.Column{
-			Type:   c.Type,
-			Name:   fmt.Sprintf("%s.%s", r.Schema.TableName, c.Name),
-			Strlen: c.Strlen,
-		}
-		newCols = append(newCols, newC)
-	}
-	newSchema.Columns = newCols
+	newSchema.Columns = append(joinColumns(l.Schema), joinColumns(r.Schema)...)
 
+	values := make([]interface{}, 0, len(l.Values)+len(r.Values))
 	return &util.Row{
 		Schema: newSchema,
-		Values: append(l.Values, r.Values...),
+		Values: append(append(values, l.Values...), r.Values...),
 	}
 }
 
+// joinColumns qualifies names of columns with the database and table
+// name, columns of a joined row are qualified already.
+func joinColumns(schema *util.Schema) []*util.Column {
+	var cols []*util.Column
+	for _, c := range schema.Columns {
+		newC := *c
+		if !strings.Contains(c.Name, ".") {
+			newC.Name = fmt.Sprintf("%s.%s.%s", schema.Database, schema.TableName, c.Name)
+		}
+		cols = append(cols, &newC)
+	}
+	return cols
+}
+
 type Insert struct {
 	baseExecutor
 
 	TableName *sqlparser.TableName
-	Keys      []string
-	Values    []*util.Row
+	// Names of columns values are given for, all columns in
+	// order of schema if empty
+	Columns []string
+	Keys    [][]byte
+	// Rows of values, functions among them are Expressions
+	Values []*util.Row
 }
 
 func (e *Insert) Open(ctx context.Context) {
 	e.ctx = ctx
-	schema := ctx.Schemas()[e.TableName.Name.String()]
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	schema := writableTable(ctx, e.TableName)
+	offsets := e.columnOffsets(schema)
+	store := ctx.Store()
+	b := util.NewRawBuilder()
+	// Check and encode every row before writing any of them
+	checker := newUniqueChecker(store, schema)
+	auto := schema.AutoIncrementColumn()
+	var firstID util.Int64
+	var raws [][]byte
+	var entries [][]byte
+	var entryValues [][]byte
 	for _, r := range e.Values {
+		if len(r.Values) != len(offsets) {
+			panic("Column count doesn't match value count")
+		}
+		for i, v := range r.Values {
+			if expr, ok := v.(Expression); ok {
+				bindContext(expr, ctx)
+				r.Values[i] = expr.Eval(nil)
+			}
+		}
+		r.Values = fillRow(ctx, schema, offsets, r.Values)
 		r.Schema = schema
-		e.Keys = append(e.Keys, util.GenerateKey(int64(r.Values[0].(int)), e.TableName.Name.String()))
+		if auto >= 0 {
+			id := e.autoIncrement(ctx, schema, r, auto)
+			if firstID == 0 {
+				firstID = id
+			}
+		}
+		for i, c := range schema.Columns {
+			r.Values[i] = util.CastColumn(r.Values[i], c)
+			if r.Values[i] == nil && c.NotNull {
+				panic(fmt.Sprintf("column %s cannot be null", c.Name))
+			}
+		}
+		// Invalid values are refused before keys are built of them
+		raws = append(raws, BuildRaw(b, r))
+		b.Reset()
+		key, keys := checker.check(r)
+		for _, entry := range keys {
+			entries = append(entries, entry)
+			entryValues = append(entryValues, key)
+		}
+		e.Keys = append(e.Keys, key)
 	}
 
-	b := util.NewRawBuilder()
+	// All rows and index entries are written at once
+	batch := new(storage.WriteBatch)
+	for i := range entries {
+		batch.Put(entries[i], entryValues[i])
+	}
 	for i := range e.Keys {
-		store := ctx.Store()
-		store.Put([]byte(e.Keys[i]), BuildRaw(b, e.Values[i]))
+		batch.Put(e.Keys[i], raws[i])
+	}
+	if err := store.Write(batch); err != nil {
+		panic(err)
+	}
+	if firstID != 0 {
+		ctx.Session().SetLastInsertID(firstID)
+	}
+}
+
+// autoIncrement fills the AUTO_INCREMENT column of row if it's NULL
+// or 0, and returns the generated ID. Otherwise the given value is
+// taken by the allocator and 0 is returned.
+func (e *Insert) autoIncrement(ctx context.Context, schema *util.Schema, row *util.Row, offset int) util.Int64 {
+	gen := ctx.IDGenerator(schema.Database, schema.TableName)
+	if _, ok := util.CastInteger(row.Values[offset], schema.Columns[offset].Type); row.Values[offset] != nil && !ok {
+		// It's refused when the row is encoded
+		return 0
+	}
+	v := util.Cast(row.Values[offset], util.ColumnInt64)
+	if v != nil && v.(util.Int64) != 0 {
+		if err := gen.Rebase(v.(util.Int64)); err != nil {
+			panic(err)
+		}
+		return 0
+	}
+	id, err := gen.Gen()
+	if err != nil {
+		panic(err)
+	}
+	row.Values[offset] = id
+	return id
+}
+
+// columnOffsets returns offsets in schema of the columns values are given for.
+func (e *Insert) columnOffsets(schema *util.Schema) []int {
+	if len(e.Columns) == 0 {
+		offsets := make([]int, len(schema.Columns))
+		for i := range offsets {
+			offsets[i] = i
+		}
+		return offsets
+	}
+	var offsets []int
+	given := make(map[string]bool)
+	for _, name := range e.Columns {
+		_, offset := schema.GetColumnByName(name)
+		if offset < 0 {
+			panic(fmt.Sprintf("Unknown column %s", name))
+		}
+		if given[name] {
+			panic(fmt.Sprintf("Column %s specified twice", name))
+		}
+		given[name] = true
+		offsets = append(offsets, offset)
+	}
+	for _, c := range schema.Columns {
+		if !given[c.Name] && c.NotNull && c.Default == nil && !c.AutoIncrement {
+			panic(fmt.Sprintf("Field %s doesn't have a default value", c.Name))
+		}
+	}
+	return offsets
+}
+
+// fillRow puts values into a row of schema by offsets, the other
+// columns get their defaults.
+func fillRow(ctx context.Context, schema *util.Schema, offsets []int, values []interface{}) []interface{} {
+	row := make([]interface{}, len(schema.Columns))
+	given := make([]bool, len(schema.Columns))
+	for i, offset := range offsets {
+		row[offset] = values[i]
+		given[offset] = true
+	}
+	for i, c := range schema.Columns {
+		if !given[i] {
+			row[i] = defaultValue(ctx, c)
+		}
+	}
+	return row
+}
+
+func duplicateKeyError(values []interface{}, key string) error {
+	var entry []string
+	for _, v := range values {
+		entry = append(entry, fmt.Sprint(v))
+	}
+	return fmt.Errorf("duplicate entry '%s' for key '%s'", strings.Join(entry, "-"), key)
+}
+
+type CreateTable struct {
+	baseExecutor
+
+	TableName *sqlparser.TableName
+	Schema    *util.Schema
+}
+
+func (e *CreateTable) Open(ctx context.Context) {
+	e.ctx = ctx
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	e.Schema.Database = writableDatabase(ctx, e.TableName)
+	if _, ok := ctx.Schemas(e.Schema.Database)[e.Schema.TableName]; ok {
+		panic(fmt.Sprintf("table %s already exists", e.Schema.TableName))
+	}
+	if err := ctx.CreateTable(nil, e.Schema); err != nil {
+		panic(err)
+	}
+}
+
+type AlterTable struct {
+	baseExecutor
+
+	TableName *sqlparser.TableName
+	// One of "add", "drop" and "modify"
+	Action string
+	// Definition of added or modified column
+	Column *util.Column
+	// Whether added column is UNIQUE
+	Unique bool
+	// Name of dropped or modified column
+	ColumnName string
+}
+
+func (e *AlterTable) Open(ctx context.Context) {
+	e.ctx = ctx
+
+	// Hold writers while schema changes, so no row is written with
+	// the version being replaced.
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	schema := writableTable(ctx, e.TableName)
+	next := schema.NextVersion()
+	// Rows changed by ALTER are written with the new definition
+	batch := new(storage.WriteBatch)
+	switch e.Action {
+	case "add":
+		if _, offset := schema.GetColumnByName(e.Column.Name); offset >= 0 {
+			panic(fmt.Sprintf("Duplicate column name %s", e.Column.Name))
+		}
+		col := *e.Column
+		col.ID = schema.MaxColumnID() + 1
+		// Existing rows get the default as of now, it's resolved here
+		// since MODIFY may change NOT NULL later.
+		v := defaultValue(ctx, &col)
+		if v == nil && col.NotNull {
+			v = col.ZeroValue()
+		}
+		if v != nil {
+			origin := util.Cast(v, util.ColumnFixedString).(string)
+			col.OriginDefault = &origin
+		}
+		next.Columns = append(next.Columns, &col)
+		if e.Unique {
+			idx := &util.Index{Columns: []string{col.Name}}
+			next.AddIndex(idx)
+			buildIndex(ctx.Store(), batch, next, idx)
+		}
+	case "drop":
+		_, offset := schema.GetColumnByName(e.ColumnName)
+		if offset < 0 {
+			panic(fmt.Sprintf("Unknown column %s", e.ColumnName))
+		}
+		if schema.IsPrimaryKey(e.ColumnName) {
+			panic(fmt.Sprintf("Can't drop column %s of primary key", e.ColumnName))
+		}
+		if indexes := schema.IndexesOfColumn(e.ColumnName); len(indexes) > 0 {
+			panic(fmt.Sprintf("Can't drop column %s used by index %s", e.ColumnName, indexes[0].Name))
+		}
+		if len(schema.Columns) == 1 {
+			panic("Can't drop the only column of table, use DROP TABLE instead")
+		}
+		next.Columns = append(next.Columns[:offset], next.Columns[offset+1:]...)
+	case "modify":
+		old, offset := schema.GetColumnByName(e.ColumnName)
+		if offset < 0 {
+			panic(fmt.Sprintf("Unknown column %s", e.ColumnName))
+		}
+		// Keys of existing rows are built from the old type
+		keyChanged := old.Type != e.Column.Type || old.Strlen != e.Column.Strlen ||
+			old.Precision != e.Column.Precision || old.Scale != e.Column.Scale || old.Fsp != e.Column.Fsp ||
+			strings.Join(old.Elems, "\x00") != strings.Join(e.Column.Elems, "\x00")
+		if schema.IsPrimaryKey(e.ColumnName) && keyChanged {
+			panic(fmt.Sprintf("Can't modify column %s of primary key", e.ColumnName))
+		}
+		if indexes := schema.IndexesOfColumn(e.ColumnName); len(indexes) > 0 && keyChanged {
+			panic(fmt.Sprintf("Can't modify column %s used by index %s", e.ColumnName, indexes[0].Name))
+		}
+		col := *e.Column
+		col.ID = old.ID
+		col.OriginDefault = old.OriginDefault
+		col.NotNull = col.NotNull || schema.IsPrimaryKey(e.ColumnName)
+		next.Columns[offset] = &col
+		checkAutoIncrement(next)
+		if col.NotNull && !old.NotNull {
+			forEachValue(ctx, schema, offset, func(v interface{}) {
+				if v == nil {
+					panic("Invalid use of NULL value")
+				}
+			})
+		}
+		if col.AutoIncrement && !old.AutoIncrement {
+			rebaseAutoIncrement(ctx, schema, offset)
+		}
+	}
+	if err := ctx.AlterTable(batch, next); err != nil {
+		panic(err)
+	}
+
+	if ctx.Options().RewriteOnAlter {
+		go rewriteRows(ctx, schema.Database, schema.TableName)
+	}
+}
+
+// forEachValue calls fn with values of column at offset in all rows
+// of table.
+func forEachValue(ctx context.Context, schema *util.Schema, offset int, fn func(v interface{})) {
+	itr := ctx.Store().Scan(util.RowRange(schema.Database, schema.TableName))
+	defer itr.Release()
+	for itr.Next() {
+		row, err := util.ReadRow(itr.Key(), itr.Value(), schema)
+		if err != nil {
+			panic(err)
+		}
+		fn(row.Values[offset])
+	}
+	if err := itr.Error(); err != nil {
+		panic(err)
+	}
+}
+
+// rebaseAutoIncrement moves the allocator of table past values of
+// column at offset in existing rows, so IDs generated later are new.
+func rebaseAutoIncrement(ctx context.Context, schema *util.Schema, offset int) {
+	var max util.Int64
+	forEachValue(ctx, schema, offset, func(v interface{}) {
+		if v = util.Cast(v, util.ColumnInt64); v != nil && v.(util.Int64) > max {
+			max = v.(util.Int64)
+		}
+	})
+	if max == 0 {
+		return
+	}
+	if err := ctx.IDGenerator(schema.Database, schema.TableName).Rebase(max); err != nil {
+		panic(err)
+	}
+}
+
+// rewriteRows upgrades rows written with older versions of schema or
+// older row formats, it's not required since ReadRow decodes all of them.
+func rewriteRows(ctx context.Context, db, table string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rewrite rows of table %s.%s: %v", db, table, r)
+		}
+	}()
+
+	// Writers are held until all rows are rewritten at once
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+	schema := ctx.Schemas(db)[table]
+	if schema == nil {
+		return
+	}
+
+	store := ctx.Store()
+	itr := store.Scan(util.RowRange(db, table))
+	defer itr.Release()
+	b := util.NewRawBuilder()
+	batch := new(storage.WriteBatch)
+	for itr.Next() {
+		if !util.IsRowOutdated(itr.Value(), schema) {
+			continue
+		}
+		row, err := util.ReadRow(itr.Key(), itr.Value(), schema)
+		if err != nil {
+			panic(err)
+		}
+		batch.Put(itr.Key(), BuildRaw(b, row))
 		b.Reset()
 	}
+	if err := itr.Error(); err != nil {
+		panic(err)
+	}
+	if err := store.Write(batch); err != nil {
+		panic(err)
+	}
+}
+
+type DropTable struct {
+	baseExecutor
+
+	TableName *sqlparser.TableName
+	IfExists  bool
+}
+
+func (e *DropTable) Open(ctx context.Context) {
+	e.ctx = ctx
+	lock := ctx.WriteLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	db := writableDatabase(ctx, e.TableName)
+	name := e.TableName.Name.String()
+	if _, ok := ctx.Schemas(db)[name]; !ok && e.IfExists {
+		return
+	}
+	// Rows are deleted with the definition
+	batch := new(storage.WriteBatch)
+	low, up := util.TableRange(db, name)
+	if err := storage.BatchDeleteRange(ctx.Store(), batch, low, up); err != nil {
+		panic(err)
+	}
+	if err := ctx.DropTable(batch, db, name); err != nil {
+		panic(err)
+	}
 }
 
 type ShowTables struct {
 	baseExecutor
 
-	tables chan string
-	count  int
+	// Database is the one given by SHOW TABLES FROM, or the current one.
+	Database string
+	tables   chan string
+	count    int
 }
 
 func (e *ShowTables) Open(ctx context.Context) {
 	e.ctx = ctx
-	e.tables = make(chan string, 1)
-	for k := range e.ctx.Schemas() {
+	db := e.Database
+	if db == "" {
+		db = currentDatabase(ctx)
+	}
+	schemas := e.ctx.Schemas(db)
+	if strings.ToLower(db) == InfoSchema {
+		schemas = infoSchemaTableMap
+	} else if schemas == nil {
+		panic(fmt.Sprintf("database %s doesn't exist", db))
+	}
+	var names []string
+	for k := range schemas {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	e.tables = make(chan string, len(names))
+	for _, k := range names {
 		e.tables <- k
 		e.count++
 	}
@@ -298,113 +843,9 @@ func (e *ShowTables) Next() *util.Row {
 	}
 }
 
-func tryCast(v interface{}, tp util.ColumnType) interface{} {
-	switch tp {
-	case util.ColumnInt32:
-		return castInt32(v)
-	case util.ColumnInt64:
-		return castInt64(v)
-	case util.ColumnUInt32:
-		return castUInt32(v)
-	case util.ColumnUInt64:
-		return castUInt64(v)
-	default:
-		return v
-	}
-}
-
-func castInt32(v interface{}) int {
-	switch value := v.(type) {
-	case int:
-		return int(value)
-	case int32:
-		return int(value)
-	case int64:
-		return int(value)
-	case uint:
-		return int(value)
-	case uint32:
-		return int(value)
-	case uint64:
-		return int(value)
-	default:
-		return 0
-	}
-}
-
-func castInt64(v interface{}) int64 {
-	switch value := v.(type) {
-	case int:
-		return int64(value)
-	case int32:
-		return int64(value)
-	case int64:
-		return int64(value)
-	case uint:
-		return int64(value)
-	case uint32:
-		return int64(value)
-	case uint64:
-		return int64(value)
-	default:
-		return 0
-	}
-}
-
-func castUInt32(v interface{}) uint {
-	switch value := v.(type) {
-	case int:
-		return uint(value)
-	case int32:
-		return uint(value)
-	case int64:
-		return uint(value)
-	case uint:
-		return uint(value)
-	case uint32:
-		return uint(value)
-	case uint64:
-		return uint(value)
-	default:
-		return 0
-	}
-}
-
-func castUInt64(v interface{}) uint64 {
-	switch value := v.(type) {
-	case int:
-		return uint64(value)
-	case int32:
-		return uint64(value)
-	case int64:
-		return uint64(value)
-	case uint:
-		return uint64(value)
-	case uint32:
-		return uint64(value)
-	case uint64:
-		return uint64(value)
-	default:
-		return 0
-	}
-}
-
 func BuildRaw(b *util.RawBuilder, row *util.Row) []byte {
-	for i, c := range row.Schema.Columns {
-		switch c.Type {
-		case util.ColumnInt32:
-			b.AppendInt32(tryCast(row.Values[i], util.ColumnInt32).(int))
-		case util.ColumnInt64:
-			b.AppendInt64(tryCast(row.Values[i], util.ColumnInt64).(int64))
-		case util.ColumnUInt32:
-			b.AppendUInt32(tryCast(row.Values[i], util.ColumnUInt32).(uint))
-		case util.ColumnUInt64:
-			b.AppendUInt64(tryCast(row.Values[i], util.ColumnUInt64).(uint64))
-		case util.ColumnFixedString:
-			b.AppendFixedString(tryCast(row.Values[i], util.ColumnFixedString).(string))
-		case util.ColumnDate:
-			b.AppendDate(tryCast(row.Values[i], util.ColumnDate).(util.Date))
-		}
+	if err := b.AppendRow(row); err != nil {
+		panic(err)
 	}
 	return b.Spawn()
 }
@@ -1,13 +1,21 @@
 package executor
 
 import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/leiysky/a-database/context"
 	"github.com/leiysky/a-database/util"
 	"github.com/xwb1989/sqlparser"
 )
 
+// Expression is evaluated against a row. Predicates follow SQL
+// three-valued logic: Eval returns true, false or nil for UNKNOWN,
+// and EvalBool only holds for true.
 type Expression interface {
 	Eval(*util.Row) interface{}
 
@@ -45,51 +53,158 @@ type Comparison struct {
 }
 
 func (e *Comparison) Eval(row *util.Row) interface{} {
-	return e.EvalBool(row)
-}
-
-func (e *Comparison) EvalBool(row *util.Row) bool {
 	l := e.Left.Eval(row)
 	r := e.Right.Eval(row)
+	// Comparing with NULL is always UNKNOWN
+	if l == nil || r == nil {
+		return nil
+	}
+	result := tryCompare(l, r)
 	switch e.Op {
 	case OpEq:
-		result := tryCompare(l, r)
-		if result == 0 {
-			return true
-		}
-		return false
+		return result == 0
 	case OpNe:
-		result := tryCompare(l, r)
-		if result != 0 && result != -2 {
-			return true
-		}
-		return false
+		return result != 0 && result != -2
 	case OpGt:
-		result := tryCompare(l, r)
-		if result > 0 {
-			return true
-		}
-		return false
+		return result > 0
 	case OpGe:
-		result := tryCompare(l, r)
-		if result >= 0 {
-			return true
-		}
-		return false
+		return result >= 0
 	case OpLt:
-		result := tryCompare(l, r)
-		if result == -1 {
-			return true
-		}
-		return false
+		return result == -1
 	case OpLe:
-		result := tryCompare(l, r)
-		if result == 0 || result == -1 {
+		return result == 0 || result == -1
+	default:
+		return false
+	}
+}
+
+func (e *Comparison) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+type And struct {
+	baseExpression
+
+	Left  Expression
+	Right Expression
+}
+
+// Eval is false if any side is false, otherwise UNKNOWN if any side is UNKNOWN.
+func (e *And) Eval(row *util.Row) interface{} {
+	l := truth(e.Left.Eval(row))
+	if l == false {
+		return false
+	}
+	r := truth(e.Right.Eval(row))
+	if r == false {
+		return false
+	}
+	if l == nil || r == nil {
+		return nil
+	}
+	return true
+}
+
+func (e *And) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+type Or struct {
+	baseExpression
+
+	Left  Expression
+	Right Expression
+}
+
+// Eval is true if any side is true, otherwise UNKNOWN if any side is UNKNOWN.
+func (e *Or) Eval(row *util.Row) interface{} {
+	l := truth(e.Left.Eval(row))
+	if l == true {
+		return true
+	}
+	r := truth(e.Right.Eval(row))
+	if r == true {
+		return true
+	}
+	if l == nil || r == nil {
+		return nil
+	}
+	return false
+}
+
+func (e *Or) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+type Not struct {
+	baseExpression
+
+	Expr Expression
+}
+
+// Eval is UNKNOWN if operand is UNKNOWN.
+func (e *Not) Eval(row *util.Row) interface{} {
+	v := truth(e.Expr.Eval(row))
+	if v == nil {
+		return nil
+	}
+	return v == false
+}
+
+func (e *Not) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+// IsNull is `expr IS [NOT] NULL`, which is never UNKNOWN.
+type IsNull struct {
+	baseExpression
+
+	Expr Expression
+	Not  bool
+}
+
+func (e *IsNull) Eval(row *util.Row) interface{} {
+	return (e.Expr.Eval(row) == nil) != e.Not
+}
+
+func (e *IsNull) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+// IsTruth is `expr IS [NOT] TRUE|FALSE`, UNKNOWN is neither true nor false.
+type IsTruth struct {
+	baseExpression
+
+	Expr  Expression
+	Value bool
+	Not   bool
+}
+
+func (e *IsTruth) Eval(row *util.Row) interface{} {
+	return (truth(e.Expr.Eval(row)) == e.Value) != e.Not
+}
+
+func (e *IsTruth) EvalBool(row *util.Row) bool {
+	return e.Eval(row) == true
+}
+
+// truth converts a value into true, false or nil for UNKNOWN,
+// numbers are true if not zero.
+func truth(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		return value
+	case string:
+		return util.Cast(value, util.ColumnDouble).(float64) != 0
+	case util.JSON:
+		if _, ok := value.Scalar(); !ok {
 			return true
 		}
-		return false
+		return truth(jsonOperand(value, nil))
 	default:
-		return false
+		return tryCompare(v, 0) != 0
 	}
 }
 
@@ -100,8 +215,46 @@ type ColumnValue struct {
 }
 
 func (e *ColumnValue) Eval(row *util.Row) interface{} {
-	_, offset := row.Schema.GetColumnByName(e.Name.Name.Lowered())
-	return row.Values[offset]
+	return row.Values[columnOffset(row, e.Name)]
+}
+
+func (e *ColumnValue) EvalBool(row *util.Row) bool {
+	return truth(e.Eval(row)) == true
+}
+
+// columnOffset finds the column name refers to in row. Columns of
+// joined rows are named `database.table.column`, they can be referred
+// without database or table name if it's not ambiguous.
+func columnOffset(row *util.Row, name *sqlparser.ColName) int {
+	col := name.Name.Lowered()
+	table, db := name.Qualifier.Name.String(), name.Qualifier.Qualifier.String()
+	if _, offset := row.Schema.GetColumnByName(col); offset >= 0 {
+		// Columns of a single table are not qualified
+		if (table == "" || table == row.Schema.TableName) && (db == "" || db == row.Schema.Database) {
+			return offset
+		}
+		panic(fmt.Sprintf("Unknown column %s", sqlparser.String(name)))
+	}
+	suffix := "." + col
+	if table != "" {
+		suffix = "." + table + suffix
+	}
+	if db != "" {
+		suffix = "." + db + suffix
+	}
+	offset := -1
+	for i, c := range row.Schema.Columns {
+		if strings.HasSuffix("."+c.Name, suffix) {
+			if offset >= 0 {
+				panic(fmt.Sprintf("Column %s is ambiguous", sqlparser.String(name)))
+			}
+			offset = i
+		}
+	}
+	if offset < 0 {
+		panic(fmt.Sprintf("Unknown column %s", sqlparser.String(name)))
+	}
+	return offset
 }
 
 type SQLValue struct {
@@ -114,14 +267,184 @@ func (e *SQLValue) Eval(row *util.Row) interface{} {
 	return e.Val
 }
 
+func (e *SQLValue) EvalBool(row *util.Row) bool {
+	return truth(e.Val) == true
+}
+
+// Now is the time statement is executed at, rounded to Fsp digits
+// of fractional seconds. UTC_TIMESTAMP is the same wall clock in UTC.
+type Now struct {
+	baseExpression
+
+	Fsp int
+	UTC bool
+
+	ctx context.Context
+}
+
+func (e *Now) Eval(row *util.Row) interface{} {
+	t := statementTime(e.ctx)
+	now := util.Date(t)
+	if e.UTC {
+		now = util.FromWallClock(t.UTC(), util.TimeZone)
+	}
+	return now.Round(e.Fsp)
+}
+
+func (e *Now) resultColumn(row *util.Row) (util.Column, bool) {
+	return util.Column{Type: util.ColumnDatetime, Fsp: e.Fsp}, true
+}
+
+// statementTime returns the time statement of ctx starts at, or the
+// current time if ctx isn't bound.
+func statementTime(ctx context.Context) time.Time {
+	if ctx != nil {
+		if t := ctx.StartTime(); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// typedExpression is an expression knowing the column type of its
+// result on row, types of other expressions are told by their values.
+type typedExpression interface {
+	resultColumn(row *util.Row) (util.Column, bool)
+}
+
+// columnOf returns the column type of result of expr on row, ok is
+// false if it's told by the value.
+func columnOf(expr Expression, row *util.Row) (util.Column, bool) {
+	switch e := expr.(type) {
+	case *ColumnValue:
+		return *row.Schema.Columns[columnOffset(row, e.Name)], true
+	case typedExpression:
+		return e.resultColumn(row)
+	default:
+		return util.Column{}, false
+	}
+}
+
+// LastInsertID is LAST_INSERT_ID() of session.
+type LastInsertID struct {
+	baseExpression
+
+	ctx context.Context
+}
+
+func (e *LastInsertID) Eval(row *util.Row) interface{} {
+	return util.Int64(e.ctx.Session().LastInsertID())
+}
+
+// bindContext gives expressions reading states of session the context
+// statement runs in.
+func bindContext(expr Expression, ctx context.Context) {
+	switch e := expr.(type) {
+	case *LastInsertID:
+		e.ctx = ctx
+	case *Now:
+		e.ctx = ctx
+	case *Comparison:
+		bindContext(e.Left, ctx)
+		bindContext(e.Right, ctx)
+	case *And:
+		bindContext(e.Left, ctx)
+		bindContext(e.Right, ctx)
+	case *Or:
+		bindContext(e.Left, ctx)
+		bindContext(e.Right, ctx)
+	case *Not:
+		bindContext(e.Expr, ctx)
+	case *IsNull:
+		bindContext(e.Expr, ctx)
+	case *IsTruth:
+		bindContext(e.Expr, ctx)
+	case *DateFunc:
+		e.ctx = ctx
+		for _, arg := range e.Args {
+			bindContext(arg, ctx)
+		}
+	case *JSONFunc:
+		for _, arg := range e.Args {
+			bindContext(arg, ctx)
+		}
+	}
+}
+
+// typeOfValue returns the column type holding values like v.
+func typeOfValue(v interface{}) util.ColumnType {
+	switch v.(type) {
+	case util.Int32:
+		return util.ColumnInt32
+	case util.Int64:
+		return util.ColumnInt64
+	case util.UInt32:
+		return util.ColumnUInt32
+	case util.UInt64:
+		return util.ColumnUInt64
+	case util.Date:
+		return util.ColumnDate
+	case util.Blob:
+		return util.ColumnBlob
+	case util.Float:
+		return util.ColumnFloat
+	case util.Double:
+		return util.ColumnDouble
+	case util.Decimal:
+		return util.ColumnDecimal
+	case util.Boolean:
+		return util.ColumnBoolean
+	case util.JSON:
+		return util.ColumnJSON
+	case util.Enum:
+		return util.ColumnEnum
+	default:
+		return util.ColumnFixedString
+	}
+}
+
 func rewriteExpr(expr sqlparser.Expr) Expression {
-	switch v := expr.(type) {
+	switch v := foldNegative(expr).(type) {
+	case *sqlparser.AndExpr:
+		return &And{
+			Left:  rewriteExpr(v.Left),
+			Right: rewriteExpr(v.Right),
+		}
+	case *sqlparser.OrExpr:
+		return &Or{
+			Left:  rewriteExpr(v.Left),
+			Right: rewriteExpr(v.Right),
+		}
+	case *sqlparser.NotExpr:
+		return &Not{
+			Expr: rewriteExpr(v.Expr),
+		}
+	case *sqlparser.IsExpr:
+		return rewriteIsExpr(v)
+	case *sqlparser.NullVal:
+		return &SQLValue{}
+	case sqlparser.BoolVal:
+		return &SQLValue{
+			Val: bool(v),
+		}
+	case *sqlparser.ParenExpr:
+		return rewriteExpr(v.Expr)
 	case *sqlparser.ComparisonExpr:
 		return rewriteComparisonExpr(v)
 	case *sqlparser.ColName:
 		return rewriteColNameExpr(v)
 	case *sqlparser.SQLVal:
 		return rewriteSQLVal(v)
+	case *sqlparser.FuncExpr:
+		return rewriteFuncExpr(v)
+	case *sqlparser.BinaryExpr:
+		if e := rewriteIntervalExpr(v); e != nil {
+			return e
+		}
+		if e := rewriteJSONOperator(v); e != nil {
+			return e
+		}
+		return &baseExpression{}
 	default:
 		return &baseExpression{}
 	}
@@ -150,6 +473,26 @@ func rewriteComparisonExpr(expr *sqlparser.ComparisonExpr) Expression {
 	return e
 }
 
+func rewriteIsExpr(expr *sqlparser.IsExpr) Expression {
+	e := rewriteExpr(expr.Expr)
+	switch expr.Operator {
+	case sqlparser.IsNullStr:
+		return &IsNull{Expr: e}
+	case sqlparser.IsNotNullStr:
+		return &IsNull{Expr: e, Not: true}
+	case sqlparser.IsTrueStr:
+		return &IsTruth{Expr: e, Value: true}
+	case sqlparser.IsNotTrueStr:
+		return &IsTruth{Expr: e, Value: true, Not: true}
+	case sqlparser.IsFalseStr:
+		return &IsTruth{Expr: e, Value: false}
+	case sqlparser.IsNotFalseStr:
+		return &IsTruth{Expr: e, Value: false, Not: true}
+	default:
+		panic(fmt.Sprintf("Unsupported operator %s", expr.Operator))
+	}
+}
+
 func rewriteColNameExpr(expr *sqlparser.ColName) Expression {
 	return &ColumnValue{
 		Name: expr,
@@ -159,24 +502,305 @@ func rewriteColNameExpr(expr *sqlparser.ColName) Expression {
 func rewriteSQLVal(expr *sqlparser.SQLVal) Expression {
 	switch expr.Type {
 	case sqlparser.IntVal:
-		v, _ := strconv.Atoi(string(expr.Val))
 		return &SQLValue{
-			Val: v,
+			Val: parseIntVal(expr.Val),
+		}
+	case sqlparser.FloatVal:
+		return &SQLValue{
+			Val: parseFloatVal(expr.Val),
 		}
 	case sqlparser.StrVal:
 		return &SQLValue{
 			Val: string(expr.Val),
 		}
+	case sqlparser.HexVal, sqlparser.HexNum:
+		return &SQLValue{
+			Val: parseHexVal(expr),
+		}
+	case sqlparser.ValArg:
+		// CURRENT_TIMESTAMP without parentheses
+		if strings.ToLower(string(expr.Val)) == "current_timestamp" {
+			return &Now{}
+		}
+		return nil
 	default:
 		return nil
 	}
 }
 
+func rewriteFuncExpr(expr *sqlparser.FuncExpr) Expression {
+	name := expr.Name.Lowered()
+	args := funcArgs(expr)
+	switch name {
+	case "now", "current_timestamp", "localtime", "localtimestamp", "sysdate":
+		return &Now{Fsp: fspOf(name, args)}
+	case "utc_timestamp":
+		return &Now{Fsp: fspOf(name, args), UTC: true}
+	case "last_insert_id":
+		return &LastInsertID{}
+	}
+	if e := rewriteDateFunc(name, args); e != nil {
+		return e
+	}
+	if e := rewriteJSONFunc(name, args); e != nil {
+		return e
+	}
+	panic(fmt.Sprintf("Unsupported function %s", expr.Name.String()))
+}
+
+// keyCond is a condition like `column op constant`.
+type keyCond struct {
+	op  OpType
+	val interface{}
+}
+
+// collectKeyConds finds conjuncts of expr comparing a column with
+// a constant, they are grouped by name of column.
+func collectKeyConds(expr Expression, conds map[string][]*keyCond) {
+	switch e := expr.(type) {
+	case *And:
+		collectKeyConds(e.Left, conds)
+		collectKeyConds(e.Right, conds)
+	case *Comparison:
+		op := e.Op
+		col, ok := e.Left.(*ColumnValue)
+		val, isConst := constantValue(e.Right)
+		if !ok || !isConst {
+			// `constant op column`, swap sides
+			col, ok = e.Right.(*ColumnValue)
+			val, isConst = constantValue(e.Left)
+			switch op {
+			case OpGt:
+				op = OpLt
+			case OpGe:
+				op = OpLe
+			case OpLt:
+				op = OpGt
+			case OpLe:
+				op = OpGe
+			}
+		}
+		// Nothing equals to NULL, leave it to the filter
+		if ok && isConst && op != OpNe && val != nil {
+			name := col.Name.Name.Lowered()
+			conds[name] = append(conds[name], &keyCond{
+				op:  op,
+				val: val,
+			})
+		}
+	}
+}
+
+// constantValue returns value of expr if it's a literal, or a date
+// function of literals like DATE('2020-01-01').
+func constantValue(expr Expression) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *SQLValue:
+		return e.Val, true
+	case *DateFunc:
+		if e.isConstant() {
+			return e.Eval(nil), true
+		}
+	}
+	return nil, false
+}
+
+// parseIntVal returns an int, or an uint64 if the literal
+// is too large for int.
+func parseIntVal(val []byte) interface{} {
+	v, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		if u, err := strconv.ParseUint(string(val), 10, 64); err == nil {
+			return u
+		}
+	}
+	return int(v)
+}
+
+// foldNegative turns `-1.5` into a literal, sqlparser only does
+// it for integers.
+func foldNegative(expr sqlparser.Expr) sqlparser.Expr {
+	if u, ok := expr.(*sqlparser.UnaryExpr); ok && u.Operator == sqlparser.UMinusStr {
+		if v, ok := u.Expr.(*sqlparser.SQLVal); ok && v.Type == sqlparser.FloatVal {
+			return sqlparser.NewFloatVal(append([]byte("-"), v.Val...))
+		}
+	}
+	return expr
+}
+
+// parseFloatVal returns a Decimal for exact literals like 1.5, and
+// a float64 for ones with exponent or too many digits.
+func parseFloatVal(val []byte) interface{} {
+	if d, err := util.ParseDecimal(string(val)); err == nil {
+		return d
+	}
+	v, _ := strconv.ParseFloat(string(val), 64)
+	return v
+}
+
+// parseHexVal decodes X'0A' or 0x0A into binary string.
+func parseHexVal(val *sqlparser.SQLVal) util.Blob {
+	digits := string(val.Val)
+	if val.Type == sqlparser.HexNum {
+		digits = digits[2:]
+	}
+	if len(digits)%2 != 0 {
+		digits = "0" + digits
+	}
+	v, err := hex.DecodeString(digits)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid hex literal %s", val.Val))
+	}
+	return v
+}
+
+// numericType returns the type numbers like v are compared as.
+func numericType(v interface{}) (util.ColumnType, bool) {
+	switch v.(type) {
+	case int, int64, bool:
+		return util.ColumnInt64, true
+	case uint, uint64:
+		return util.ColumnUInt64, true
+	case float32, float64:
+		return util.ColumnDouble, true
+	case util.Decimal:
+		return util.ColumnDecimal, true
+	default:
+		return 0, false
+	}
+}
+
+// enumOperand returns the value v is compared with other as. Enum
+// is compared by its index, so is a string naming an element of the
+// other side. Enum is compared as string with other strings.
+func enumOperand(v, other interface{}) interface{} {
+	switch value := v.(type) {
+	case util.Enum:
+		if s, ok := other.(string); ok && value.IndexOf(s) == 0 {
+			return value.String()
+		}
+		return value.Index
+	case string:
+		if e, ok := other.(util.Enum); ok {
+			if i := e.IndexOf(value); i > 0 {
+				return i
+			}
+		}
+	}
+	return v
+}
+
+// numericOperand returns the value v is compared with other as. A
+// string is compared with an integer as an integer if it's one, or as
+// Double otherwise. Double and Decimal are handled by promotedType.
+func numericOperand(v, other interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	if tp, ok := numericType(other); !ok || tp == util.ColumnDouble || tp == util.ColumnDecimal {
+		return v
+	}
+	s = strings.TrimSpace(s)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u
+	}
+	return util.Cast(s, util.ColumnDouble)
+}
+
+// promotedType returns the type to compare l and r as, if either is
+// an inexact or decimal number: Double beats Decimal, which beats
+// integers. A string is converted into the type of the other side.
+func promotedType(l, r interface{}) (util.ColumnType, bool) {
+	lt, lok := numericType(l)
+	rt, rok := numericType(r)
+	if !lok && !rok {
+		return 0, false
+	}
+	if !rok {
+		_, isString := r.(string)
+		return lt, isString && (lt == util.ColumnDouble || lt == util.ColumnDecimal)
+	}
+	if !lok {
+		_, isString := l.(string)
+		return rt, isString && (rt == util.ColumnDouble || rt == util.ColumnDecimal)
+	}
+	if lt == util.ColumnDouble || rt == util.ColumnDouble {
+		return util.ColumnDouble, true
+	}
+	if lt == util.ColumnDecimal || rt == util.ColumnDecimal {
+		return util.ColumnDecimal, true
+	}
+	return 0, false
+}
+
+// compareMixedIntegers compares a signed integer with an unsigned one
+// by sign first, so a negative value is never wrapped around.
+func compareMixedIntegers(l, r interface{}) (int, bool) {
+	lt, lok := numericType(l)
+	rt, rok := numericType(r)
+	if !lok || !rok || lt == rt {
+		return 0, false
+	}
+	if lt == util.ColumnUInt64 {
+		c, _ := compareMixedIntegers(r, l)
+		return -c, true
+	}
+	lv := util.Cast(l, util.ColumnInt64).(int64)
+	if lv < 0 {
+		return -1, true
+	}
+	rv := util.Cast(r, util.ColumnUInt64).(uint64)
+	if uint64(lv) > rv {
+		return 1, true
+	} else if uint64(lv) == rv {
+		return 0, true
+	}
+	return -1, true
+}
+
 func tryCompare(l, r interface{}) int {
+	_, lj := l.(util.JSON)
+	_, rj := r.(util.JSON)
+	if lj || rj {
+		l, r = jsonOperand(l, r), jsonOperand(r, l)
+	}
+	_, le := l.(util.Enum)
+	_, re := r.(util.Enum)
+	if le || re {
+		l, r = enumOperand(l, r), enumOperand(r, l)
+	}
+	l, r = numericOperand(l, r), numericOperand(r, l)
+	if tp, ok := promotedType(l, r); ok {
+		if tp == util.ColumnDecimal {
+			return util.Cast(l, tp).(util.Decimal).Cmp(util.Cast(r, tp).(util.Decimal))
+		}
+		lv := util.Cast(l, tp).(float64)
+		rv := util.Cast(r, tp).(float64)
+		if lv > rv {
+			return 1
+		} else if lv == rv {
+			return 0
+		} else {
+			return -1
+		}
+	}
+	if c, ok := compareMixedIntegers(l, r); ok {
+		return c
+	}
+	if _, ok := r.(util.Date); ok {
+		// Strings are compared with dates as dates
+		if _, ok := l.(string); ok {
+			return -tryCompare(r, l)
+		}
+	}
 	switch l.(type) {
-	case int, int64:
-		lv := tryCast(l, util.ColumnInt64).(int64)
-		rv := tryCast(r, util.ColumnInt64).(int64)
+	case int, int64, bool:
+		lv := util.Cast(l, util.ColumnInt64).(int64)
+		rv := util.Cast(r, util.ColumnInt64).(int64)
 		if lv > rv {
 			return 1
 		} else if lv == rv {
@@ -185,8 +809,8 @@ func tryCompare(l, r interface{}) int {
 			return -1
 		}
 	case uint, uint64:
-		lv := tryCast(l, util.ColumnUInt64).(uint64)
-		rv := tryCast(r, util.ColumnUInt64).(uint64)
+		lv := util.Cast(l, util.ColumnUInt64).(uint64)
+		rv := util.Cast(r, util.ColumnUInt64).(uint64)
 		if lv > rv {
 			return 1
 		} else if lv == rv {
@@ -195,12 +819,16 @@ func tryCompare(l, r interface{}) int {
 			return -1
 		}
 	case string:
-		lv := tryCast(l, util.ColumnFixedString).(string)
-		rv := tryCast(r, util.ColumnFixedString).(string)
+		lv := util.Cast(l, util.ColumnFixedString).(string)
+		rv := util.Cast(r, util.ColumnFixedString).(string)
 		return strings.Compare(lv, rv)
+	case util.Blob:
+		lv := util.Cast(l, util.ColumnBlob).(util.Blob)
+		rv := util.Cast(r, util.ColumnBlob).(util.Blob)
+		return bytes.Compare(lv, rv)
 	case util.Date:
-		lv := tryCast(l, util.ColumnDate).(util.Date).Timestamp()
-		rv := tryCast(r, util.ColumnDate).(util.Date).Timestamp()
+		lv := util.Cast(l, util.ColumnDate).(util.Date).Micros()
+		rv := util.Cast(r, util.ColumnDate).(util.Date).Micros()
 		if lv > rv {
 			return 1
 		} else if lv == rv {
@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"github.com/leiysky/a-database/storage"
+	"github.com/leiysky/a-database/util"
+)
+
+// uniqueChecker checks rows against primary key and unique indexes
+// of a table. Rows checked by it are taken into account as well, so
+// a statement can check all rows before writing any of them.
+type uniqueChecker struct {
+	store  storage.Storage
+	schema *util.Schema
+	taken  map[string]bool
+}
+
+func newUniqueChecker(store storage.Storage, schema *util.Schema) *uniqueChecker {
+	return &uniqueChecker{
+		store:  store,
+		schema: schema,
+		taken:  make(map[string]bool),
+	}
+}
+
+// check returns the key of row and keys of its index entries, which
+// should be written with the key of row as value. It panics with a
+// duplicate key error if any of them is taken.
+func (c *uniqueChecker) check(row *util.Row) (key []byte, entries [][]byte) {
+	pk := c.schema.PrimaryKeyValues(row)
+	key = util.GenerateKey(c.schema, pk)
+	c.take(key, pk, "PRIMARY")
+	for _, idx := range c.schema.Indexes {
+		values := c.schema.IndexValues(idx, row)
+		if hasNull(values) {
+			// NULL never equals to anything
+			continue
+		}
+		entry := util.IndexKey(c.schema, idx, values)
+		c.take(entry, values, idx.Name)
+		entries = append(entries, entry)
+	}
+	return key, entries
+}
+
+func (c *uniqueChecker) take(key []byte, values []interface{}, name string) {
+	_, err := c.store.Get(key)
+	if err == nil || c.taken[string(key)] {
+		panic(duplicateKeyError(values, name))
+	} else if err != storage.ErrNotFound {
+		panic(err)
+	}
+	c.taken[string(key)] = true
+}
+
+func hasNull(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// buildIndex adds entries of idx for all rows of the table to batch,
+// rows are read with schema which idx belongs to.
+func buildIndex(store storage.Storage, batch *storage.WriteBatch, schema *util.Schema, idx *util.Index) {
+	// Rows exist already, only the index is checked
+	checker := newUniqueChecker(store, schema)
+	itr := store.Scan(util.RowRange(schema.Database, schema.TableName))
+	for itr.Next() {
+		row, err := util.ReadRow(itr.Key(), itr.Value(), schema)
+		if err != nil {
+			itr.Release()
+			panic(err)
+		}
+		values := schema.IndexValues(idx, row)
+		if hasNull(values) {
+			continue
+		}
+		entry := util.IndexKey(schema, idx, values)
+		checker.take(entry, values, idx.Name)
+		batch.Put(entry, itr.Key())
+	}
+	itr.Release()
+	if err := itr.Error(); err != nil {
+		panic(err)
+	}
+}
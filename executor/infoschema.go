@@ -0,0 +1,207 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// InfoSchema is the database of read-only tables describing other tables.
+const InfoSchema = "information_schema"
+
+func infoSchemaTable(name string, columns ...*util.Column) *util.Schema {
+	return &util.Schema{
+		TableName: name,
+		Columns:   columns,
+	}
+}
+
+func strColumn(name string) *util.Column {
+	return &util.Column{Type: util.ColumnFixedString, Name: name, Strlen: 64}
+}
+
+func intColumn(name string) *util.Column {
+	return &util.Column{Type: util.ColumnInt64, Name: name}
+}
+
+var (
+	infoSchemaTables = infoSchemaTable("tables",
+		strColumn("table_schema"),
+		strColumn("table_name"),
+		strColumn("table_type"),
+		intColumn("version"),
+		intColumn("table_rows"),
+	)
+	infoSchemaColumns = infoSchemaTable("columns",
+		strColumn("table_schema"),
+		strColumn("table_name"),
+		strColumn("column_name"),
+		intColumn("ordinal_position"),
+		strColumn("column_default"),
+		strColumn("is_nullable"),
+		strColumn("data_type"),
+		intColumn("character_maximum_length"),
+		strColumn("column_type"),
+		strColumn("column_key"),
+		strColumn("extra"),
+	)
+	infoSchemaIndexes = infoSchemaTable("indexes",
+		strColumn("table_schema"),
+		strColumn("table_name"),
+		strColumn("index_name"),
+		intColumn("index_id"),
+		intColumn("non_unique"),
+		strColumn("column_names"),
+	)
+	infoSchemaStatistics = infoSchemaTable("statistics",
+		strColumn("table_schema"),
+		strColumn("table_name"),
+		intColumn("non_unique"),
+		strColumn("index_name"),
+		intColumn("seq_in_index"),
+		strColumn("column_name"),
+		strColumn("nullable"),
+	)
+
+	infoSchemaTableMap = map[string]*util.Schema{
+		"tables":     infoSchemaTables,
+		"columns":    infoSchemaColumns,
+		"indexes":    infoSchemaIndexes,
+		"statistics": infoSchemaStatistics,
+	}
+)
+
+// InfoSchemaScan reads a table of information_schema, rows are built
+// from table definitions when it's opened.
+type InfoSchemaScan struct {
+	staticRows
+
+	table *sqlparser.TableName
+}
+
+func (e *InfoSchemaScan) Open(ctx context.Context) {
+	e.ctx = ctx
+	table := strings.ToLower(e.table.Name.String())
+	if infoSchemaTableMap[table] == nil {
+		panic(fmt.Sprintf("table %s.%s doesn't exist", InfoSchema, e.table.Name.String()))
+	}
+	for _, db := range ctx.Databases() {
+		e.addTables(ctx, db, table)
+	}
+}
+
+// addTables adds rows of table describing tables of db.
+func (e *InfoSchemaScan) addTables(ctx context.Context, db, table string) {
+	schemas := ctx.Schemas(db)
+	var names []string
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		schema := schemas[name]
+		switch table {
+		case "tables":
+			e.add(infoSchemaTables, db, name, "BASE TABLE",
+				util.Int64(schema.Version), countRows(ctx, db, name))
+		case "columns":
+			for i, c := range schema.Columns {
+				e.add(infoSchemaColumns, db, name, c.Name, util.Int64(i+1),
+					columnDefault(c), yesOrNo(!c.NotNull), dataType(c), maxLength(c),
+					columnTypeSQL(c), columnKey(schema, c.Name), columnExtra(c))
+			}
+		case "indexes":
+			for _, idx := range tableIndexes(schema) {
+				e.add(infoSchemaIndexes, db, name, idx.Name, util.Int64(idx.ID),
+					util.Int64(0), strings.Join(idx.Columns, ","))
+			}
+		case "statistics":
+			for _, idx := range tableIndexes(schema) {
+				for i, c := range idx.Columns {
+					col, _ := schema.GetColumnByName(c)
+					e.add(infoSchemaStatistics, db, name, util.Int64(0), idx.Name,
+						util.Int64(i+1), c, yesOrEmpty(!col.NotNull))
+				}
+			}
+		}
+	}
+}
+
+func (e *InfoSchemaScan) add(schema *util.Schema, values ...interface{}) {
+	e.rows = append(e.rows, &util.Row{
+		Schema: schema,
+		Values: values,
+	})
+}
+
+// tableIndexes returns primary key as an index with ID 0,
+// followed by the unique indexes.
+func tableIndexes(schema *util.Schema) []*util.Index {
+	indexes := []*util.Index{{
+		Name:    "PRIMARY",
+		Columns: schema.PrimaryKey,
+	}}
+	return append(indexes, schema.Indexes...)
+}
+
+func countRows(ctx context.Context, db, table string) util.Int64 {
+	itr := ctx.Snapshot().Scan(util.RowRange(db, table))
+	defer itr.Release()
+	var n util.Int64
+	for itr.Next() {
+		n++
+	}
+	if err := itr.Error(); err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func columnDefault(c *util.Column) interface{} {
+	if c.Default == nil {
+		return nil
+	}
+	return defaultText(c)
+}
+
+func columnExtra(c *util.Column) string {
+	if c.AutoIncrement {
+		return "auto_increment"
+	}
+	return ""
+}
+
+// dataType returns name of column type without length and attributes.
+func dataType(c *util.Column) string {
+	tp := columnTypeSQL(c)
+	if i := strings.IndexAny(tp, "( "); i >= 0 {
+		return tp[:i]
+	}
+	return tp
+}
+
+func maxLength(c *util.Column) interface{} {
+	if c.Type == util.ColumnFixedString || c.Type.IsVariableLength() {
+		return util.Int64(c.Strlen)
+	}
+	return nil
+}
+
+func yesOrNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func yesOrEmpty(b bool) string {
+	if b {
+		return "YES"
+	}
+	return ""
+}
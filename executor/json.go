@@ -0,0 +1,178 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// JSONFunc is a function on JSON values, NULL in arguments makes
+// the result NULL.
+type JSONFunc struct {
+	baseExpression
+
+	Name string
+	Args []Expression
+}
+
+func rewriteJSONFunc(name string, args []sqlparser.Expr) Expression {
+	switch name {
+	case "json_extract":
+		checkArgs(name, args, 2, len(args))
+	case "json_unquote", "json_valid", "json_type":
+		checkArgs(name, args, 1, 1)
+	case "json_array":
+	case "json_object":
+		if len(args)%2 != 0 {
+			panic(fmt.Sprintf("Incorrect parameter count in the call to %s", strings.ToUpper(name)))
+		}
+	default:
+		return nil
+	}
+	e := &JSONFunc{Name: name}
+	for _, arg := range args {
+		e.Args = append(e.Args, rewriteExpr(arg))
+	}
+	return e
+}
+
+// rewriteJSONOperator rewrites `doc -> path` into JSON_EXTRACT(doc, path),
+// and `doc ->> path` into JSON_UNQUOTE(JSON_EXTRACT(doc, path)).
+func rewriteJSONOperator(expr *sqlparser.BinaryExpr) Expression {
+	switch expr.Operator {
+	case sqlparser.JSONExtractOp:
+		return rewriteJSONFunc("json_extract", []sqlparser.Expr{expr.Left, expr.Right})
+	case sqlparser.JSONUnquoteExtractOp:
+		return &JSONFunc{
+			Name: "json_unquote",
+			Args: []Expression{rewriteJSONFunc("json_extract", []sqlparser.Expr{expr.Left, expr.Right})},
+		}
+	default:
+		return nil
+	}
+}
+
+func (e *JSONFunc) Eval(row *util.Row) interface{} {
+	args := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.Eval(row)
+	}
+	switch e.Name {
+	case "json_array":
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = jsonValue(arg)
+		}
+		return util.NewJSON(values)
+	case "json_object":
+		members := make(map[string]interface{})
+		for i := 0; i < len(args); i += 2 {
+			if args[i] == nil {
+				panic("JSON documents may not contain NULL member names")
+			}
+			members[util.Cast(args[i], util.ColumnFixedString).(string)] = jsonValue(args[i+1])
+		}
+		return util.NewJSON(members)
+	}
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+	}
+	switch e.Name {
+	case "json_valid":
+		switch value := args[0].(type) {
+		case util.JSON:
+			return true
+		case string:
+			_, err := util.ParseJSON(value)
+			return err == nil
+		default:
+			return false
+		}
+	case "json_unquote":
+		if j, ok := args[0].(util.JSON); ok {
+			return j.Unquote()
+		}
+		s := util.Cast(args[0], util.ColumnFixedString).(string)
+		if j, err := util.ParseJSON(s); err == nil && strings.HasPrefix(s, `"`) {
+			return j.Unquote()
+		}
+		return s
+	case "json_type":
+		return jsonArg(e.Name, args, 0).Type()
+	case "json_extract":
+		doc := jsonArg(e.Name, args, 0).Value()
+		var matches []interface{}
+		wildcard := len(args) > 2
+		for i := 1; i < len(args); i++ {
+			path, err := util.ParseJSONPath(util.Cast(args[i], util.ColumnFixedString).(string))
+			if err != nil {
+				panic(err)
+			}
+			wildcard = wildcard || path.HasWildcard()
+			matches = append(matches, path.Extract(doc)...)
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		// Matches are wrapped into an array unless there can be only one
+		if !wildcard {
+			return util.NewJSON(matches[0])
+		}
+		return util.NewJSON(matches)
+	default:
+		return nil
+	}
+}
+
+func (e *JSONFunc) EvalBool(row *util.Row) bool {
+	return truth(e.Eval(row)) == true
+}
+
+// jsonArg returns argument i of function name, strings are parsed
+// as JSON texts.
+func jsonArg(name string, args []interface{}, i int) util.JSON {
+	j, ok := util.Cast(args[i], util.ColumnJSON).(util.JSON)
+	if !ok {
+		panic(fmt.Sprintf("invalid JSON text in argument %d to function %s", i+1, name))
+	}
+	return j
+}
+
+// jsonValue converts v into a value of JSON document, SQL strings
+// are JSON strings rather than JSON texts.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil, bool, string:
+		return value
+	case util.JSON:
+		return value.Value()
+	default:
+		return util.Cast(value, util.ColumnJSON).(util.JSON).Value()
+	}
+}
+
+// jsonOperand returns the value v is compared with other as. A scalar
+// JSON is its SQL value, objects and arrays are their texts, and a
+// string compared with them is normalized if it's a JSON text.
+func jsonOperand(v, other interface{}) interface{} {
+	switch value := v.(type) {
+	case util.JSON:
+		if scalar, ok := value.Scalar(); ok && scalar != nil {
+			return scalar
+		}
+		return string(value)
+	case string:
+		if j, ok := other.(util.JSON); ok {
+			if _, scalar := j.Scalar(); !scalar {
+				if normalized, err := util.ParseJSON(value); err == nil {
+					return string(normalized)
+				}
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,207 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leiysky/a-database/context"
+	"github.com/leiysky/a-database/util"
+	"github.com/xwb1989/sqlparser"
+)
+
+// staticRows returns rows prepared in Open one by one.
+type staticRows struct {
+	baseExecutor
+
+	rows []*util.Row
+}
+
+func (e *staticRows) Next() *util.Row {
+	if len(e.rows) == 0 {
+		return nil
+	}
+	r := e.rows[0]
+	e.rows = e.rows[1:]
+	return r
+}
+
+// DescribeTable is DESCRIBE or SHOW COLUMNS, there is a row for each column.
+type DescribeTable struct {
+	staticRows
+
+	TableName *sqlparser.TableName
+}
+
+func (e *DescribeTable) Open(ctx context.Context) {
+	e.ctx = ctx
+	schema := lookupTable(ctx, e.TableName)
+	for _, c := range schema.Columns {
+		e.rows = append(e.rows, &util.Row{
+			Schema: util.TableDescription,
+			Values: []interface{}{c.Name, columnTypeSQL(c), yesOrNo(!c.NotNull), columnKey(schema, c.Name), columnDefault(c), columnExtra(c)},
+		})
+	}
+}
+
+// ShowCreateTable returns a CREATE TABLE statement building the table.
+type ShowCreateTable struct {
+	staticRows
+
+	TableName *sqlparser.TableName
+}
+
+func (e *ShowCreateTable) Open(ctx context.Context) {
+	e.ctx = ctx
+	schema := lookupTable(ctx, e.TableName)
+	e.rows = append(e.rows, &util.Row{
+		Schema: &util.Schema{
+			Columns: []*util.Column{
+				{Type: util.ColumnFixedString, Name: "table"},
+				{Type: util.ColumnFixedString, Name: "create table"},
+			},
+		},
+		Values: []interface{}{schema.TableName, createTableSQL(schema)},
+	})
+}
+
+// columnTypeSQL returns type of column c in SQL.
+func columnTypeSQL(c *util.Column) string {
+	switch c.Type {
+	case util.ColumnInt32:
+		return intTypeSQL(c.Width)
+	case util.ColumnInt64:
+		return "bigint"
+	case util.ColumnUInt32:
+		return intTypeSQL(c.Width) + " unsigned"
+	case util.ColumnUInt64:
+		return "bigint unsigned"
+	case util.ColumnFixedString:
+		return fmt.Sprintf("char(%d)", c.Strlen)
+	case util.ColumnVarchar:
+		return fmt.Sprintf("varchar(%d)", c.Strlen)
+	case util.ColumnText:
+		return lobPrefix(c.Strlen) + "text"
+	case util.ColumnBlob:
+		return lobPrefix(c.Strlen) + "blob"
+	case util.ColumnDate:
+		return "datetime"
+	case util.ColumnDateOnly:
+		return "date"
+	case util.ColumnBoolean:
+		return "boolean"
+	case util.ColumnJSON:
+		return "json"
+	case util.ColumnEnum:
+		elems := make([]string, len(c.Elems))
+		for i, elem := range c.Elems {
+			elems[i] = "'" + strings.Replace(elem, "'", "''", -1) + "'"
+		}
+		return "enum(" + strings.Join(elems, ",") + ")"
+	case util.ColumnDatetime, util.ColumnTimestamp:
+		name := strings.ToLower(c.Type.String())
+		if c.Fsp > 0 {
+			return fmt.Sprintf("%s(%d)", name, c.Fsp)
+		}
+		return name
+	case util.ColumnFloat:
+		return "float"
+	case util.ColumnDouble:
+		return "double"
+	case util.ColumnDecimal:
+		return fmt.Sprintf("decimal(%d,%d)", c.Precision, c.Scale)
+	default:
+		return c.Type.String()
+	}
+}
+
+// intTypeSQL returns the integer type of width bytes, 0 for INT.
+func intTypeSQL(width int) string {
+	for name, w := range intWidths {
+		if w == width {
+			return name
+		}
+	}
+	return "int"
+}
+
+// lobPrefix returns prefix of TEXT or BLOB type with max length.
+func lobPrefix(length int) string {
+	for prefix, size := range lobSizes {
+		if size == length {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// columnKey tells which key column belongs to: PRI for primary key,
+// UNI for an unique index of only it and MUL for the first column of
+// an unique index of multiple columns.
+func columnKey(schema *util.Schema, name string) string {
+	if schema.IsPrimaryKey(name) {
+		return "PRI"
+	}
+	key := ""
+	for _, idx := range schema.Indexes {
+		if idx.Columns[0] != name {
+			continue
+		}
+		if len(idx.Columns) == 1 {
+			return "UNI"
+		}
+		key = "MUL"
+	}
+	return key
+}
+
+// defaultText returns DEFAULT of column c as shown by DESCRIBE,
+// strings are unquoted.
+func defaultText(c *util.Column) string {
+	stmt, err := sqlparser.Parse("select " + *c.Default)
+	if err != nil {
+		return *c.Default
+	}
+	expr := stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr
+	if val, ok := expr.(*sqlparser.SQLVal); ok && val.Type == sqlparser.StrVal {
+		return string(val.Val)
+	}
+	return strings.ToUpper(*c.Default)
+}
+
+// createTableSQL returns a CREATE TABLE statement of schema, which
+// can be parsed by us.
+func createTableSQL(schema *util.Schema) string {
+	var defs []string
+	for _, c := range schema.Columns {
+		def := quoteName(c.Name) + " " + columnTypeSQL(c)
+		if c.NotNull {
+			def += " NOT NULL"
+		}
+		if c.Default != nil {
+			def += " DEFAULT " + *c.Default
+		} else if !c.NotNull && !c.AutoIncrement {
+			def += " DEFAULT NULL"
+		}
+		if c.AutoIncrement {
+			def += " AUTO_INCREMENT"
+		}
+		defs = append(defs, def)
+	}
+	defs = append(defs, "PRIMARY KEY "+quoteNames(schema.PrimaryKey))
+	for _, idx := range schema.Indexes {
+		defs = append(defs, "UNIQUE KEY "+quoteName(idx.Name)+" "+quoteNames(idx.Columns))
+	}
+	return "CREATE TABLE " + quoteName(schema.TableName) + " (" + strings.Join(defs, ", ") + ")"
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func quoteNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteName(name)
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
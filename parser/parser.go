@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	"github.com/xwb1989/sqlparser"
 )
 
@@ -12,6 +16,218 @@ func New() *Parser {
 }
 
 func (p *Parser) Parse(sql string) sqlparser.Statement {
-	stmt, _ := sqlparser.Parse(sql)
+	if tableDefRegexp.MatchString(sql) {
+		sql = rewriteColumnOptions(sql)
+	}
+	if show := parseShowOnTable(sql); show != nil {
+		return show
+	}
+	stmt, _ := sqlparser.Parse(rewriteTemporalSyntax(sql))
+	if ddl, ok := stmt.(*sqlparser.DDL); ok && ddl.Action == sqlparser.AlterStr {
+		return parseAlterTable(ddl, sql)
+	}
+	if ddl, ok := stmt.(*sqlparser.DBDDL); ok {
+		// sqlparser drops IF [NOT] EXISTS of CREATE/DROP DATABASE
+		ddl.IfExists = dbIfExistsRegexp.MatchString(sql)
+	}
 	return stmt
 }
+
+// Split splits sql into statements separated by semicolons. sql is
+// returned as it is if it can't be split, parsing it reports the error.
+func Split(sql string) []string {
+	pieces, err := sqlparser.SplitStatementToPieces(sql)
+	if err != nil {
+		return []string{sql}
+	}
+	var stmts []string
+	for _, piece := range pieces {
+		if strings.TrimSpace(piece) != "" {
+			stmts = append(stmts, piece)
+		}
+	}
+	if len(stmts) == 0 {
+		return []string{sql}
+	}
+	return stmts
+}
+
+var (
+	dateLiteralRegexp = regexp.MustCompile(`(?i)\b(date|timestamp)\s+('(?:[^'\\]|\\.|'')*')`)
+	extractRegexp     = regexp.MustCompile(`(?i)\bextract\s*\(\s*(\w+)\s+from\b`)
+)
+
+// rewriteTemporalSyntax turns syntax of temporal values sqlparser can't
+// parse into function calls, `DATE '2020-01-01'` becomes `date('2020-01-01')`
+// and `EXTRACT(YEAR FROM d)` becomes `extract(YEAR, d)`.
+func rewriteTemporalSyntax(sql string) string {
+	sql = replaceOutsideStrings(sql, dateLiteralRegexp, "$1($2)")
+	return replaceOutsideStrings(sql, extractRegexp, "extract($1,")
+}
+
+// replaceOutsideStrings is ReplaceAllString of re, but matches whose
+// last group starts inside a quoted string are kept. Parts of a match
+// before it may be quoted, like the closing backtick of a column name.
+func replaceOutsideStrings(sql string, re *regexp.Regexp, repl string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(sql, -1) {
+		if insideString(sql, m[len(m)-2]) {
+			continue
+		}
+		b.WriteString(sql[last:m[0]])
+		b.Write(re.ExpandString(nil, repl, sql, m))
+		last = m[1]
+	}
+	b.WriteString(sql[last:])
+	return b.String()
+}
+
+// insideString tells whether position i of sql is in a quoted string.
+func insideString(sql string, i int) bool {
+	var quote byte
+	for j := 0; j < i; j++ {
+		switch c := sql[j]; {
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		case quote != 0 && c == '\\':
+			j++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return quote != 0
+}
+
+var dbIfExistsRegexp = regexp.MustCompile("(?is)^\\s*(?:create|drop)\\s+(?:database|schema)\\s+if\\s+(?:not\\s+)?exists\\b")
+
+var (
+	describeRegexp    = regexp.MustCompile("(?is)^\\s*(?:describe|desc)\\s+(\\S+)[\\s;]*$")
+	showColumnsRegexp = regexp.MustCompile("(?is)^\\s*show\\s+(?:full\\s+)?(?:columns|fields)\\s+(?:from|in)\\s+(\\S+?)(?:\\s+(?:from|in)\\s+(\\S+?))?[\\s;]*$")
+	showCreateRegexp  = regexp.MustCompile("(?is)^\\s*show\\s+create\\s+table\\s+(\\S+?)[\\s;]*$")
+)
+
+// parseShowOnTable parses statements showing information of a table,
+// sqlparser drops the table name of them. DESCRIBE is the same as
+// SHOW COLUMNS. It returns nil if sql is not one of them.
+func parseShowOnTable(sql string) *sqlparser.Show {
+	show := &sqlparser.Show{Type: "columns"}
+	var name, db string
+	if m := describeRegexp.FindStringSubmatch(sql); m != nil {
+		name = m[1]
+	} else if m := showColumnsRegexp.FindStringSubmatch(sql); m != nil {
+		name, db = m[1], m[2]
+	} else if m := showCreateRegexp.FindStringSubmatch(sql); m != nil {
+		show.Type = "create table"
+		name = m[1]
+	} else {
+		return nil
+	}
+	table, ok := parseTableName(name)
+	if !ok {
+		return nil
+	}
+	if db != "" {
+		table.Qualifier = sqlparser.NewTableIdent(strings.Trim(db, "`"))
+	}
+	show.OnTable = table
+	return show
+}
+
+// parseTableName parses a possibly qualified and quoted table name.
+func parseTableName(name string) (sqlparser.TableName, bool) {
+	stmt, err := sqlparser.Parse("select * from " + name)
+	if err != nil {
+		return sqlparser.TableName{}, false
+	}
+	from := stmt.(*sqlparser.Select).From
+	if len(from) != 1 {
+		return sqlparser.TableName{}, false
+	}
+	alias, ok := from[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return sqlparser.TableName{}, false
+	}
+	table, ok := alias.Expr.(sqlparser.TableName)
+	return table, ok && alias.As.IsEmpty()
+}
+
+var (
+	tableDefRegexp    = regexp.MustCompile("(?is)^\\s*(create|alter)\\s+table\\s")
+	defaultNowRegexp  = regexp.MustCompile("(?i)\\bdefault\\s+(now|current_timestamp|localtime|localtimestamp)\\s*\\(\\s*\\d*\\s*\\)")
+	defaultSignRegexp = regexp.MustCompile("(?i)\\bdefault\\s+([-+]\\s*[0-9.]+)")
+	autoIncRegexp     = regexp.MustCompile("(?i)\\b(primary\\s+key|unique\\s+key|unique)\\s+auto_increment\\b")
+	defaultBoolRegexp = regexp.MustCompile("(?i)\\bdefault\\s+(true|false)\\b")
+	boolTypeRegexp    = regexp.MustCompile("(?i)([\\w`]\\s+)(bool|boolean)\\b")
+)
+
+// BoolTypeLength is the length of TINYINT rewritten from BOOL or
+// BOOLEAN, which tells it apart from a TINYINT(1) integer.
+const BoolTypeLength = "01"
+
+// rewriteColumnOptions turns column options sqlparser can't parse into
+// equivalent ones it can. `DEFAULT NOW()` becomes `DEFAULT CURRENT_TIMESTAMP`,
+// signed numbers in DEFAULT become strings, AUTO_INCREMENT is moved
+// before key options, and BOOL or BOOLEAN becomes TINYINT(BoolTypeLength),
+// whose DEFAULT TRUE or FALSE becomes 1 or 0.
+func rewriteColumnOptions(sql string) string {
+	sql = replaceOutsideStrings(sql, boolTypeRegexp, "${1}tinyint("+BoolTypeLength+")")
+	sql = defaultBoolRegexp.ReplaceAllStringFunc(sql, func(s string) string {
+		if strings.HasSuffix(strings.ToLower(s), "true") {
+			return "default 1"
+		}
+		return "default 0"
+	})
+	sql = autoIncRegexp.ReplaceAllString(sql, "auto_increment $1")
+	sql = defaultNowRegexp.ReplaceAllString(sql, "default current_timestamp")
+	return defaultSignRegexp.ReplaceAllStringFunc(sql, func(s string) string {
+		m := defaultSignRegexp.FindStringSubmatch(s)
+		return "default '" + strings.Join(strings.Fields(m[1]), "") + "'"
+	})
+}
+
+// AlterTable is an ALTER TABLE statement changing one column.
+// sqlparser only keeps the table name of it, so the rest is parsed here.
+type AlterTable struct {
+	*sqlparser.DDL
+
+	// One of "add", "drop" and "modify"
+	Action string
+	// Definition of added or modified column
+	Column *sqlparser.ColumnDefinition
+	// Name of dropped column
+	ColumnName sqlparser.ColIdent
+	// Err is the syntax error of column definition, if any
+	Err error
+}
+
+var alterTableRegexp = regexp.MustCompile("(?is)^\\s*alter\\s+table\\s+(?:`[^`]*`|[^\\s`]+)\\s+(add|drop|modify)\\s+(?:column\\s+)?(.*?)[\\s;]*$")
+
+func parseAlterTable(ddl *sqlparser.DDL, sql string) sqlparser.Statement {
+	m := alterTableRegexp.FindStringSubmatch(sql)
+	if m == nil {
+		return nil
+	}
+	alter := &AlterTable{
+		DDL:    ddl,
+		Action: strings.ToLower(m[1]),
+	}
+	if alter.Action == "drop" {
+		alter.ColumnName = sqlparser.NewColIdent(strings.Trim(m[2], "`"))
+		return alter
+	}
+	// Borrow the column definition parser of CREATE TABLE, which
+	// is strict so a partial statement isn't returned
+	stmt, err := sqlparser.ParseStrictDDL("create table t (" + m[2] + ")")
+	if err != nil {
+		alter.Err = fmt.Errorf("column definition '%s': %v", m[2], err)
+		return alter
+	}
+	spec := stmt.(*sqlparser.DDL).TableSpec
+	if spec == nil || len(spec.Columns) != 1 || len(spec.Indexes) != 0 {
+		alter.Err = fmt.Errorf("column definition '%s': expect one column", m[2])
+		return alter
+	}
+	alter.Column = spec.Columns[0]
+	return alter
+}
@@ -3,4 +3,19 @@ package server
 type Config struct {
 	HttpPort string
 	DataPath string
+
+	// Upgrade rows to the latest schema in background after ALTER TABLE
+	RewriteOnAlter bool
+
+	// Time zone of DATETIME values, like +08:00 or Asia/Shanghai,
+	// the system one is used if it's empty
+	TimeZone string
+
+	// Storage engine, leveldb by default, btree for the native B+tree,
+	// or memory to keep nothing after exit. Only schema files are read
+	// from DataPath in memory.
+	StorageEngine string
+	// When WAL of btree is synced: always (on every commit, by default),
+	// interval (every 100ms) or none (left to the system)
+	WALSync string
 }
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"strconv"
+
 	"github.com/gin-gonic/gin"
+	"github.com/leiysky/a-database/parser"
 	"github.com/leiysky/a-database/util"
 )
 
@@ -14,6 +17,8 @@ func (s *Server) ping(ctx *gin.Context) {
 func (s *Server) query(ctx *gin.Context) {
 	type Req struct {
 		Query string `json:"query"`
+		// Database is the current database of query, the default one if it's empty.
+		Database string `json:"database"`
 	}
 	req := &Req{}
 	err := ctx.BindJSON(req)
@@ -21,8 +26,34 @@ func (s *Server) query(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{
 			"msg": err.Error(),
 		})
+		return
+	}
+	session := s.db.NewSession()
+	if req.Database != "" {
+		if err := session.Use(req.Database); err != nil {
+			ctx.JSON(400, gin.H{
+				"msg": err.Error(),
+			})
+			return
+		}
+	}
+	// Statements of a query share the session, results of the
+	// last one are returned.
+	var results []*util.Row
+	for _, stmt := range parser.Split(req.Query) {
+		if results, err = session.ExecuteQuery(stmt); err != nil {
+			break
+		}
+	}
+	if id := session.LastInsertID(); id != 0 {
+		ctx.Header("X-Last-Insert-Id", strconv.FormatInt(id, 10))
+	}
+	if err != nil {
+		ctx.JSON(400, gin.H{
+			"msg": err.Error(),
+		})
+		return
 	}
-	results := s.db.ExecuteQuery(req.Query)
 
 	ctx.String(200, util.Prettify(results))
 }
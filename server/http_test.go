@@ -0,0 +1,41 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestLastInsertID(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewServer(&Config{DataPath: dir, StorageEngine: "memory"})
+	s.bootstrap()
+	query := func(sql string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "`+sql+`"}`))
+		s.http.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(query("create table t (id int auto_increment primary key, v int)").Code, 200)
+
+	w := query("insert into t (v) values (1), (2); insert into t (v) values (3); select last_insert_id()")
+	assert.Equal(w.Code, 200)
+	assert.Equal(w.Header().Get("X-Last-Insert-Id"), "3")
+	assert.True(strings.Contains(w.Body.String(), "║ 3 "))
+
+	// Another request is another session
+	w = query("select last_insert_id()")
+	assert.True(strings.Contains(w.Body.String(), "║ 0 "))
+}
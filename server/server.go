@@ -1,12 +1,11 @@
 package server
 
 import (
-	"io/ioutil"
-	"os"
+	"fmt"
 	"path"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/leiysky/a-database/catalog"
 	"github.com/leiysky/a-database/context"
 	"github.com/leiysky/a-database/db"
 	"github.com/leiysky/a-database/storage"
@@ -14,7 +13,6 @@ import (
 )
 
 type Server struct {
-	id   util.IDGenerator
 	http *gin.Engine
 	cfg  *Config
 	db   *db.DB
@@ -22,7 +20,6 @@ type Server struct {
 
 func NewServer(config *Config) *Server {
 	return &Server{
-		id:   util.NewIDGenerator(0),
 		http: gin.New(),
 		cfg:  config,
 	}
@@ -38,28 +35,41 @@ func (s *Server) Run() {
 
 // initialize environment
 func (s *Server) bootstrap() {
+	if s.cfg.TimeZone != "" {
+		loc, err := util.ParseTimeZone(s.cfg.TimeZone)
+		if err != nil {
+			panic(err)
+		}
+		util.TimeZone = loc
+	}
+
 	storeCfg := &storage.Config{
-		Path: path.Join(s.cfg.DataPath, "db"),
+		Engine:  s.cfg.StorageEngine,
+		WALSync: s.cfg.WALSync,
+		Path:    path.Join(s.cfg.DataPath, "db"),
+	}
+	store, err := storage.NewStorage(storeCfg)
+	if err != nil {
+		panic(fmt.Sprintf("can't open storage %q at %s: %v", s.cfg.StorageEngine, storeCfg.Path, err))
 	}
-	store := storage.NewKVStorage(storeCfg)
 
-	schemaPath := path.Join(s.cfg.DataPath, "schema")
-	schemas := make(map[string]*util.Schema)
-	filepath.Walk(schemaPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		buff, _ := ioutil.ReadFile(path)
-		schema := util.NewSchemaFromBytes(buff)
-		schema.TableName = filepath.Base(path)
-		schemas[filepath.Base(path)] = schema
-		return nil
-	})
+	cat := catalog.NewCatalog(store)
+	err = catalog.ImportSchemaDir(cat, store, path.Join(s.cfg.DataPath, "schema"))
+	if err != nil {
+		panic(err)
+	}
+	schemas, err := cat.Load()
+	if err != nil {
+		panic(err)
+	}
+	if err := catalog.Upgrade(store, schemas); err != nil {
+		panic(err)
+	}
 
-	ctx := context.NewContext(schemas, store)
+	opts := &context.Options{
+		RewriteOnAlter: s.cfg.RewriteOnAlter,
+	}
+	ctx := context.NewContext(schemas, cat, store, opts)
 	s.db = db.NewDB(ctx)
 
 	s.route()
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// btreeIterator implements iterator.Iterator on a version of tree,
+// the version is kept from being reused until it's released.
+type btreeIterator struct {
+	util.BasicReleaser
+
+	s       *BTreeStorage
+	root    pageID
+	version uint64
+	r       *util.Range
+
+	// path from root to current entry
+	path []btreeCursor
+	// Before the first key or after the last one, Next moves to
+	// the first key from the former and Prev to the last from the latter.
+	atStart, atEnd bool
+	released       bool
+	value          []byte
+	err            error
+}
+
+type btreeCursor struct {
+	p *page
+	i int
+}
+
+// newIterator returns an iterator of tree root, which takes over a
+// reader of version.
+func (s *BTreeStorage) newIterator(root pageID, version uint64, low, up []byte) Iterator {
+	return &btreeIterator{
+		s:       s,
+		root:    root,
+		version: version,
+		r:       &util.Range{Start: low, Limit: up},
+	}
+}
+
+func (i *btreeIterator) load(id pageID) *page {
+	if i.err != nil {
+		return nil
+	}
+	p, err := i.s.pool.get(id)
+	if err != nil {
+		i.err = err
+	}
+	return p
+}
+
+// descend goes down from root, choose returns index of child or
+// entry in a page. ok is false if the tree is empty.
+func (i *btreeIterator) descend(choose func(p *page) int) bool {
+	i.path = i.path[:0]
+	if i.root == 0 {
+		return false
+	}
+	for p := i.load(i.root); p != nil; {
+		c := btreeCursor{p: p, i: choose(p)}
+		i.path = append(i.path, c)
+		if p.isLeaf() {
+			return true
+		}
+		p = i.load(p.children[c.i])
+	}
+	return false
+}
+
+// forward moves to the next entry, the current one may be past the end of leaf.
+func (i *btreeIterator) forward() bool {
+	leaf := &i.path[len(i.path)-1]
+	if leaf.i++; leaf.i < len(leaf.p.keys) {
+		return true
+	}
+	// Go up until there is a child on the right, then go down to its first leaf
+	depth := len(i.path) - 2
+	for ; depth >= 0 && i.path[depth].i+1 >= len(i.path[depth].p.children); depth-- {
+	}
+	if depth < 0 {
+		return false
+	}
+	i.path[depth].i++
+	for d := depth; d < len(i.path)-1; d++ {
+		p := i.load(i.path[d].p.children[i.path[d].i])
+		if p == nil {
+			return false
+		}
+		i.path[d+1] = btreeCursor{p: p}
+	}
+	return true
+}
+
+// backward moves to the previous entry, the current one may be before the start of leaf.
+func (i *btreeIterator) backward() bool {
+	leaf := &i.path[len(i.path)-1]
+	if leaf.i--; leaf.i >= 0 {
+		return true
+	}
+	depth := len(i.path) - 2
+	for ; depth >= 0 && i.path[depth].i == 0; depth-- {
+	}
+	if depth < 0 {
+		return false
+	}
+	i.path[depth].i--
+	for d := depth; d < len(i.path)-1; d++ {
+		p := i.load(i.path[d].p.children[i.path[d].i])
+		if p == nil {
+			return false
+		}
+		last := len(p.keys) - 1
+		if !p.isLeaf() {
+			last = len(p.children) - 1
+		}
+		i.path[d+1] = btreeCursor{p: p, i: last}
+	}
+	return true
+}
+
+// settle checks the entry moved to, ok is the result of the move.
+func (i *btreeIterator) settle(ok, forward bool) bool {
+	i.value = nil
+	if ok && i.err == nil {
+		k := i.Key()
+		if forward && (i.r.Limit == nil || bytes.Compare(k, i.r.Limit) < 0) ||
+			!forward && (i.r.Start == nil || bytes.Compare(k, i.r.Start) >= 0) {
+			i.atStart, i.atEnd = false, false
+			return true
+		}
+	}
+	i.path = i.path[:0]
+	i.atStart, i.atEnd = !forward, forward
+	return false
+}
+
+// seek moves to the least key not less than k.
+func (i *btreeIterator) seek(k []byte) bool {
+	ok := i.descend(func(p *page) int {
+		if k == nil {
+			return 0
+		}
+		if p.isLeaf() {
+			return sort.Search(len(p.keys), func(j int) bool {
+				return bytes.Compare(p.keys[j], k) >= 0
+			})
+		}
+		return childIndex(p, k)
+	})
+	if ok && i.path[len(i.path)-1].i == len(i.path[len(i.path)-1].p.keys) {
+		ok = i.forward()
+	}
+	return i.settle(ok, true)
+}
+
+func (i *btreeIterator) First() bool {
+	if i.released {
+		return false
+	}
+	return i.seek(i.r.Start)
+}
+
+func (i *btreeIterator) Last() bool {
+	if i.released {
+		return false
+	}
+	// Move to the greatest key less than limit
+	limit := i.r.Limit
+	ok := i.descend(func(p *page) int {
+		n := len(p.keys)
+		if limit != nil {
+			n = sort.Search(len(p.keys), func(j int) bool {
+				return bytes.Compare(p.keys[j], limit) >= 0
+			})
+		}
+		if p.isLeaf() {
+			return n - 1
+		}
+		return n
+	})
+	if ok && i.path[len(i.path)-1].i < 0 {
+		ok = i.backward()
+	}
+	return i.settle(ok, false)
+}
+
+func (i *btreeIterator) Seek(key []byte) bool {
+	if i.released {
+		return false
+	}
+	if i.r.Start != nil && bytes.Compare(key, i.r.Start) < 0 {
+		key = i.r.Start
+	}
+	return i.seek(key)
+}
+
+func (i *btreeIterator) Next() bool {
+	switch {
+	case i.atEnd || i.released:
+		return false
+	case len(i.path) == 0:
+		return i.First()
+	default:
+		return i.settle(i.forward(), true)
+	}
+}
+
+func (i *btreeIterator) Prev() bool {
+	switch {
+	case i.atStart || i.released:
+		return false
+	case len(i.path) == 0:
+		return i.Last()
+	default:
+		return i.settle(i.backward(), false)
+	}
+}
+
+func (i *btreeIterator) Valid() bool {
+	return len(i.path) > 0
+}
+
+// Key returns the key of current entry, it must not be modified.
+func (i *btreeIterator) Key() []byte {
+	if len(i.path) == 0 {
+		return nil
+	}
+	c := i.path[len(i.path)-1]
+	k := c.p.keys[c.i]
+	return k[:len(k):len(k)]
+}
+
+// Value returns the value of current entry, it must not be modified.
+func (i *btreeIterator) Value() []byte {
+	if len(i.path) == 0 {
+		return nil
+	}
+	if i.value == nil {
+		c := i.path[len(i.path)-1]
+		v := c.p.values[c.i]
+		if v.overflow == 0 {
+			return v.data[:len(v.data):len(v.data)]
+		}
+		var err error
+		if i.value, err = i.s.readValue(v); err != nil {
+			i.err = err
+		}
+	}
+	return i.value
+}
+
+func (i *btreeIterator) Error() error {
+	return i.err
+}
+
+func (i *btreeIterator) Release() {
+	if !i.released {
+		i.released = true
+		i.path = nil
+		i.s.release(i.version)
+		i.BasicReleaser.Release()
+	}
+}
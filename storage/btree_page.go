@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// Pages of BTreeStorage file are:
+//
+//	page 0, 1: meta, written in turns so one of them is always intact
+//	others:    nodes of tree, overflow pages or free pages
+//
+// A node page starts with the header:
+//
+//	[type][reserved][count uint16][version uint64][checksum uint32]
+//
+// version is the version of tree the page was written in, checksum is
+// CRC-32 (IEEE) of the page with checksum itself as zero. Integers
+// are in big endian.
+//
+// A leaf has count entries of
+//
+//	[uvarint key length][key][flag][uvarint value length][value or first overflow page]
+//
+// where flag tells whether the value is in overflow pages. A branch
+// has count keys and count+1 children:
+//
+//	[child 0][uvarint key length][key 0][child 1]...[key n-1][child n]
+//
+// Keys of subtree child i are in [key i-1, key i). An overflow page
+// holds a part of value:
+//
+//	[next page][data length uint16][data]
+const (
+	pageLeaf byte = iota + 1
+	pageBranch
+	pageOverflow
+)
+
+const (
+	pageHeaderSize = 16
+	pageIDSize     = 8
+	metaMagic      = "ADBTREE1"
+	metaSize       = 8 + 4 + 8 + 8 + 8 + 8 + 4
+)
+
+type pageID uint64
+
+// page is a decoded page of file, it's never changed after written
+// into buffer pool except by the transaction creating it.
+type page struct {
+	id      pageID
+	typ     byte
+	version uint64
+
+	// leaf and branch
+	keys [][]byte
+	// leaf
+	values []pageValue
+	// branch
+	children []pageID
+
+	// overflow
+	next pageID
+	data []byte
+}
+
+// pageValue is a value in leaf, it's in overflow pages starting at
+// overflow if that's not zero.
+type pageValue struct {
+	data     []byte
+	overflow pageID
+	length   int
+}
+
+// meta is the root of everything in file, it's written by checkpoint
+// which includes records of WAL up to lsn.
+type meta struct {
+	pageSize  int
+	version   uint64
+	root      pageID
+	pageCount uint64
+	lsn       uint64
+}
+
+var errCorruptedPage = errors.New("page checksum mismatch")
+
+func (p *page) isLeaf() bool {
+	return p.typ == pageLeaf
+}
+
+// clone copies p for modification, slices are copied but not their elements.
+func (p *page) clone() *page {
+	c := &page{typ: p.typ}
+	c.keys = append([][]byte{}, p.keys...)
+	if p.isLeaf() {
+		c.values = append([]pageValue{}, p.values...)
+	} else {
+		c.children = append([]pageID{}, p.children...)
+	}
+	return c
+}
+
+func uvarintSize(v int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(v))
+}
+
+func leafEntrySize(key []byte, v pageValue) int {
+	size := uvarintSize(len(key)) + len(key) + 1 + uvarintSize(v.length)
+	if v.overflow != 0 {
+		return size + pageIDSize
+	}
+	return size + len(v.data)
+}
+
+func branchEntrySize(key []byte) int {
+	return uvarintSize(len(key)) + len(key) + pageIDSize
+}
+
+// size returns number of bytes p takes when encoded.
+func (p *page) size() int {
+	size := pageHeaderSize
+	switch p.typ {
+	case pageLeaf:
+		for i, k := range p.keys {
+			size += leafEntrySize(k, p.values[i])
+		}
+	case pageBranch:
+		size += pageIDSize
+		for _, k := range p.keys {
+			size += branchEntrySize(k)
+		}
+	case pageOverflow:
+		size += pageIDSize + 2 + len(p.data)
+	}
+	return size
+}
+
+// encode writes p into buf of a page size, p must fit in it.
+func (p *page) encode(buf []byte) {
+	if size := p.size(); size > len(buf) {
+		panic(fmt.Sprintf("page %d takes %d bytes", p.id, size))
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+	buf[0] = p.typ
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(p.keys)))
+	binary.BigEndian.PutUint64(buf[4:], p.version)
+	b := buf[pageHeaderSize:pageHeaderSize]
+	switch p.typ {
+	case pageLeaf:
+		for i, k := range p.keys {
+			v := p.values[i]
+			b = appendBytes(b, k)
+			if v.overflow != 0 {
+				b = append(b, 1)
+				b = appendUvarint(b, v.length)
+				b = appendPageID(b, v.overflow)
+			} else {
+				b = append(b, 0)
+				b = appendBytes(b, v.data)
+			}
+		}
+	case pageBranch:
+		b = appendPageID(b, p.children[0])
+		for i, k := range p.keys {
+			b = appendBytes(b, k)
+			b = appendPageID(b, p.children[i+1])
+		}
+	case pageOverflow:
+		b = appendPageID(b, p.next)
+		b = append(b, byte(len(p.data)>>8), byte(len(p.data)))
+		b = append(b, p.data...)
+	}
+	binary.BigEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(buf))
+}
+
+func appendUvarint(b []byte, v int) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(v))
+	return append(b, buf[:n]...)
+}
+
+func appendBytes(b, v []byte) []byte {
+	return append(appendUvarint(b, len(v)), v...)
+}
+
+func appendPageID(b []byte, id pageID) []byte {
+	var buf [pageIDSize]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(id))
+	return append(b, buf[:]...)
+}
+
+// decodePage decodes page id from buf, slices of result share memory with buf.
+func decodePage(id pageID, buf []byte) (*page, error) {
+	checksum := binary.BigEndian.Uint32(buf[12:])
+	binary.BigEndian.PutUint32(buf[12:], 0)
+	if crc32.ChecksumIEEE(buf) != checksum {
+		return nil, fmt.Errorf("page %d: %v", id, errCorruptedPage)
+	}
+	p := &page{
+		id:      id,
+		typ:     buf[0],
+		version: binary.BigEndian.Uint64(buf[4:]),
+	}
+	count := int(binary.BigEndian.Uint16(buf[2:]))
+	r := &pageReader{buf: buf, offset: pageHeaderSize}
+	switch p.typ {
+	case pageLeaf:
+		for i := 0; i < count; i++ {
+			p.keys = append(p.keys, r.bytes())
+			var v pageValue
+			if r.byte() != 0 {
+				v.length = r.uvarint()
+				v.overflow = r.pageID()
+			} else {
+				v.data = r.bytes()
+				v.length = len(v.data)
+			}
+			p.values = append(p.values, v)
+		}
+	case pageBranch:
+		p.children = append(p.children, r.pageID())
+		for i := 0; i < count; i++ {
+			p.keys = append(p.keys, r.bytes())
+			p.children = append(p.children, r.pageID())
+		}
+	case pageOverflow:
+		p.next = r.pageID()
+		length := int(r.byte())<<8 | int(r.byte())
+		p.data = r.slice(length)
+	default:
+		return nil, fmt.Errorf("page %d: unknown type %d", id, p.typ)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("page %d: %v", id, r.err)
+	}
+	return p, nil
+}
+
+// pageReader reads fields of page, the first error is kept and
+// zero values are returned after it.
+type pageReader struct {
+	buf    []byte
+	offset int
+	err    error
+}
+
+func (r *pageReader) slice(n int) []byte {
+	if r.err != nil || n < 0 || r.offset+n > len(r.buf) {
+		r.err = errors.New("page is truncated")
+		return nil
+	}
+	b := r.buf[r.offset : r.offset+n : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *pageReader) byte() byte {
+	if b := r.slice(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pageReader) uvarint() int {
+	if r.err != nil || r.offset >= len(r.buf) {
+		r.err = errors.New("page is truncated")
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.offset:])
+	if n <= 0 || v > math.MaxInt32 {
+		r.err = errors.New("page is truncated")
+		return 0
+	}
+	r.offset += n
+	return int(v)
+}
+
+func (r *pageReader) bytes() []byte {
+	return r.slice(r.uvarint())
+}
+
+func (r *pageReader) pageID() pageID {
+	if b := r.slice(pageIDSize); b != nil {
+		return pageID(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (m *meta) encode(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	copy(buf, metaMagic)
+	binary.BigEndian.PutUint32(buf[8:], uint32(m.pageSize))
+	binary.BigEndian.PutUint64(buf[12:], m.version)
+	binary.BigEndian.PutUint64(buf[20:], uint64(m.root))
+	binary.BigEndian.PutUint64(buf[28:], m.pageCount)
+	binary.BigEndian.PutUint64(buf[36:], m.lsn)
+	binary.BigEndian.PutUint32(buf[44:], crc32.ChecksumIEEE(buf[:44]))
+}
+
+// decodeMeta returns nil if buf isn't an intact meta.
+func decodeMeta(buf []byte) *meta {
+	if len(buf) < metaSize || string(buf[:8]) != metaMagic ||
+		crc32.ChecksumIEEE(buf[:44]) != binary.BigEndian.Uint32(buf[44:]) {
+		return nil
+	}
+	return &meta{
+		pageSize:  int(binary.BigEndian.Uint32(buf[8:])),
+		version:   binary.BigEndian.Uint64(buf[12:]),
+		root:      pageID(binary.BigEndian.Uint64(buf[20:])),
+		pageCount: binary.BigEndian.Uint64(buf[28:]),
+		lsn:       binary.BigEndian.Uint64(buf[36:]),
+	}
+}
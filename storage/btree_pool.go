@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// bufferPool caches decoded pages of file, at most capacity of them
+// are kept and the least recently used one is evicted first. Dirty
+// pages are written back when evicted or flushed.
+//
+// Pages are never changed after put, so a page got from pool stays
+// valid after being evicted.
+type bufferPool struct {
+	mu       sync.Mutex
+	file     file
+	pageSize int
+	capacity int
+	frames   map[pageID]*list.Element
+	lru      *list.List
+}
+
+type frame struct {
+	page  *page
+	dirty bool
+}
+
+func newBufferPool(file file, pageSize, capacity int) *bufferPool {
+	return &bufferPool{
+		file:     file,
+		pageSize: pageSize,
+		capacity: capacity,
+		frames:   make(map[pageID]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns page id, reading it from file if it's not cached.
+func (bp *bufferPool) get(id pageID) (*page, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if e, ok := bp.frames[id]; ok {
+		bp.lru.MoveToFront(e)
+		return e.Value.(*frame).page, nil
+	}
+	buf := make([]byte, bp.pageSize)
+	if _, err := bp.file.ReadAt(buf, int64(id)*int64(bp.pageSize)); err != nil {
+		return nil, err
+	}
+	p, err := decodePage(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	return p, bp.add(&frame{page: p})
+}
+
+// put caches p as a dirty page, it replaces the page of the same id.
+func (bp *bufferPool) put(p *page) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if e, ok := bp.frames[p.id]; ok {
+		bp.lru.MoveToFront(e)
+		e.Value = &frame{page: p, dirty: true}
+		return nil
+	}
+	return bp.add(&frame{page: p, dirty: true})
+}
+
+func (bp *bufferPool) add(f *frame) error {
+	bp.frames[f.page.id] = bp.lru.PushFront(f)
+	for bp.lru.Len() > bp.capacity {
+		e := bp.lru.Back()
+		victim := e.Value.(*frame)
+		if victim.dirty {
+			if err := bp.write(victim.page); err != nil {
+				return err
+			}
+		}
+		bp.lru.Remove(e)
+		delete(bp.frames, victim.page.id)
+	}
+	return nil
+}
+
+func (bp *bufferPool) write(p *page) error {
+	buf := make([]byte, bp.pageSize)
+	p.encode(buf)
+	_, err := bp.file.WriteAt(buf, int64(p.id)*int64(bp.pageSize))
+	return err
+}
+
+// flush writes all dirty pages in order of their IDs.
+func (bp *bufferPool) flush() error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	var dirty []*frame
+	for _, e := range bp.frames {
+		if f := e.Value.(*frame); f.dirty {
+			dirty = append(dirty, f)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool {
+		return dirty[i].page.id < dirty[j].page.id
+	})
+	for _, f := range dirty {
+		if err := bp.write(f.page); err != nil {
+			return err
+		}
+		f.dirty = false
+	}
+	return nil
+}
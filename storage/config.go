@@ -1,5 +1,52 @@
 package storage
 
+import (
+	"fmt"
+	"time"
+)
+
+// Engines a storage can be opened with.
+const (
+	EngineLevelDB = "leveldb"
+	// EngineMemory keeps data in memory, it's lost on exit.
+	EngineMemory = "memory"
+	// EngineBTree is BTreeStorage.
+	EngineBTree = "btree"
+)
+
 type Config struct {
+	// Engine is EngineLevelDB if it's empty
+	Engine string
+	// Path is the directory of LevelDB or BTreeStorage
 	Path string
-}
\ No newline at end of file
+
+	// PageSize of a new BTreeStorage, DefaultPageSize if it's zero
+	PageSize int
+	// CachePages is the number of pages cached by BTreeStorage,
+	// DefaultCachePages if it's zero
+	CachePages int
+	// WALSync is the policy of syncing WAL of BTreeStorage,
+	// WALSyncAlways if it's empty
+	WALSync         string
+	WALSyncInterval time.Duration
+	// CheckpointSize is the size of WAL which triggers checkpoint,
+	// DefaultCheckpointSize if it's zero
+	CheckpointSize int64
+
+	// fs replaces files of BTreeStorage in tests
+	fs fileSystem
+}
+
+// NewStorage opens a storage with the engine of cfg.
+func NewStorage(cfg *Config) (Storage, error) {
+	switch cfg.Engine {
+	case "", EngineLevelDB:
+		return NewKVStorage(cfg)
+	case EngineMemory:
+		return NewMemStorage(), nil
+	case EngineBTree:
+		return NewBTreeStorage(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", cfg.Engine)
+	}
+}
@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+var errCrashed = errors.New("crashed")
+
+// crashFS keeps files in memory and crashes after a number of writes,
+// every operation fails after that. restart returns what would be left
+// on disk: synced data and a random part of writes after sync, the
+// last of which may be torn.
+type crashFS struct {
+	mu    sync.Mutex
+	files map[string]*crashFile
+	// budget is number of writes before crash, negative for never
+	budget  int
+	crashed bool
+	// failSync makes Sync fail without crashing
+	failSync bool
+}
+
+type crashFile struct {
+	fs      *crashFS
+	durable []byte
+	data    []byte
+	pending []crashWrite
+}
+
+// crashWrite is a write or truncate not synced yet.
+type crashWrite struct {
+	offset   int64
+	data     []byte
+	truncate bool
+}
+
+func newCrashFS() *crashFS {
+	return &crashFS{files: make(map[string]*crashFile), budget: -1}
+}
+
+func (fs *crashFS) crash(after int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.budget = after
+}
+
+// tick counts a write, it's called with mu held.
+func (fs *crashFS) tick() error {
+	if fs.budget == 0 {
+		fs.crashed = true
+	}
+	if fs.crashed {
+		return errCrashed
+	}
+	if fs.budget > 0 {
+		fs.budget--
+	}
+	return nil
+}
+
+func (fs *crashFS) restart(r *rand.Rand) *crashFS {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	restarted := newCrashFS()
+	for name, f := range fs.files {
+		data := append([]byte{}, f.durable...)
+		for _, w := range f.pending {
+			if r.Intn(2) == 0 {
+				continue
+			}
+			if w.truncate {
+				data = resize(data, w.offset)
+				continue
+			}
+			written := w.data
+			if r.Intn(4) == 0 {
+				written = written[:r.Intn(len(written)+1)]
+			}
+			if end := w.offset + int64(len(written)); end > int64(len(data)) {
+				data = resize(data, end)
+			}
+			copy(data[w.offset:], written)
+		}
+		restarted.files[name] = &crashFile{fs: restarted, durable: data, data: append([]byte{}, data...)}
+	}
+	return restarted
+}
+
+func resize(b []byte, size int64) []byte {
+	if size <= int64(len(b)) {
+		return b[:size]
+	}
+	return append(b, make([]byte, size-int64(len(b)))...)
+}
+
+func (fs *crashFS) MkdirAll(dir string) error {
+	return nil
+}
+
+func (fs *crashFS) OpenFile(name string, create bool) (file, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.crashed {
+		return nil, errCrashed
+	}
+	f, ok := fs.files[name]
+	if !ok {
+		if !create {
+			return nil, os.ErrNotExist
+		}
+		f = &crashFile{fs: fs}
+		fs.files[name] = f
+	}
+	return f, nil
+}
+
+func (fs *crashFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.tick(); err != nil {
+		return err
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *crashFS) List(dir string) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var names []string
+	for name := range fs.files {
+		if filepath.Dir(name) == filepath.Clean(dir) {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *crashFS) SyncDir(dir string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.crashed {
+		return errCrashed
+	}
+	return nil
+}
+
+func (f *crashFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.fs.crashed {
+		return 0, errCrashed
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *crashFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.tick(); err != nil {
+		return 0, err
+	}
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = resize(f.data, end)
+	}
+	copy(f.data[off:], p)
+	f.pending = append(f.pending, crashWrite{offset: off, data: append([]byte{}, p...)})
+	return len(p), nil
+}
+
+func (f *crashFile) Size() (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return int64(len(f.data)), nil
+}
+
+func (f *crashFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.tick(); err != nil {
+		return err
+	}
+	f.data = resize(f.data, size)
+	f.pending = append(f.pending, crashWrite{offset: size, truncate: true})
+	return nil
+}
+
+func (f *crashFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.fs.failSync {
+		return errors.New("sync failed")
+	}
+	if err := f.fs.tick(); err != nil {
+		return err
+	}
+	f.durable = append([]byte{}, f.data...)
+	f.pending = nil
+	return nil
+}
+
+func (f *crashFile) Close() error {
+	return nil
+}
+
+type crashOp struct {
+	delete     bool
+	key, value string
+}
+
+func randomCrashOp(r *rand.Rand) crashOp {
+	op := crashOp{key: fmt.Sprintf("key%03d", r.Intn(200))}
+	if r.Intn(4) == 0 {
+		op.delete = true
+	} else {
+		// Some values are in overflow pages
+		op.value = strings.Repeat(string(rune('a'+r.Intn(26))), r.Intn(1500))
+	}
+	return op
+}
+
+func (op crashOp) apply(s Storage) error {
+	if op.delete {
+		return s.Delete([]byte(op.key))
+	}
+	return s.Put([]byte(op.key), []byte(op.value))
+}
+
+func (op crashOp) applyModel(model map[string]string) {
+	if op.delete {
+		delete(model, op.key)
+	} else {
+		model[op.key] = op.value
+	}
+}
+
+func dumpStorage(t *testing.T, s Storage) map[string]string {
+	state := make(map[string]string)
+	itr := s.ScanAll()
+	defer itr.Release()
+	for itr.Next() {
+		state[string(itr.Key())] = string(itr.Value())
+	}
+	if err := itr.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func equalStates(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// TestBTreeCrash crashes writes at random points, including those of
+// checkpoints, and checks that recovery gets the state after a prefix
+// of writes. All acknowledged writes are kept if WAL is synced on commit.
+func TestBTreeCrash(t *testing.T) {
+	assert := assert.New(t)
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 60; trial++ {
+		policy := WALSyncAlways
+		if trial%3 == 2 {
+			policy = WALSyncNone
+		}
+		fs := newCrashFS()
+		cfg := &Config{Engine: EngineBTree, Path: "db", PageSize: 512, CachePages: 8, CheckpointSize: 4096, WALSync: policy, fs: fs}
+		s, err := OpenBTreeStorage(cfg)
+		assert.Equal(err, nil)
+
+		fs.crash(r.Intn(3000))
+		var ops []crashOp
+		acked := 0
+		for {
+			op := randomCrashOp(r)
+			ops = append(ops, op)
+			if op.apply(s) != nil {
+				break
+			}
+			acked++
+		}
+
+		cfg.fs = fs.restart(r)
+		s, err = OpenBTreeStorage(cfg)
+		assert.Equal(err, nil)
+		state := dumpStorage(t, s)
+
+		// Find the prefix of ops recovered
+		from := acked
+		if policy != WALSyncAlways {
+			from = 0
+		}
+		model := make(map[string]string)
+		for _, op := range ops[:from] {
+			op.applyModel(model)
+		}
+		recovered := equalStates(state, model)
+		for i := from; i < len(ops) && !recovered; i++ {
+			ops[i].applyModel(model)
+			recovered = equalStates(state, model)
+		}
+		assert.True(recovered)
+
+		// The recovered storage works as usual
+		for i := 0; i < 50; i++ {
+			op := randomCrashOp(r)
+			assert.Equal(op.apply(s), nil)
+			op.applyModel(state)
+		}
+		assert.Equal(s.Close(), nil)
+		s, err = OpenBTreeStorage(cfg)
+		assert.Equal(err, nil)
+		assert.True(equalStates(dumpStorage(t, s), state))
+		assert.Equal(s.Close(), nil)
+	}
+}
+
+// TestBTreeCrashGroupCommit crashes concurrent writers, whose commits
+// share WAL syncs.
+func TestBTreeCrashGroupCommit(t *testing.T) {
+	assert := assert.New(t)
+	r := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 10; trial++ {
+		fs := newCrashFS()
+		cfg := &Config{Engine: EngineBTree, Path: "db", PageSize: 512, CheckpointSize: 16 << 10, fs: fs}
+		s, err := OpenBTreeStorage(cfg)
+		assert.Equal(err, nil)
+		fs.crash(200 + r.Intn(2000))
+
+		var mu sync.Mutex
+		acked := make(map[string]string)
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					k, v := fmt.Sprintf("w%d-%04d", w, i), strings.Repeat("v", i%700)
+					if s.Put([]byte(k), []byte(v)) != nil {
+						return
+					}
+					mu.Lock()
+					acked[k] = v
+					mu.Unlock()
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		cfg.fs = fs.restart(r)
+		s, err = OpenBTreeStorage(cfg)
+		assert.Equal(err, nil)
+		state := dumpStorage(t, s)
+		for k, v := range acked {
+			assert.Equal(state[k], v)
+		}
+		for k, v := range state {
+			var w, i int
+			fmt.Sscanf(k, "w%d-%d", &w, &i)
+			assert.Equal(v, strings.Repeat("v", i%700))
+		}
+		assert.Equal(s.Close(), nil)
+	}
+}
+
+// TestBTreeSyncFailure checks that a write is never seen if its WAL
+// record fails to sync, and the storage refuses writes after it.
+func TestBTreeSyncFailure(t *testing.T) {
+	assert := assert.New(t)
+	fs := newCrashFS()
+	s, err := OpenBTreeStorage(&Config{Engine: EngineBTree, Path: "db", PageSize: 512, fs: fs})
+	assert.Equal(err, nil)
+	assert.Equal(s.Put([]byte("k1"), []byte("v1")), nil)
+
+	fs.failSync = true
+	assert.NEqual(s.Put([]byte("k2"), []byte("v2")), nil)
+	fs.failSync = false
+	_, err = s.Get([]byte("k2"))
+	assert.Equal(err, ErrNotFound)
+	v, err := s.Get([]byte("k1"))
+	assert.Equal(err, nil)
+	assert.Equal(v, []byte("v1"))
+	assert.NEqual(s.Put([]byte("k3"), []byte("v3")), nil)
+	assert.NEqual(s.Close(), nil)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fileSystem is what BTreeStorage and WAL write files through, tests
+// replace it to inject crashes.
+type fileSystem interface {
+	MkdirAll(dir string) error
+	// OpenFile opens name for reading and writing, it's created if
+	// create is true and it doesn't exist.
+	OpenFile(name string, create bool) (file, error)
+	Remove(name string) error
+	// List returns sorted names of files in dir.
+	List(dir string) ([]string, error)
+	// SyncDir makes creation and removal of files in dir durable.
+	SyncDir(dir string) error
+}
+
+type file interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+type osFileSystem struct{}
+
+type osFile struct {
+	*os.File
+}
+
+func (osFileSystem) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (osFileSystem) OpenFile(name string, create bool) (file, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) List(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = filepath.Base(m)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (osFileSystem) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -1,6 +1,12 @@
 package storage
 
-import "github.com/syndtr/goleveldb/leveldb/iterator"
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// ErrNotFound is returned by Get when the key doesn't exist.
+var ErrNotFound = leveldb.ErrNotFound
 
 type Storage interface {
 	Get([]byte) ([]byte, error)
@@ -8,8 +14,72 @@ type Storage interface {
 	Delete([]byte) error
 	Scan([]byte, []byte) Iterator
 	ScanAll() Iterator
+	// Write applies all writes of batch or none of them.
+	Write(*WriteBatch) error
+	// Snapshot returns the storage as of now, it must be released
+	// after use.
+	Snapshot() (Snapshot, error)
+}
+
+// Snapshot is a read-only view of a storage at some moment, writes
+// after that are never seen through it.
+type Snapshot interface {
+	Get([]byte) ([]byte, error)
+	Scan([]byte, []byte) Iterator
+	ScanAll() Iterator
+	// Release frees the snapshot, iterators of it remain valid until
+	// they are released.
+	Release()
+}
+
+// WriteBatch collects puts and deletes to be written together by
+// Storage.Write, they're applied in order.
+type WriteBatch struct {
+	batch leveldb.Batch
+}
+
+func (b *WriteBatch) Put(k, v []byte) {
+	b.batch.Put(k, v)
+}
+
+func (b *WriteBatch) Delete(k []byte) {
+	b.batch.Delete(k)
+}
+
+// Len returns the number of writes in batch.
+func (b *WriteBatch) Len() int {
+	return b.batch.Len()
+}
+
+func (b *WriteBatch) Reset() {
+	b.batch.Reset()
+}
+
+// replay calls r with writes of batch in order, keys and values refer
+// to memory of batch.
+func (b *WriteBatch) replay(r leveldb.BatchReplay) error {
+	return b.batch.Replay(r)
 }
 
 type Iterator interface {
 	iterator.Iterator
 }
+
+// DeleteRange removes every key in [low, up) at once.
+func DeleteRange(s Storage, low, up []byte) error {
+	batch := new(WriteBatch)
+	if err := BatchDeleteRange(s, batch, low, up); err != nil {
+		return err
+	}
+	return s.Write(batch)
+}
+
+// BatchDeleteRange adds deletes of keys in [low, up) to batch.
+func BatchDeleteRange(s Storage, batch *WriteBatch, low, up []byte) error {
+	itr := s.Scan(low, up)
+	defer itr.Release()
+	for itr.Next() {
+		batch.Delete(itr.Key())
+	}
+	return itr.Error()
+}
@@ -0,0 +1,932 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var _ Storage = &BTreeStorage{}
+
+const (
+	// DefaultPageSize is the page size of new BTreeStorage files.
+	DefaultPageSize = 4096
+	// DefaultCachePages is the capacity of buffer pool of BTreeStorage.
+	DefaultCachePages = 1024
+	// DefaultCheckpointSize is the size of WAL which triggers checkpoint.
+	DefaultCheckpointSize = 4 << 20
+
+	btreeFileName = "btree.db"
+	btreeWALDir   = "wal"
+)
+
+var ErrKeyTooLong = errors.New("key is too long")
+
+// BTreeStorage is a B+tree in pages of a file. Pages are copied on
+// write: a write makes new pages for the path from root to the leaf
+// it changes, so the tree of a version is never changed, and an
+// iterator reads the version when it's created like a snapshot of
+// LevelDB.
+//
+// Writes are logged in WAL, and the tree of a write is seen by readers
+// after its record is durable. Pages changed stay in buffer pool until
+// evicted or checkpoint. Checkpoint writes
+// all of them and meta pointing to the new root, then records before
+// it are removed from WAL. On open, records after the last checkpoint
+// are redone on its tree.
+//
+// Pages no longer in the latest tree are freed, they are reused once
+// neither readers nor the tree on disk may refer to them. Free pages
+// are found by walking the tree on open, they are not stored.
+type BTreeStorage struct {
+	path     string
+	fs       fileSystem
+	file     file
+	pageSize int
+	pool     *bufferPool
+	wal      *WAL
+	// checkpointSize is the size of WAL which triggers checkpoint
+	checkpointSize int64
+
+	// writeMu is held through a write
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// root of the latest version seen by readers
+	root    pageID
+	version uint64
+	// readers counts readers of each version
+	readers map[uint64]int
+
+	// Guarded by writeMu
+	// latest is root of the tree made by the last write, which is
+	// published as root once it's durable
+	latest        pageID
+	latestVersion uint64
+	// err is the failure of WAL or checkpoint, writes made after a
+	// failed one are unknown to WAL, so all of them are refused
+	err       error
+	pageCount uint64
+	free      []pageID
+	pending   []freedPage
+	// committed is the version of tree on disk, it includes
+	// records of WAL up to checkpointLSN.
+	committed     uint64
+	checkpointLSN uint64
+	// metaSlot is the slot of the latest meta, the other one is
+	// written by the next checkpoint
+	metaSlot int
+}
+
+// freedPage is a page written in version allocated and removed
+// from tree in version freed.
+type freedPage struct {
+	id        pageID
+	allocated uint64
+	freed     uint64
+}
+
+// NewBTreeStorage opens or creates the B+tree in directory cfg.Path.
+func NewBTreeStorage(cfg *Config) (Storage, error) {
+	s, err := OpenBTreeStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenBTreeStorage is NewBTreeStorage returning *BTreeStorage.
+func OpenBTreeStorage(cfg *Config) (*BTreeStorage, error) {
+	fs := cfg.fs
+	if fs == nil {
+		fs = osFileSystem{}
+	}
+	if err := fs.MkdirAll(cfg.Path); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cfg.Path, btreeFileName)
+	file, err := fs.OpenFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+	s := &BTreeStorage{
+		path:           path,
+		fs:             fs,
+		file:           file,
+		readers:        make(map[uint64]int),
+		checkpointSize: cfg.CheckpointSize,
+	}
+	if s.checkpointSize <= 0 {
+		s.checkpointSize = DefaultCheckpointSize
+	}
+	if err := s.load(cfg); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+
+	opts := &WALOptions{Sync: cfg.WALSync, SyncInterval: cfg.WALSyncInterval, fs: fs}
+	redone := false
+	s.wal, err = OpenWAL(filepath.Join(cfg.Path, btreeWALDir), s.checkpointLSN, opts, func(lsn uint64, payload []byte) error {
+		redone = true
+		ops, err := decodeBTreeOps(payload)
+		if err != nil {
+			return err
+		}
+		return s.write(ops, false)
+	})
+	if err == nil && redone {
+		err = s.checkpoint()
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *BTreeStorage) load(cfg *Config) error {
+	size, err := s.file.Size()
+	if err != nil {
+		return err
+	}
+	cachePages := cfg.CachePages
+	if cachePages <= 0 {
+		cachePages = DefaultCachePages
+	}
+
+	if size == 0 {
+		s.pageSize = cfg.PageSize
+		if s.pageSize == 0 {
+			s.pageSize = DefaultPageSize
+		}
+		if s.pageSize < 512 || s.pageSize > math.MaxUint16 {
+			return fmt.Errorf("invalid page size %d", s.pageSize)
+		}
+		s.pool = newBufferPool(s.file, s.pageSize, cachePages)
+		s.pageCount = 2
+		// The first meta is in slot 0
+		s.metaSlot = 1
+		if err := s.writeMeta(); err != nil {
+			return err
+		}
+		return s.file.Sync()
+	}
+
+	latest, slot, err := s.readMeta()
+	if err != nil {
+		return err
+	}
+	s.pageSize = latest.pageSize
+	s.root, s.latest = latest.root, latest.root
+	s.version, s.latestVersion = latest.version, latest.version
+	s.committed = latest.version
+	s.checkpointLSN = latest.lsn
+	s.pageCount = latest.pageCount
+	s.metaSlot = slot
+	s.pool = newBufferPool(s.file, s.pageSize, cachePages)
+	return s.collectFreePages()
+}
+
+// readMeta returns the latest intact meta and its slot. The second
+// one is at offset of page size, which is tried in all valid ones if
+// the first one is corrupted.
+func (s *BTreeStorage) readMeta() (*meta, int, error) {
+	read := func(offset int64) (*meta, error) {
+		buf := make([]byte, metaSize)
+		if _, err := s.file.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return decodeMeta(buf), nil
+	}
+	first, err := read(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	for size := 512; size <= math.MaxUint16; size *= 2 {
+		if first != nil && size != first.pageSize {
+			continue
+		}
+		second, err := read(int64(size))
+		if err != nil {
+			return nil, 0, err
+		}
+		if second != nil && second.pageSize == size && (first == nil || second.lsn > first.lsn ||
+			second.lsn == first.lsn && second.version > first.version) {
+			return second, 1, nil
+		}
+	}
+	if first == nil {
+		return nil, 0, errors.New("meta is corrupted")
+	}
+	return first, 0, nil
+}
+
+// writeMeta writes meta of the current version into the slot of
+// older meta.
+func (s *BTreeStorage) writeMeta() error {
+	m := &meta{
+		pageSize:  s.pageSize,
+		version:   s.latestVersion,
+		root:      s.latest,
+		pageCount: s.pageCount,
+		lsn:       s.checkpointLSN,
+	}
+	buf := make([]byte, s.pageSize)
+	m.encode(buf)
+	slot := 1 - s.metaSlot
+	if _, err := s.file.WriteAt(buf, int64(slot)*int64(s.pageSize)); err != nil {
+		return err
+	}
+	s.metaSlot = slot
+	return nil
+}
+
+// collectFreePages marks pages reachable from root, others are free.
+func (s *BTreeStorage) collectFreePages() error {
+	used := make([]bool, s.pageCount)
+	used[0], used[1] = true, true
+	var mark func(id pageID) error
+	mark = func(id pageID) error {
+		if uint64(id) >= s.pageCount || used[id] {
+			return fmt.Errorf("page %d is referred to twice or out of file", id)
+		}
+		used[id] = true
+		p, err := s.pool.get(id)
+		if err != nil {
+			return err
+		}
+		switch p.typ {
+		case pageBranch:
+			for _, child := range p.children {
+				if err := mark(child); err != nil {
+					return err
+				}
+			}
+		case pageLeaf:
+			for _, v := range p.values {
+				for next := v.overflow; next != 0; {
+					if err := mark(next); err != nil {
+						return err
+					}
+					o, err := s.pool.get(next)
+					if err != nil {
+						return err
+					}
+					next = o.next
+				}
+			}
+		}
+		return nil
+	}
+	if s.latest != 0 {
+		if err := mark(s.latest); err != nil {
+			return err
+		}
+	}
+	for id := len(used) - 1; id >= 2; id-- {
+		if !used[id] {
+			s.free = append(s.free, pageID(id))
+		}
+	}
+	return nil
+}
+
+// Close makes a checkpoint and closes files.
+func (s *BTreeStorage) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.err
+	if err == nil {
+		err = s.checkpoint()
+	}
+	if werr := s.wal.Close(); err == nil {
+		err = werr
+	}
+	if ferr := s.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// checkpoint writes the latest tree and meta pointing to it, records
+// of WAL in it are removed then. It's called with writeMu held.
+func (s *BTreeStorage) checkpoint() error {
+	lsn, err := s.wal.Rotate()
+	if err != nil {
+		return err
+	}
+	// All writes are durable in WAL now
+	s.publish(s.latest, s.latestVersion)
+	if err := s.pool.flush(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.checkpointLSN = lsn
+	if err := s.writeMeta(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.committed = s.latestVersion
+	return s.wal.Truncate(lsn)
+}
+
+// acquire returns root of the latest version, the version must be
+// released after being read.
+func (s *BTreeStorage) acquire() (pageID, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readers[s.version]++
+	return s.root, s.version
+}
+
+// hold registers one more reader of version, which is being read.
+func (s *BTreeStorage) hold(version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readers[version]++
+}
+
+func (s *BTreeStorage) release(version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readers[version]--; s.readers[version] == 0 {
+		delete(s.readers, version)
+	}
+}
+
+func (s *BTreeStorage) Get(k []byte) ([]byte, error) {
+	root, version := s.acquire()
+	defer s.release(version)
+	return s.get(root, k)
+}
+
+func (s *BTreeStorage) get(root pageID, k []byte) ([]byte, error) {
+	if root == 0 {
+		return nil, ErrNotFound
+	}
+	p, err := s.pool.get(root)
+	for err == nil && !p.isLeaf() {
+		p, err = s.pool.get(p.children[childIndex(p, k)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(p.keys), func(i int) bool {
+		return bytes.Compare(p.keys[i], k) >= 0
+	})
+	if i == len(p.keys) || !bytes.Equal(p.keys[i], k) {
+		return nil, ErrNotFound
+	}
+	return s.readValue(p.values[i])
+}
+
+// childIndex returns index of child of branch p whose keys may include k.
+func childIndex(p *page, k []byte) int {
+	return sort.Search(len(p.keys), func(i int) bool {
+		return bytes.Compare(p.keys[i], k) > 0
+	})
+}
+
+// readValue returns a copy of v.
+func (s *BTreeStorage) readValue(v pageValue) ([]byte, error) {
+	if v.overflow == 0 {
+		return append([]byte{}, v.data...), nil
+	}
+	data := make([]byte, 0, v.length)
+	for next := v.overflow; next != 0; {
+		p, err := s.pool.get(next)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, p.data...)
+		next = p.next
+	}
+	if len(data) != v.length {
+		return nil, fmt.Errorf("value of %d bytes has %d bytes in overflow pages", v.length, len(data))
+	}
+	return data, nil
+}
+
+func (s *BTreeStorage) Put(k, v []byte) error {
+	return s.write([]btreeOp{{key: k, value: v}}, true)
+}
+
+func (s *BTreeStorage) Delete(k []byte) error {
+	return s.write([]btreeOp{{delete: true, key: k}}, true)
+}
+
+// Write applies writes of b in one transaction, which is one record
+// of WAL.
+func (s *BTreeStorage) Write(b *WriteBatch) error {
+	r := &btreeBatchReplay{ops: make([]btreeOp, 0, b.Len())}
+	if err := b.replay(r); err != nil {
+		return err
+	}
+	if len(r.ops) == 0 {
+		return nil
+	}
+	return s.write(r.ops, true)
+}
+
+type btreeBatchReplay struct {
+	ops []btreeOp
+}
+
+func (r *btreeBatchReplay) Put(k, v []byte) {
+	r.ops = append(r.ops, btreeOp{key: k, value: v})
+}
+
+func (r *btreeBatchReplay) Delete(k []byte) {
+	r.ops = append(r.ops, btreeOp{delete: true, key: k})
+}
+
+// write applies ops in a transaction and commits the tree it makes,
+// nothing is changed if any of them fails. The transaction is logged
+// unless it's being redone, and readers see the tree after the record
+// is durable. Writes go on while a record is being synced, so a tree
+// is built on the latest one which may not be published yet.
+func (s *BTreeStorage) write(ops []btreeOp, log bool) error {
+	s.writeMu.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			s.writeMu.Unlock()
+		}
+	}()
+	if s.err != nil {
+		return s.err
+	}
+	if log && s.wal.Size() >= s.checkpointSize {
+		if err := s.checkpoint(); err != nil {
+			s.err = err
+			return err
+		}
+	}
+	s.reclaim()
+
+	tx := &btreeTx{
+		s:         s,
+		root:      s.latest,
+		version:   s.latestVersion + 1,
+		pageCount: s.pageCount,
+		free:      s.free,
+	}
+	for _, op := range ops {
+		var err error
+		if op.delete {
+			err = tx.delete(op.key)
+		} else {
+			err = tx.put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if tx.root == s.latest {
+		return nil
+	}
+	if !log {
+		s.commit(tx)
+		s.publish(tx.root, tx.version)
+		return nil
+	}
+
+	lsn, err := s.wal.Append(encodeBTreeOps(ops))
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.commit(tx)
+	// Other writes go on while waiting for WAL
+	s.writeMu.Unlock()
+	locked = false
+	if err := s.wal.Commit(lsn); err != nil {
+		s.fail(err)
+		return err
+	}
+	s.publish(tx.root, tx.version)
+	return nil
+}
+
+// commit makes the tree of tx the latest one, it's not published yet.
+func (s *BTreeStorage) commit(tx *btreeTx) {
+	s.latest = tx.root
+	s.latestVersion = tx.version
+	s.pageCount = tx.pageCount
+	s.free = tx.free
+	s.pending = append(s.pending, tx.freed...)
+}
+
+// publish lets readers see tree root of version, unless a newer one is
+// published already. Trees are built on each other, so a newer one
+// has all writes of older ones.
+func (s *BTreeStorage) publish(root pageID, version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version > s.version {
+		s.root = root
+		s.version = version
+	}
+}
+
+func (s *BTreeStorage) fail(err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// btreeOp is a put or delete of a write, which is logged as:
+//
+//	[uvarint count of ops]([op][uvarint key length][key][uvarint value length][value])...
+//
+// where op is 0 for put and 1 for delete, which has no value.
+type btreeOp struct {
+	delete     bool
+	key, value []byte
+}
+
+func encodeBTreeOps(ops []btreeOp) []byte {
+	b := appendUvarint(nil, len(ops))
+	for _, op := range ops {
+		if op.delete {
+			b = appendBytes(append(b, 1), op.key)
+		} else {
+			b = appendBytes(appendBytes(append(b, 0), op.key), op.value)
+		}
+	}
+	return b
+}
+
+func decodeBTreeOps(b []byte) ([]btreeOp, error) {
+	r := &pageReader{buf: b}
+	ops := make([]btreeOp, r.uvarint())
+	for i := range ops {
+		ops[i].delete = r.byte() == 1
+		ops[i].key = r.bytes()
+		if !ops[i].delete {
+			ops[i].value = r.bytes()
+		}
+	}
+	if r.err != nil || r.offset != len(b) {
+		return nil, errors.New("invalid operations")
+	}
+	return ops, nil
+}
+
+// reclaim moves freed pages which can't be read any more into free list.
+func (s *BTreeStorage) reclaim() {
+	s.mu.Lock()
+	versions := make([]uint64, 0, len(s.readers))
+	for version := range s.readers {
+		versions = append(versions, version)
+	}
+	published := s.version
+	s.mu.Unlock()
+
+	// Trees of versions in [allocated, freed) have the page
+	inUse := func(f freedPage, version uint64) bool {
+		return f.allocated <= version && version < f.freed
+	}
+	pending := s.pending[:0]
+	for _, f := range s.pending {
+		// Versions after the published one may be published later
+		used := f.freed > published || inUse(f, s.committed)
+		for _, version := range versions {
+			used = used || inUse(f, version)
+		}
+		if used {
+			pending = append(pending, f)
+		} else {
+			s.free = append(s.free, f.id)
+		}
+	}
+	s.pending = pending
+}
+
+// btreeTx makes a new version of tree, pages are allocated from its
+// own copy of free list so nothing is changed until commit.
+type btreeTx struct {
+	s         *BTreeStorage
+	root      pageID
+	version   uint64
+	pageCount uint64
+	free      []pageID
+	freed     []freedPage
+}
+
+func (tx *btreeTx) allocate() pageID {
+	if n := len(tx.free); n > 0 {
+		id := tx.free[n-1]
+		tx.free = tx.free[: n-1 : n-1]
+		return id
+	}
+	tx.pageCount++
+	return pageID(tx.pageCount - 1)
+}
+
+// release frees page p removed from tree.
+func (tx *btreeTx) release(p *page) {
+	tx.freed = append(tx.freed, freedPage{id: p.id, allocated: p.version, freed: tx.version})
+}
+
+// save assigns a page to p and puts it into buffer pool.
+func (tx *btreeTx) save(p *page) error {
+	p.id = tx.allocate()
+	p.version = tx.version
+	return tx.s.pool.put(p)
+}
+
+// maxKeySize keeps an entry of leaf or branch in a quarter of page,
+// so a page split in halves always fits.
+func (tx *btreeTx) maxKeySize() int {
+	return (tx.s.pageSize-pageHeaderSize)/4 - 1 - 2*binary.MaxVarintLen64 - pageIDSize
+}
+
+func (tx *btreeTx) put(k, v []byte) error {
+	if len(k) > tx.maxKeySize() {
+		return ErrKeyTooLong
+	}
+	value, err := tx.writeValue(k, v)
+	if err != nil {
+		return err
+	}
+	if tx.root == 0 {
+		leaf := &page{typ: pageLeaf, keys: [][]byte{copyBytes(k)}, values: []pageValue{value}}
+		if err := tx.save(leaf); err != nil {
+			return err
+		}
+		tx.root = leaf.id
+		return nil
+	}
+	root, err := tx.s.pool.get(tx.root)
+	if err != nil {
+		return err
+	}
+	left, sep, right, err := tx.insert(root, k, value)
+	if err != nil {
+		return err
+	}
+	if right != nil {
+		branch := &page{typ: pageBranch, keys: [][]byte{sep}, children: []pageID{left.id, right.id}}
+		if err := tx.save(branch); err != nil {
+			return err
+		}
+		left = branch
+	}
+	tx.root = left.id
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// writeValue keeps v in leaf if entry of it takes no more than a quarter
+// of page, or writes it into overflow pages.
+func (tx *btreeTx) writeValue(k, v []byte) (pageValue, error) {
+	value := pageValue{data: copyBytes(v), length: len(v)}
+	if leafEntrySize(k, value) <= (tx.s.pageSize-pageHeaderSize)/4 {
+		return value, nil
+	}
+	// Pages are written from the last one, each of them refers to the next
+	chunk := tx.s.pageSize - pageHeaderSize - pageIDSize - 2
+	var next pageID
+	for start := (len(v) - 1) / chunk * chunk; start >= 0; start -= chunk {
+		end := start + chunk
+		if end > len(v) {
+			end = len(v)
+		}
+		p := &page{typ: pageOverflow, next: next, data: value.data[start:end]}
+		if err := tx.save(p); err != nil {
+			return value, err
+		}
+		next = p.id
+	}
+	return pageValue{overflow: next, length: len(v)}, nil
+}
+
+// releaseValue frees overflow pages of v.
+func (tx *btreeTx) releaseValue(v pageValue) error {
+	for next := v.overflow; next != 0; {
+		p, err := tx.s.pool.get(next)
+		if err != nil {
+			return err
+		}
+		tx.release(p)
+		next = p.next
+	}
+	return nil
+}
+
+// insert puts k into subtree p, and returns the copy of p. If the copy
+// is split, right is the right half and sep is the least key of it.
+func (tx *btreeTx) insert(p *page, k []byte, v pageValue) (left *page, sep []byte, right *page, err error) {
+	c := p.clone()
+	if p.isLeaf() {
+		i := sort.Search(len(p.keys), func(i int) bool {
+			return bytes.Compare(p.keys[i], k) >= 0
+		})
+		if i < len(p.keys) && bytes.Equal(p.keys[i], k) {
+			if err := tx.releaseValue(p.values[i]); err != nil {
+				return nil, nil, nil, err
+			}
+			c.values[i] = v
+		} else {
+			c.keys = append(c.keys[:i], append([][]byte{copyBytes(k)}, c.keys[i:]...)...)
+			c.values = append(c.values[:i], append([]pageValue{v}, c.values[i:]...)...)
+		}
+	} else {
+		i := childIndex(p, k)
+		child, err := tx.s.pool.get(p.children[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		l, sep, r, err := tx.insert(child, k, v)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c.children[i] = l.id
+		if r != nil {
+			c.keys = append(c.keys[:i], append([][]byte{sep}, c.keys[i:]...)...)
+			c.children = append(c.children[:i+1], append([]pageID{r.id}, c.children[i+1:]...)...)
+		}
+	}
+	tx.release(p)
+
+	if c.size() > tx.s.pageSize {
+		sep, right = tx.split(c)
+		if err := tx.save(right); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := tx.save(c); err != nil {
+		return nil, nil, nil, err
+	}
+	return c, sep, right, nil
+}
+
+// split moves the right half of p by size into a new page. A branch
+// gives up its middle key as sep.
+func (tx *btreeTx) split(p *page) (sep []byte, right *page) {
+	half := (p.size() - pageHeaderSize) / 2
+	size := 0
+	i := 0
+	for ; i < len(p.keys)-1; i++ {
+		if p.isLeaf() {
+			size += leafEntrySize(p.keys[i], p.values[i])
+		} else {
+			size += branchEntrySize(p.keys[i])
+		}
+		if size >= half {
+			break
+		}
+	}
+	right = &page{typ: p.typ}
+	if p.isLeaf() {
+		i++
+		right.keys = append(right.keys, p.keys[i:]...)
+		right.values = append(right.values, p.values[i:]...)
+		p.keys, p.values = p.keys[:i], p.values[:i]
+		return right.keys[0], right
+	}
+	sep = p.keys[i]
+	right.keys = append(right.keys, p.keys[i+1:]...)
+	right.children = append(right.children, p.children[i+1:]...)
+	p.keys, p.children = p.keys[:i], p.children[:i+1]
+	return sep, right
+}
+
+func (tx *btreeTx) delete(k []byte) error {
+	if tx.root == 0 {
+		return nil
+	}
+	root, err := tx.s.pool.get(tx.root)
+	if err != nil {
+		return err
+	}
+	c, changed, err := tx.remove(root, k)
+	if err != nil || !changed {
+		return err
+	}
+	// A branch with only one child is replaced by it
+	for c != nil && !c.isLeaf() && len(c.children) == 1 {
+		child, err := tx.s.pool.get(c.children[0])
+		if err != nil {
+			return err
+		}
+		tx.release(c)
+		c = child
+	}
+	if c == nil {
+		tx.root = 0
+	} else {
+		tx.root = c.id
+	}
+	return nil
+}
+
+// remove deletes k from subtree p and returns the copy of p, which is
+// nil if it's empty. Pages are left alone if there is no k. Pages are
+// not merged, only empty ones are removed.
+func (tx *btreeTx) remove(p *page, k []byte) (c *page, changed bool, err error) {
+	if p.isLeaf() {
+		i := sort.Search(len(p.keys), func(i int) bool {
+			return bytes.Compare(p.keys[i], k) >= 0
+		})
+		if i == len(p.keys) || !bytes.Equal(p.keys[i], k) {
+			return p, false, nil
+		}
+		if err := tx.releaseValue(p.values[i]); err != nil {
+			return nil, false, err
+		}
+		tx.release(p)
+		if len(p.keys) == 1 {
+			return nil, true, nil
+		}
+		c = p.clone()
+		c.keys = append(c.keys[:i], c.keys[i+1:]...)
+		c.values = append(c.values[:i], c.values[i+1:]...)
+		return c, true, tx.save(c)
+	}
+
+	i := childIndex(p, k)
+	child, err := tx.s.pool.get(p.children[i])
+	if err != nil {
+		return nil, false, err
+	}
+	newChild, changed, err := tx.remove(child, k)
+	if err != nil || !changed {
+		return p, false, err
+	}
+	tx.release(p)
+	c = p.clone()
+	if newChild != nil {
+		c.children[i] = newChild.id
+	} else {
+		if len(c.children) == 1 {
+			return nil, true, nil
+		}
+		// Drop the key on the side of removed child
+		j := i - 1
+		if i == 0 {
+			j = 0
+		}
+		c.keys = append(c.keys[:j], c.keys[j+1:]...)
+		c.children = append(c.children[:i], c.children[i+1:]...)
+	}
+	return c, true, tx.save(c)
+}
+
+func (s *BTreeStorage) Scan(low, up []byte) Iterator {
+	root, version := s.acquire()
+	return s.newIterator(root, version, low, up)
+}
+
+func (s *BTreeStorage) ScanAll() Iterator {
+	root, version := s.acquire()
+	return s.newIterator(root, version, nil, nil)
+}
+
+// Snapshot keeps the latest version from being reused until it's released.
+func (s *BTreeStorage) Snapshot() (Snapshot, error) {
+	root, version := s.acquire()
+	return &btreeSnapshot{s: s, root: root, version: version}, nil
+}
+
+type btreeSnapshot struct {
+	s        *BTreeStorage
+	root     pageID
+	version  uint64
+	released sync.Once
+}
+
+func (s *btreeSnapshot) Get(k []byte) ([]byte, error) {
+	return s.s.get(s.root, k)
+}
+
+func (s *btreeSnapshot) Scan(low, up []byte) Iterator {
+	s.s.hold(s.version)
+	return s.s.newIterator(s.root, s.version, low, up)
+}
+
+func (s *btreeSnapshot) ScanAll() Iterator {
+	return s.Scan(nil, nil)
+}
+
+func (s *btreeSnapshot) Release() {
+	s.released.Do(func() {
+		s.s.release(s.version)
+	})
+}
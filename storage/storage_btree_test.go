@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestBTreeStorage(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "btree")
+	assert.Equal(err, nil)
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{Engine: EngineBTree, Path: dir, PageSize: 512, CachePages: 8}
+	s, err := OpenBTreeStorage(cfg)
+	assert.Equal(err, nil)
+
+	expected := make(map[string][]byte)
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprintf("key%04d", r.Intn(500))
+		if r.Intn(3) == 0 {
+			assert.Equal(s.Delete([]byte(k)), nil)
+			delete(expected, k)
+			continue
+		}
+		// Some values are in overflow pages
+		v := bytes.Repeat([]byte{byte(i)}, r.Intn(1500))
+		assert.Equal(s.Put([]byte(k), v), nil)
+		expected[k] = v
+	}
+	assert.Equal(s.Put(make([]byte, 512), nil), ErrKeyTooLong)
+	// Nothing of a batch is written if any write fails
+	batch := new(WriteBatch)
+	batch.Put([]byte("batch"), nil)
+	batch.Put(make([]byte, 512), nil)
+	assert.Equal(s.Write(batch), ErrKeyTooLong)
+	_, err = s.Get([]byte("batch"))
+	assert.Equal(err, ErrNotFound)
+
+	check := func(s *BTreeStorage) {
+		var keys []string
+		for k, v := range expected {
+			keys = append(keys, k)
+			got, err := s.Get([]byte(k))
+			assert.Equal(err, nil)
+			assert.Equal(got, v)
+		}
+		sort.Strings(keys)
+		itr := s.ScanAll()
+		for _, k := range keys {
+			assert.True(itr.Next())
+			assert.Equal(string(itr.Key()), k)
+			assert.Equal(itr.Value(), expected[k])
+		}
+		assert.False(itr.Next())
+		for i := len(keys) - 1; i >= 0; i-- {
+			assert.True(itr.Prev())
+			assert.Equal(string(itr.Key()), keys[i])
+		}
+		assert.Equal(itr.Error(), nil)
+		itr.Release()
+	}
+	check(s)
+
+	// Everything is kept after reopen
+	assert.Equal(s.Close(), nil)
+	s, err = OpenBTreeStorage(cfg)
+	assert.Equal(err, nil)
+	check(s)
+
+	// Records after the last checkpoint are redone
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key%04d", i)
+		expected[k] = []byte(k)
+		assert.Equal(s.Put([]byte(k), []byte(k)), nil)
+	}
+	s, err = OpenBTreeStorage(cfg)
+	assert.Equal(err, nil)
+	check(s)
+
+	// Pages of deleted keys are reused after checkpoint
+	for k := range expected {
+		assert.Equal(s.Delete([]byte(k)), nil)
+	}
+	assert.Equal(s.root, pageID(0))
+	assert.Equal(s.Close(), nil)
+	s, err = OpenBTreeStorage(cfg)
+	assert.Equal(err, nil)
+	pages := s.pageCount
+	assert.Equal(uint64(len(s.free))+2, pages)
+	for k, v := range expected {
+		assert.Equal(s.Put([]byte(k), v), nil)
+	}
+	assert.True(s.pageCount == pages || len(s.free) == 0)
+	check(s)
+	assert.Equal(s.Close(), nil)
+}
+
+func TestBTreeSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "btree")
+	assert.Equal(err, nil)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenBTreeStorage(&Config{Engine: EngineBTree, Path: dir, PageSize: 512})
+	assert.Equal(err, nil)
+	defer s.Close()
+	for i := 0; i < 100; i++ {
+		s.Put([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, 100))
+	}
+
+	// Pages read by an iterator are not reused until it's released
+	itr := s.Scan([]byte{10}, []byte{90})
+	for i := 0; i < 100; i++ {
+		s.Put([]byte{byte(i)}, bytes.Repeat([]byte{0xff}, 100))
+	}
+	for i := 10; i < 90; i++ {
+		assert.True(itr.Next())
+		assert.Equal(itr.Key(), []byte{byte(i)})
+		assert.Equal(itr.Value(), bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	assert.False(itr.Next())
+	itr.Release()
+
+	v, err := s.Get([]byte{50})
+	assert.Equal(err, nil)
+	assert.Equal(v, bytes.Repeat([]byte{0xff}, 100))
+
+	// So are pages of a snapshot
+	snap, err := s.Snapshot()
+	assert.Equal(err, nil)
+	for i := 0; i < 100; i++ {
+		s.Put([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	for i := 0; i < 100; i++ {
+		v, err := snap.Get([]byte{byte(i)})
+		assert.Equal(err, nil)
+		assert.Equal(v, bytes.Repeat([]byte{0xff}, 100))
+	}
+	snap.Release()
+	snap.Release()
+	assert.Equal(len(s.readers), 0)
+}
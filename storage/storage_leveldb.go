@@ -12,14 +12,18 @@ type KVStorage struct {
 	db *leveldb.DB
 }
 
-func NewKVStorage(cfg *Config) Storage {
+func NewKVStorage(cfg *Config) (Storage, error) {
 	db, err := leveldb.OpenFile(cfg.Path, &opt.Options{})
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	return &KVStorage{
 		db: db,
-	}
+	}, nil
+}
+
+func (s *KVStorage) Close() error {
+	return s.db.Close()
 }
 
 func (s *KVStorage) Get(k []byte) ([]byte, error) {
@@ -34,10 +38,42 @@ func (s *KVStorage) Delete(k []byte) error {
 	return s.db.Delete(k, nil)
 }
 
+func (s *KVStorage) Write(b *WriteBatch) error {
+	return s.db.Write(&b.batch, nil)
+}
+
 func (s *KVStorage) Scan(low, up []byte) Iterator {
-	return s.db.NewIterator(&util.Range{low, up}, nil)
+	return s.db.NewIterator(&util.Range{Start: low, Limit: up}, nil)
 }
 
 func (s *KVStorage) ScanAll() Iterator {
 	return s.db.NewIterator(&util.Range{}, nil)
 }
+
+func (s *KVStorage) Snapshot() (Snapshot, error) {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &kvSnapshot{snap}, nil
+}
+
+type kvSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s *kvSnapshot) Get(k []byte) ([]byte, error) {
+	return s.snap.Get(k, nil)
+}
+
+func (s *kvSnapshot) Scan(low, up []byte) Iterator {
+	return s.snap.NewIterator(&util.Range{Start: low, Limit: up}, nil)
+}
+
+func (s *kvSnapshot) ScanAll() Iterator {
+	return s.snap.NewIterator(&util.Range{}, nil)
+}
+
+func (s *kvSnapshot) Release() {
+	s.snap.Release()
+}
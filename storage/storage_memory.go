@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ Storage = &MemStorage{}
+
+// MemStorage keeps keys in a persistent treap, writes copy the path
+// to the changed node instead of modifying it. So a tree is never
+// changed once built, and an iterator reads the tree at the moment
+// it's created like a snapshot of LevelDB.
+type MemStorage struct {
+	mu   sync.RWMutex
+	root *memNode
+	rand *rand.Rand
+}
+
+type memNode struct {
+	key, value  []byte
+	priority    int64
+	left, right *memNode
+}
+
+func NewMemStorage() Storage {
+	return &MemStorage{
+		rand: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *MemStorage) snapshot() *memNode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.root
+}
+
+func (s *MemStorage) Get(k []byte) ([]byte, error) {
+	return memGet(s.snapshot(), k)
+}
+
+func memGet(n *memNode, k []byte) ([]byte, error) {
+	for n != nil {
+		switch c := bytes.Compare(k, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return append([]byte{}, n.value...), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStorage) Put(k, v []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root = s.insert(s.root, k, v)
+	return nil
+}
+
+func (s *MemStorage) insert(t *memNode, k, v []byte) *memNode {
+	n := &memNode{
+		key:      append([]byte{}, k...),
+		value:    append([]byte{}, v...),
+		priority: s.rand.Int63(),
+	}
+	return memInsert(t, n)
+}
+
+func (s *MemStorage) Delete(k []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root = memDelete(s.root, k)
+	return nil
+}
+
+// Write builds a tree with all writes of b before replacing root with
+// it, readers never see part of them.
+func (s *MemStorage) Write(b *WriteBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &memBatchReplay{s: s, root: s.root}
+	if err := b.replay(r); err != nil {
+		return err
+	}
+	s.root = r.root
+	return nil
+}
+
+type memBatchReplay struct {
+	s    *MemStorage
+	root *memNode
+}
+
+func (r *memBatchReplay) Put(k, v []byte) {
+	r.root = r.s.insert(r.root, k, v)
+}
+
+func (r *memBatchReplay) Delete(k []byte) {
+	r.root = memDelete(r.root, k)
+}
+
+func (s *MemStorage) Scan(low, up []byte) Iterator {
+	return memSnapshot{s.snapshot()}.Scan(low, up)
+}
+
+func (s *MemStorage) ScanAll() Iterator {
+	return memSnapshot{s.snapshot()}.ScanAll()
+}
+
+// Snapshot keeps the current tree, which is never changed.
+func (s *MemStorage) Snapshot() (Snapshot, error) {
+	return memSnapshot{s.snapshot()}, nil
+}
+
+type memSnapshot struct {
+	root *memNode
+}
+
+func (s memSnapshot) Get(k []byte) ([]byte, error) {
+	return memGet(s.root, k)
+}
+
+func (s memSnapshot) Scan(low, up []byte) Iterator {
+	return &memIterator{
+		root: s.root,
+		r:    &util.Range{Start: low, Limit: up},
+	}
+}
+
+func (s memSnapshot) ScanAll() Iterator {
+	return &memIterator{
+		root: s.root,
+		r:    &util.Range{},
+	}
+}
+
+func (s memSnapshot) Release() {}
+
+// memInsert returns the tree with n put into it, nodes on the path
+// to n are copied.
+func memInsert(t, n *memNode) *memNode {
+	if t == nil {
+		return n
+	}
+	c := *t
+	switch cmp := bytes.Compare(n.key, t.key); {
+	case cmp < 0:
+		c.left = memInsert(t.left, n)
+		// The children are new nodes, rotations may change them
+		if c.left.priority > c.priority {
+			l := c.left
+			c.left, l.right = l.right, &c
+			return l
+		}
+	case cmp > 0:
+		c.right = memInsert(t.right, n)
+		if c.right.priority > c.priority {
+			r := c.right
+			c.right, r.left = r.left, &c
+			return r
+		}
+	default:
+		c.value = n.value
+	}
+	return &c
+}
+
+// memDelete returns the tree without key k, it's t itself if there
+// is no such key.
+func memDelete(t *memNode, k []byte) *memNode {
+	if t == nil {
+		return nil
+	}
+	var c memNode
+	switch cmp := bytes.Compare(k, t.key); {
+	case cmp < 0:
+		left := memDelete(t.left, k)
+		if left == t.left {
+			return t
+		}
+		c = *t
+		c.left = left
+	case cmp > 0:
+		right := memDelete(t.right, k)
+		if right == t.right {
+			return t
+		}
+		c = *t
+		c.right = right
+	default:
+		return memMerge(t.left, t.right)
+	}
+	return &c
+}
+
+// memMerge joins trees a and b, keys in a are less than those in b.
+func memMerge(a, b *memNode) *memNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		c := *a
+		c.right = memMerge(a.right, b)
+		return &c
+	}
+	c := *b
+	c.left = memMerge(a, b.left)
+	return &c
+}
+
+// memIterator implements iterator.Iterator on a tree, every move
+// searches from root.
+type memIterator struct {
+	util.BasicReleaser
+
+	root *memNode
+	r    *util.Range
+	node *memNode
+	// Before the first key or after the last one, Next moves to
+	// the first key from the former and Prev to the last from the latter.
+	atStart, atEnd bool
+	released       bool
+}
+
+func (i *memIterator) inRange(n *memNode) bool {
+	return n != nil && (i.r.Start == nil || bytes.Compare(n.key, i.r.Start) >= 0) &&
+		(i.r.Limit == nil || bytes.Compare(n.key, i.r.Limit) < 0)
+}
+
+// seek moves to n, or out of range on the side of forward if n isn't in range.
+func (i *memIterator) seek(n *memNode, forward bool) bool {
+	if i.released {
+		return false
+	}
+	if i.inRange(n) {
+		i.node, i.atStart, i.atEnd = n, false, false
+		return true
+	}
+	i.node, i.atStart, i.atEnd = nil, !forward, forward
+	return false
+}
+
+// ceiling returns the node with the least key not less than k,
+// the least one of all if k is nil.
+func (i *memIterator) ceiling(k []byte) *memNode {
+	var found *memNode
+	for n := i.root; n != nil; {
+		if k == nil || bytes.Compare(n.key, k) >= 0 {
+			found, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return found
+}
+
+// higher returns the node with the least key greater than k.
+func (i *memIterator) higher(k []byte) *memNode {
+	var found *memNode
+	for n := i.root; n != nil; {
+		if bytes.Compare(n.key, k) > 0 {
+			found, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return found
+}
+
+// lower returns the node with the greatest key less than k,
+// the greatest one of all if k is nil.
+func (i *memIterator) lower(k []byte) *memNode {
+	var found *memNode
+	for n := i.root; n != nil; {
+		if k == nil || bytes.Compare(n.key, k) < 0 {
+			found, n = n, n.right
+		} else {
+			n = n.left
+		}
+	}
+	return found
+}
+
+func (i *memIterator) First() bool {
+	return i.seek(i.ceiling(i.r.Start), true)
+}
+
+func (i *memIterator) Last() bool {
+	return i.seek(i.lower(i.r.Limit), false)
+}
+
+func (i *memIterator) Seek(key []byte) bool {
+	if i.r.Start != nil && bytes.Compare(key, i.r.Start) < 0 {
+		key = i.r.Start
+	}
+	return i.seek(i.ceiling(key), true)
+}
+
+func (i *memIterator) Next() bool {
+	switch {
+	case i.atEnd || i.released:
+		return false
+	case i.node == nil:
+		return i.First()
+	default:
+		return i.seek(i.higher(i.node.key), true)
+	}
+}
+
+func (i *memIterator) Prev() bool {
+	switch {
+	case i.atStart || i.released:
+		return false
+	case i.node == nil:
+		return i.Last()
+	default:
+		return i.seek(i.lower(i.node.key), false)
+	}
+}
+
+func (i *memIterator) Valid() bool {
+	return i.node != nil
+}
+
+// Key returns the key of current entry, it must not be modified.
+func (i *memIterator) Key() []byte {
+	if i.node == nil {
+		return nil
+	}
+	return i.node.key[:len(i.node.key):len(i.node.key)]
+}
+
+// Value returns the value of current entry, it must not be modified.
+func (i *memIterator) Value() []byte {
+	if i.node == nil {
+		return nil
+	}
+	return i.node.value[:len(i.node.value):len(i.node.value)]
+}
+
+func (i *memIterator) Error() error {
+	return nil
+}
+
+func (i *memIterator) Release() {
+	if !i.released {
+		i.released = true
+		i.node, i.root = nil, nil
+		i.BasicReleaser.Release()
+	}
+}
@@ -1,61 +1,238 @@
 package storage
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/leiysky/go-utils/assert"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
-var config *Config
+// forEngines runs test on an empty storage of every engine.
+func forEngines(t *testing.T, test func(t *testing.T, s Storage)) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
-func SetUp() {
-	config = &Config{
-		Path: "tmp-data",
+	configs := []*Config{
+		{Engine: EngineLevelDB, Path: filepath.Join(dir, EngineLevelDB)},
+		{Engine: EngineMemory},
+		// Small pages make the tree deep
+		{Engine: EngineBTree, Path: filepath.Join(dir, EngineBTree), PageSize: 512, CachePages: 16},
+	}
+	for _, cfg := range configs {
+		t.Run(cfg.Engine, func(t *testing.T) {
+			s, err := NewStorage(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := s.(io.Closer); ok {
+				t.Cleanup(func() {
+					if err := c.Close(); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+			test(t, s)
+		})
 	}
 }
 
-func TearDown() {
-	os.RemoveAll("tmp-data")
+func TestUnknownEngine(t *testing.T) {
+	_, err := NewStorage(&Config{Engine: "unknown"})
+	assert.New(t).NEqual(err, nil)
 }
 
 func TestStorage(t *testing.T) {
-	SetUp()
-	defer TearDown()
-	assert := assert.New(t)
-	s := NewKVStorage(config)
+	forEngines(t, func(t *testing.T, s Storage) {
+		assert := assert.New(t)
 
-	s.Put([]byte("k1"), []byte("v1"))
-	s.Put([]byte("k2"), []byte("v2"))
+		s.Put([]byte("k1"), []byte("v1"))
+		s.Put([]byte("k2"), []byte("v2"))
 
-	v1, _ := s.Get([]byte("k1"))
-	assert.Equal(v1, []byte("v1"))
+		v1, _ := s.Get([]byte("k1"))
+		assert.Equal(v1, []byte("v1"))
 
-	s.Delete([]byte("k1"))
+		s.Delete([]byte("k1"))
 
-	v1, err := s.Get([]byte("k1"))
-	assert.Equal(err, leveldb.ErrNotFound)
+		v1, err := s.Get([]byte("k1"))
+		assert.Equal(err, leveldb.ErrNotFound)
+	})
 }
 
 func TestScan(t *testing.T) {
-	SetUp()
-	defer TearDown()
-	assert := assert.New(t)
+	forEngines(t, func(t *testing.T, s Storage) {
+		assert := assert.New(t)
 
-	s := NewKVStorage(config)
-	s.Put([]byte{1}, []byte{1})
-	s.Put([]byte{2}, []byte{2})
-	s.Put([]byte{3}, []byte{3})
+		s.Put([]byte{1}, []byte{1})
+		s.Put([]byte{2}, []byte{2})
+		s.Put([]byte{3}, []byte{3})
 
-	itr := s.Scan([]byte{1}, []byte{3})
+		itr := s.Scan([]byte{1}, []byte{3})
+
+		for i := 1; i < 3; i++ {
+			itr.Next()
+			assert.True(itr.Valid())
+			assert.Equal(itr.Key(), []byte{byte(i)})
+			assert.Equal(itr.Value(), []byte{byte(i)})
+		}
+		assert.False(itr.Next())
+		assert.False(itr.Valid())
+
+		// Moving back from the end starts at the last key
+		assert.True(itr.Prev())
+		assert.Equal(itr.Key(), []byte{2})
+		assert.True(itr.Seek([]byte{0}))
+		assert.Equal(itr.Key(), []byte{1})
+		assert.False(itr.Prev())
+		assert.True(itr.Last())
+		assert.Equal(itr.Key(), []byte{2})
+		itr.Release()
+		assert.False(itr.Next())
+	})
+}
 
-	for i := 1; i < 3; i++ {
-		itr.Next()
-		assert.True(itr.Valid())
-		assert.Equal(itr.Key(), []byte{byte(i)})
-		assert.Equal(itr.Value(), []byte{byte(i)})
+func TestScanSnapshot(t *testing.T) {
+	forEngines(t, func(t *testing.T, s Storage) {
+		assert := assert.New(t)
+		for i := 0; i < 10; i++ {
+			s.Put([]byte{byte(i)}, []byte{byte(i)})
+		}
+
+		// Iterators don't see writes after they are created
+		itr := s.ScanAll()
+		defer itr.Release()
+		var keys []byte
+		for itr.Next() {
+			k := itr.Key()[0]
+			keys = append(keys, k)
+			s.Delete([]byte{k})
+			s.Put([]byte{k + 100}, []byte{k})
+		}
+		assert.Equal(keys, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+		assert.Equal(DeleteRange(s, []byte{100}, nil), nil)
+		itr = s.ScanAll()
+		assert.False(itr.Next())
+		itr.Release()
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	forEngines(t, func(t *testing.T, s Storage) {
+		assert := assert.New(t)
+		for i := 0; i < 100; i++ {
+			s.Put([]byte{byte(i)}, []byte{byte(i)})
+		}
+		snap, err := s.Snapshot()
+		assert.Equal(err, nil)
+		batch := new(WriteBatch)
+		for i := 0; i < 100; i++ {
+			batch.Delete([]byte{byte(i)})
+			batch.Put([]byte{byte(i + 100)}, []byte{byte(i)})
+		}
+		assert.Equal(s.Write(batch), nil)
+
+		// Writes after snapshot are not seen
+		v, err := snap.Get([]byte{1})
+		assert.Equal(err, nil)
+		assert.Equal(v, []byte{1})
+		_, err = snap.Get([]byte{101})
+		assert.Equal(err, ErrNotFound)
+		itr := snap.Scan([]byte{50}, nil)
+		snap.Release()
+		n := 0
+		for ; itr.Next(); n++ {
+			assert.Equal(itr.Key(), []byte{byte(n + 50)})
+		}
+		assert.Equal(n, 50)
+		itr.Release()
+
+		all := s.ScanAll()
+		assert.True(all.Next())
+		assert.Equal(all.Key(), []byte{100})
+		all.Release()
+	})
+}
+
+func TestWriteBatch(t *testing.T) {
+	forEngines(t, func(t *testing.T, s Storage) {
+		assert := assert.New(t)
+		s.Put([]byte{1}, []byte{1})
+		itr := s.ScanAll()
+		defer itr.Release()
+
+		// Writes are applied in order
+		batch := new(WriteBatch)
+		batch.Put([]byte{2}, []byte{2})
+		batch.Delete([]byte{1})
+		batch.Put([]byte{3}, []byte{3})
+		batch.Delete([]byte{3})
+		batch.Put([]byte{3}, []byte{4})
+		assert.Equal(batch.Len(), 5)
+		assert.Equal(s.Write(batch), nil)
+		_, err := s.Get([]byte{1})
+		assert.Equal(err, ErrNotFound)
+		v, _ := s.Get([]byte{3})
+		assert.Equal(v, []byte{4})
+
+		// Iterators created before don't see any of them
+		assert.True(itr.Next())
+		assert.Equal(itr.Key(), []byte{1})
+		assert.False(itr.Next())
+
+		batch.Reset()
+		assert.Equal(batch.Len(), 0)
+		assert.Equal(s.Write(batch), nil)
+		assert.Equal(DeleteRange(s, nil, nil), nil)
+		all := s.ScanAll()
+		assert.False(all.Next())
+		all.Release()
+	})
+}
+
+func TestMemStorage(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMemStorage()
+	expected := make(map[string]string)
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 5000; i++ {
+		k := fmt.Sprintf("%04d", r.Intn(1000))
+		if r.Intn(3) == 0 {
+			s.Delete([]byte(k))
+			delete(expected, k)
+		} else {
+			v := fmt.Sprint(i)
+			s.Put([]byte(k), []byte(v))
+			expected[k] = v
+		}
+	}
+
+	var keys []string
+	for k := range expected {
+		keys = append(keys, k)
+		v, err := s.Get([]byte(k))
+		assert.Equal(err, nil)
+		assert.Equal(string(v), expected[k])
+	}
+	sort.Strings(keys)
+
+	itr := s.ScanAll()
+	for _, k := range keys {
+		assert.True(itr.Next())
+		assert.Equal(string(itr.Key()), k)
 	}
 	assert.False(itr.Next())
-	assert.False(itr.Valid())
+	for i := len(keys) - 1; i >= 0; i-- {
+		assert.True(itr.Prev())
+		assert.Equal(string(itr.Key()), keys[i])
+	}
+	itr.Release()
 }
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WAL is a write-ahead log in segment files of a directory. A record
+// is numbered by LSN, which starts from 1 and increases by 1, and it's
+// encoded as:
+//
+//	[checksum uint32][payload length uint32][LSN uint64][payload]
+//
+// where checksum is CRC-32 (IEEE) of the rest, integers are in big
+// endian. A segment is named by hex of LSN of its first record.
+//
+// Records appended are written and synced in groups: the first
+// committer writes all records appended so far, others wait for it.
+type WAL struct {
+	fs   fileSystem
+	dir  string
+	sync string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// segments are first LSNs of segment files, the last one is file
+	segments []uint64
+	file     file
+	size     int64
+	// Records appended but not written yet
+	buf      []byte
+	nextLSN  uint64
+	written  uint64
+	synced   uint64
+	flushing bool
+	// err is the first failure of writing, nothing can be
+	// appended after it
+	err     error
+	stopped chan struct{}
+	done    sync.WaitGroup
+}
+
+// Policies of syncing WAL.
+const (
+	// WALSyncAlways syncs records before commit returns.
+	WALSyncAlways = "always"
+	// WALSyncInterval writes records before commit returns, and syncs
+	// them periodically.
+	WALSyncInterval = "interval"
+	// WALSyncNone writes records before commit returns and leaves
+	// syncing to the system, they may be lost if the system crashes.
+	WALSyncNone = "none"
+
+	// DefaultWALSyncInterval is the period of WALSyncInterval.
+	DefaultWALSyncInterval = 100 * time.Millisecond
+
+	walRecordHeaderSize = 16
+	walSuffix           = ".log"
+)
+
+var errWALClosed = errors.New("WAL is closed")
+
+// WALOptions are options of OpenWAL.
+type WALOptions struct {
+	// Sync is one of WALSync policies, WALSyncAlways if it's empty
+	Sync         string
+	SyncInterval time.Duration
+	fs           fileSystem
+}
+
+// OpenWAL opens the log in dir and redoes records after LSN from by
+// apply in order. A torn record at the end, which was being written
+// when crashed, is removed with records after it.
+func OpenWAL(dir string, from uint64, opts *WALOptions, apply func(lsn uint64, payload []byte) error) (*WAL, error) {
+	w := &WAL{
+		fs:      opts.fs,
+		dir:     dir,
+		sync:    opts.Sync,
+		stopped: make(chan struct{}),
+	}
+	if w.fs == nil {
+		w.fs = osFileSystem{}
+	}
+	switch w.sync {
+	case "":
+		w.sync = WALSyncAlways
+	case WALSyncAlways, WALSyncInterval, WALSyncNone:
+	default:
+		return nil, fmt.Errorf("unknown WAL sync policy %s", w.sync)
+	}
+	w.cond = sync.NewCond(&w.mu)
+	if err := w.fs.MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	if err := w.redo(from, apply); err != nil {
+		return nil, err
+	}
+	if err := w.createSegment(); err != nil {
+		return nil, err
+	}
+	if w.sync == WALSyncInterval {
+		interval := opts.SyncInterval
+		if interval <= 0 {
+			interval = DefaultWALSyncInterval
+		}
+		w.done.Add(1)
+		go w.syncPeriodically(interval)
+	}
+	return w, nil
+}
+
+func (w *WAL) segmentName(first uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016x%s", first, walSuffix))
+}
+
+// redo reads all segments, nextLSN is set after the last record.
+func (w *WAL) redo(from uint64, apply func(lsn uint64, payload []byte) error) error {
+	names, err := w.fs.List(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, first)
+	}
+
+	w.nextLSN = from + 1
+	for i, first := range w.segments {
+		last := i == len(w.segments)-1
+		if first > w.nextLSN {
+			return fmt.Errorf("WAL is missing records from LSN %d to %d", w.nextLSN, first-1)
+		}
+		f, err := w.fs.OpenFile(w.segmentName(first), false)
+		if err != nil {
+			return err
+		}
+		valid, err := w.redoSegment(f, first, from, apply)
+		if err == errTornRecord && last {
+			// Records after a torn one are never committed
+			if err = f.Truncate(valid); err == nil {
+				err = f.Sync()
+			}
+		} else if err == errTornRecord {
+			err = fmt.Errorf("WAL segment %x is corrupted at offset %d", first, valid)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	w.written, w.synced = w.nextLSN-1, w.nextLSN-1
+	return nil
+}
+
+var errTornRecord = errors.New("torn WAL record")
+
+// redoSegment applies records of segment f after LSN from, and returns
+// the size of valid records in it.
+func (w *WAL) redoSegment(f file, lsn, from uint64, apply func(lsn uint64, payload []byte) error) (int64, error) {
+	size, err := f.Size()
+	if err != nil {
+		return 0, err
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil && size > 0 {
+		return 0, err
+	}
+	offset := 0
+	for offset < len(data) {
+		rest := data[offset:]
+		if len(rest) < walRecordHeaderSize {
+			return int64(offset), errTornRecord
+		}
+		length := int(binary.BigEndian.Uint32(rest[4:]))
+		if length > len(rest)-walRecordHeaderSize ||
+			crc32.ChecksumIEEE(rest[4:walRecordHeaderSize+length]) != binary.BigEndian.Uint32(rest) ||
+			binary.BigEndian.Uint64(rest[8:]) != lsn {
+			return int64(offset), errTornRecord
+		}
+		if lsn > from {
+			if lsn != w.nextLSN {
+				return 0, fmt.Errorf("WAL is missing records from LSN %d to %d", w.nextLSN, lsn-1)
+			}
+			if err := apply(lsn, rest[walRecordHeaderSize:walRecordHeaderSize+length]); err != nil {
+				return 0, fmt.Errorf("redo WAL record %d: %v", lsn, err)
+			}
+			w.nextLSN++
+		}
+		lsn++
+		offset += walRecordHeaderSize + length
+	}
+	return int64(offset), nil
+}
+
+// createSegment starts a new segment for records not written yet.
+func (w *WAL) createSegment() error {
+	first := w.written + 1
+	if n := len(w.segments); n > 0 && w.segments[n-1] == first {
+		// The last segment has no records after the torn one
+		if err := w.fs.Remove(w.segmentName(first)); err != nil {
+			return err
+		}
+		w.segments = w.segments[:n-1]
+	}
+	f, err := w.fs.OpenFile(w.segmentName(first), true)
+	if err != nil {
+		return err
+	}
+	if err := w.fs.SyncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = 0
+	w.segments = append(w.segments, first)
+	return nil
+}
+
+// Append adds a record, it's durable only after Commit of its LSN.
+func (w *WAL) Append(payload []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	var header [walRecordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	binary.BigEndian.PutUint64(header[8:], w.nextLSN)
+	checksum := crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, payload)
+	binary.BigEndian.PutUint32(header[:], checksum)
+	w.buf = append(append(w.buf, header[:]...), payload...)
+	w.nextLSN++
+	return w.nextLSN - 1, nil
+}
+
+// Commit waits until record lsn is written, and synced if the policy
+// is WALSyncAlways. Records committed together share a write.
+func (w *WAL) Commit(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.err == nil {
+		if w.synced >= lsn || w.sync != WALSyncAlways && w.written >= lsn {
+			return nil
+		}
+		if w.flushing {
+			w.cond.Wait()
+			continue
+		}
+		w.flush(w.sync == WALSyncAlways)
+	}
+	return w.err
+}
+
+// flush writes buffered records as the leader of a group, and syncs
+// them if sync is true. It's called with mu held, which is released
+// while writing.
+func (w *WAL) flush(sync bool) {
+	w.flushing = true
+	data, last, f, offset := w.buf, w.nextLSN-1, w.file, w.size
+	w.buf = nil
+	w.size += int64(len(data))
+	w.mu.Unlock()
+
+	var err error
+	if len(data) > 0 {
+		_, err = f.WriteAt(data, offset)
+	}
+	if err == nil && sync {
+		err = f.Sync()
+	}
+
+	w.mu.Lock()
+	w.flushing = false
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if err == nil {
+		w.written = last
+		if sync {
+			w.synced = last
+		}
+	}
+	w.cond.Broadcast()
+}
+
+func (w *WAL) syncPeriodically(interval time.Duration) {
+	defer w.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if !w.flushing && w.err == nil && w.synced < w.nextLSN-1 {
+				w.flush(true)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Size returns bytes of records in the current segment.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size + int64(len(w.buf))
+}
+
+// Rotate writes and syncs records appended so far, and starts a new
+// segment for later ones. It returns the LSN of the last record before it.
+func (w *WAL) Rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.flushing {
+		w.cond.Wait()
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	last := w.nextLSN - 1
+	if w.size == 0 && len(w.buf) == 0 {
+		// The current segment is empty, keep it
+		return last, nil
+	}
+	w.flush(true)
+	if w.err != nil {
+		return 0, w.err
+	}
+	old := w.file
+	if err := w.createSegment(); err != nil {
+		w.err = err
+		return 0, err
+	}
+	old.Close()
+	return last, nil
+}
+
+// Truncate removes segments holding only records up to LSN lsn,
+// the current segment is always kept.
+func (w *WAL) Truncate(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	removed := 0
+	for removed < len(w.segments)-1 && w.segments[removed+1] <= lsn+1 {
+		if err := w.fs.Remove(w.segmentName(w.segments[removed])); err != nil {
+			return err
+		}
+		removed++
+	}
+	w.segments = w.segments[removed:]
+	if removed == 0 {
+		return nil
+	}
+	return w.fs.SyncDir(w.dir)
+}
+
+// Close syncs all records and closes the current segment.
+func (w *WAL) Close() error {
+	close(w.stopped)
+	w.done.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.flushing {
+		w.cond.Wait()
+	}
+	if w.err == nil {
+		w.flush(true)
+	}
+	err := w.err
+	w.err = errWALClosed
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestWAL(t *testing.T) {
+	assert := assert.New(t)
+	fs := newCrashFS()
+	opts := &WALOptions{fs: fs}
+	var redone []string
+	redo := func(lsn uint64, payload []byte) error {
+		redone = append(redone, fmt.Sprintf("%d:%s", lsn, payload))
+		return nil
+	}
+
+	w, err := OpenWAL("wal", 0, opts, redo)
+	assert.Equal(err, nil)
+	for i := 1; i <= 3; i++ {
+		lsn, err := w.Append([]byte(fmt.Sprint("r", i)))
+		assert.Equal(err, nil)
+		assert.Equal(lsn, uint64(i))
+	}
+	assert.Equal(w.Commit(3), nil)
+	last, err := w.Rotate()
+	assert.Equal(err, nil)
+	assert.Equal(last, uint64(3))
+	lsn, _ := w.Append([]byte("r4"))
+	assert.Equal(w.Commit(lsn), nil)
+	assert.Equal(w.Close(), nil)
+
+	// Records after from are redone
+	w, err = OpenWAL("wal", 2, opts, redo)
+	assert.Equal(err, nil)
+	assert.Equal(fmt.Sprint(redone), "[3:r3 4:r4]")
+	lsn, _ = w.Append([]byte("r5"))
+	assert.Equal(lsn, uint64(5))
+	assert.Equal(w.Commit(lsn), nil)
+
+	// Segments up to LSN 3 are removed
+	assert.Equal(w.Truncate(3), nil)
+	names, _ := fs.List("wal")
+	assert.Equal(fmt.Sprint(names), "[0000000000000004.log 0000000000000005.log]")
+	assert.Equal(w.Close(), nil)
+
+	// A torn record at the end is removed
+	f, _ := fs.OpenFile("wal/0000000000000005.log", false)
+	size, _ := f.Size()
+	f.WriteAt([]byte("torn"), size)
+	redone = nil
+	w, err = OpenWAL("wal", 3, opts, redo)
+	assert.Equal(err, nil)
+	assert.Equal(fmt.Sprint(redone), "[4:r4 5:r5]")
+	lsn, _ = w.Append([]byte("r6"))
+	assert.Equal(lsn, uint64(6))
+	assert.Equal(w.Commit(lsn), nil)
+	assert.Equal(w.Close(), nil)
+
+	// Records after from must not be missing
+	_, err = OpenWAL("wal", 1, opts, redo)
+	assert.NEqual(err, nil)
+}
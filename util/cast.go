@@ -0,0 +1,390 @@
+package util
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cast converts v into the Go type holding values of column type tp,
+// v is returned unchanged if there is no such conversion. NULL stays
+// nil whatever the type is.
+func Cast(v interface{}, tp ColumnType) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch value := v.(type) {
+	case bool:
+		if tp != ColumnBoolean && tp != ColumnJSON {
+			v = boolToInt(value)
+		}
+	case Enum:
+		// Enum is its index as a number, or its element otherwise
+		if isNumeric(tp) {
+			v = value.Index
+		} else if tp != ColumnEnum {
+			v = value.String()
+		}
+	}
+	if tp.IsTemporal() {
+		return castDate(v)
+	}
+	switch tp {
+	case ColumnInt32, ColumnInt64, ColumnUInt32, ColumnUInt64:
+		// Out of range values wrap around, strings not numbers are 0
+		v, _ = CastInteger(v, tp)
+		return v
+	case ColumnFixedString, ColumnVarchar, ColumnText:
+		return castFixedString(v)
+	case ColumnBlob:
+		return castBlob(v)
+	case ColumnFloat:
+		return float32(castDouble(v))
+	case ColumnDouble:
+		return castDouble(v)
+	case ColumnDecimal:
+		return castDecimal(v)
+	case ColumnBoolean:
+		return castBoolean(v)
+	case ColumnJSON:
+		return castJSON(v)
+	default:
+		return v
+	}
+}
+
+// CastColumn is Cast, but values of Decimal column are also rounded
+// to its scale, and temporal values to precision of the column.
+// Values out of range of the column are returned as they are, and
+// rejected when the row is encoded.
+func CastColumn(v interface{}, c *Column) interface{} {
+	if s, ok := v.(string); ok && c.Type.IsTemporal() {
+		// Left as it is, AppendValue refuses it
+		if _, err := ParseDate(s); err != nil {
+			return v
+		}
+	}
+	if c.Type.IsInteger() {
+		// Left as it is, AppendValue refuses it
+		if i, ok := CastInteger(v, c.Type); ok && c.holdsInteger(i) {
+			return i
+		}
+		return v
+	}
+	if d, ok := Cast(v, ColumnDouble).(float64); ok && c.Type == ColumnFloat && math.Abs(d) > math.MaxFloat32 {
+		// Left as it is rather than infinity, AppendValue refuses it
+		return v
+	}
+	v = Cast(v, c.Type)
+	switch value := v.(type) {
+	case Decimal:
+		if r, err := value.Rescale(c.Scale); err == nil {
+			return r
+		}
+	case Enum:
+		if c.Type == ColumnEnum {
+			return castEnum(value.String(), c)
+		}
+	case string, int, int64, uint, uint64:
+		if c.Type == ColumnEnum {
+			return castEnum(value, c)
+		}
+	case Date:
+		switch c.Type {
+		case ColumnDate:
+			return value.Round(0)
+		case ColumnDateOnly:
+			return value.Day()
+		case ColumnDatetime, ColumnTimestamp:
+			return value.Round(c.Fsp)
+		}
+	}
+	return v
+}
+
+// integer is an integer of any sign and width.
+type integer struct {
+	neg bool
+	mag uint64
+}
+
+// toInteger converts v into an integer, numbers are rounded. ok is
+// false if v isn't a number or out of range of 64-bit integers.
+func toInteger(v interface{}) (n integer, ok bool) {
+	switch value := v.(type) {
+	case bool:
+		return integer{mag: uint64(boolToInt(value))}, true
+	case int:
+		return signedInteger(int64(value)), true
+	case int32:
+		return signedInteger(int64(value)), true
+	case int64:
+		return signedInteger(value), true
+	case uint:
+		return integer{mag: uint64(value)}, true
+	case uint32:
+		return integer{mag: uint64(value)}, true
+	case uint64:
+		return integer{mag: value}, true
+	case float32:
+		return floatInteger(float64(value))
+	case float64:
+		return floatInteger(value)
+	case Decimal:
+		return signedInteger(roundDecimal(value)), true
+	case Enum:
+		return integer{mag: uint64(value.Index)}, true
+	case string:
+		s := strings.TrimSpace(value)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return signedInteger(i), true
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return integer{mag: u}, true
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return floatInteger(f)
+		}
+		return integer{}, false
+	default:
+		return integer{}, false
+	}
+}
+
+func signedInteger(v int64) integer {
+	if v < 0 {
+		// -v overflows for math.MinInt64
+		return integer{neg: true, mag: uint64(-(v + 1)) + 1}
+	}
+	return integer{mag: uint64(v)}
+}
+
+func floatInteger(f float64) (integer, bool) {
+	f = math.Round(f)
+	switch {
+	case math.IsNaN(f):
+		return integer{}, false
+	case f < 0:
+		return integer{neg: true, mag: uint64(-f)}, f >= math.MinInt64
+	default:
+		return integer{mag: uint64(f)}, f < math.MaxUint64
+	}
+}
+
+// int64 wraps n around if it's out of range.
+func (n integer) int64() int64 {
+	if n.neg {
+		return -int64(n.mag)
+	}
+	return int64(n.mag)
+}
+
+// inRange tells whether n is in [min, max].
+func (n integer) inRange(min int64, max uint64) bool {
+	if n.neg {
+		return min < 0 && n.mag <= signedInteger(min).mag
+	}
+	return n.mag <= max
+}
+
+// CastInteger is Cast into integer type tp, ok is false if v isn't
+// a number or out of range of tp.
+func CastInteger(v interface{}, tp ColumnType) (interface{}, bool) {
+	n, ok := toInteger(v)
+	switch tp {
+	case ColumnInt32:
+		return int(n.int64()), ok && n.inRange(math.MinInt32, math.MaxInt32)
+	case ColumnInt64:
+		return n.int64(), ok && n.inRange(math.MinInt64, math.MaxInt64)
+	case ColumnUInt32:
+		return uint(n.int64()), ok && n.inRange(0, math.MaxUint32)
+	case ColumnUInt64:
+		return uint64(n.int64()), ok && n.inRange(0, math.MaxUint64)
+	default:
+		return v, false
+	}
+}
+
+func castFixedString(v interface{}) string {
+	switch value := v.(type) {
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case uint:
+		return strconv.FormatUint(uint64(value), 10)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case string:
+		return value
+	case Date:
+		return value.String()
+	case Blob:
+		return string(value)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case Decimal:
+		return value.String()
+	case JSON:
+		return string(value)
+	default:
+		return ""
+	}
+}
+
+func castBlob(v interface{}) Blob {
+	if value, ok := v.(Blob); ok {
+		return value
+	}
+	return Blob(castFixedString(v))
+}
+
+func castDate(v interface{}) Date {
+	switch value := v.(type) {
+	case Date:
+		return value
+	case int:
+		return Date(time.Unix(int64(value), 0))
+	case int64:
+		return Date(time.Unix(value, 0))
+	case float64:
+		return DateFromMicros(int64(math.Round(value * 1e6)))
+	case Decimal:
+		if r, err := value.Rescale(MaxFsp); err == nil {
+			return DateFromMicros(r.Unscaled)
+		}
+		return Date(time.Unix(0, 0))
+	case string:
+		if d, err := ParseDate(value); err == nil {
+			return d
+		}
+		return Date(time.Unix(0, 0))
+	default:
+		return Date(time.Unix(0, 0))
+	}
+}
+
+func castDouble(v interface{}) float64 {
+	switch value := v.(type) {
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case float64:
+		return value
+	case Decimal:
+		return value.Float64()
+	case string:
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+func castDecimal(v interface{}) Decimal {
+	switch value := v.(type) {
+	case Decimal:
+		return value
+	case int:
+		return Decimal{Unscaled: int64(value)}
+	case int64:
+		return Decimal{Unscaled: value}
+	case float32, float64, uint, uint64, string:
+		d, err := ParseDecimal(castFixedString(value))
+		if err != nil {
+			// Exponent or out of range, use the closest one we have
+			d, _ = ParseDecimal(strconv.FormatFloat(castDouble(value), 'f', -1, 64))
+		}
+		return d
+	default:
+		return Decimal{}
+	}
+}
+
+// castJSON parses strings as JSON texts, an invalid one is returned
+// as it is and rejected when the row is encoded. Other values become
+// JSON scalars.
+func castJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case JSON:
+		return value
+	case string:
+		if j, err := ParseJSON(value); err == nil {
+			return j
+		}
+		return value
+	case bool, int, int64, uint, uint64, float32, float64:
+		return NewJSON(value)
+	case Decimal:
+		return JSON(value.String())
+	default:
+		return NewJSON(castFixedString(value))
+	}
+}
+
+// castEnum finds element v or the v-th element of Enum column c, v is
+// returned as it is if there is no such one, and rejected when the
+// row is encoded.
+func castEnum(v interface{}, c *Column) interface{} {
+	e := Enum{Elems: c.Elems}
+	if s, ok := v.(string); ok {
+		e.Index = e.IndexOf(s)
+	} else {
+		i, _ := CastInteger(v, ColumnInt32)
+		e.Index = i.(int)
+	}
+	if e.Index < 1 || e.Index > len(c.Elems) {
+		return v
+	}
+	return e
+}
+
+func isNumeric(tp ColumnType) bool {
+	switch tp {
+	case ColumnInt32, ColumnInt64, ColumnUInt32, ColumnUInt64, ColumnFloat, ColumnDouble, ColumnDecimal, ColumnBoolean:
+		return true
+	default:
+		return false
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// castBoolean is true for numbers not equal to zero, strings are
+// TRUE, FALSE or numbers.
+func castBoolean(v interface{}) bool {
+	switch value := v.(type) {
+	case bool:
+		return value
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+			return b
+		}
+		return castDouble(value) != 0
+	case Decimal:
+		return value.Unscaled != 0
+	default:
+		return castDouble(value) != 0
+	}
+}
+
+// roundDecimal rounds d half away from zero to an integer.
+func roundDecimal(d Decimal) int64 {
+	r, _ := d.Rescale(0)
+	return r.Unscaled
+}
@@ -0,0 +1,232 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Memcomparable encoding: comparing encoded values byte by byte gives
+// the same order as comparing the values, so rows are stored by order
+// of their primary keys.
+
+const (
+	signMask uint64 = 1 << 63
+
+	encGroupSize = 8
+	encMarker    = byte(0xFF)
+	encPad       = byte(0x0)
+)
+
+var errInvalidKey = errors.New("invalid memcomparable key")
+
+// EncodeInt flips the sign bit, so negative numbers sort first.
+func EncodeInt(b []byte, v int64) []byte {
+	return EncodeUint(b, uint64(v)^signMask)
+}
+
+func DecodeInt(b []byte) ([]byte, int64, error) {
+	b, v, err := DecodeUint(b)
+	return b, int64(v ^ signMask), err
+}
+
+func EncodeUint(b []byte, v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return append(b, buf...)
+}
+
+func DecodeUint(b []byte) ([]byte, uint64, error) {
+	if len(b) < 8 {
+		return nil, 0, errInvalidKey
+	}
+	return b[8:], binary.BigEndian.Uint64(b), nil
+}
+
+// EncodeFloat flips the sign bit of positive numbers and all bits
+// of negative ones, so they sort by bytes in order of values.
+func EncodeFloat(b []byte, v float64) []byte {
+	u := math.Float64bits(v)
+	if v >= 0 {
+		u |= signMask
+	} else {
+		u = ^u
+	}
+	return EncodeUint(b, u)
+}
+
+func DecodeFloat(b []byte) ([]byte, float64, error) {
+	b, u, err := DecodeUint(b)
+	if u&signMask != 0 {
+		u &^= signMask
+	} else {
+		u = ^u
+	}
+	return b, math.Float64frombits(u), err
+}
+
+// EncodeBytes splits data into groups of 8 bytes, each followed by a
+// marker counting the real bytes in it:
+//
+//	[group1][marker1]...[groupN][markerN]
+//
+// The last group is padded with zero and its marker is 0xFF minus the
+// number of padding bytes, other markers are 0xFF. So "a" sorts before
+// "a\x00" and before "ab", and no encoded value is a prefix of another.
+func EncodeBytes(b []byte, data []byte) []byte {
+	for idx := 0; idx <= len(data); idx += encGroupSize {
+		remain := len(data) - idx
+		padCount := 0
+		if remain >= encGroupSize {
+			b = append(b, data[idx:idx+encGroupSize]...)
+		} else {
+			padCount = encGroupSize - remain
+			b = append(b, data[idx:]...)
+			for i := 0; i < padCount; i++ {
+				b = append(b, encPad)
+			}
+		}
+		b = append(b, encMarker-byte(padCount))
+	}
+	return b
+}
+
+func DecodeBytes(b []byte) ([]byte, []byte, error) {
+	var data []byte
+	for {
+		if len(b) < encGroupSize+1 {
+			return nil, nil, errInvalidKey
+		}
+		group := b[:encGroupSize]
+		marker := b[encGroupSize]
+		b = b[encGroupSize+1:]
+		padCount := encMarker - marker
+		if padCount > encGroupSize {
+			return nil, nil, errInvalidKey
+		}
+		data = append(data, group[:encGroupSize-int(padCount)]...)
+		if padCount != 0 {
+			return b, data, nil
+		}
+	}
+}
+
+// EncodeKey appends values of key columns in memcomparable format,
+// values must have been casted to types of columns.
+func EncodeKey(b []byte, values []interface{}, columns []*Column) []byte {
+	for i, v := range values {
+		switch value := v.(type) {
+		case Int32:
+			b = EncodeInt(b, int64(value))
+		case Int64:
+			b = EncodeInt(b, value)
+		case UInt32:
+			b = EncodeUint(b, uint64(value))
+		case UInt64:
+			b = EncodeUint(b, value)
+		case FixedString:
+			b = EncodeBytes(b, []byte(value))
+		case Blob:
+			b = EncodeBytes(b, value)
+		case Date:
+			b = EncodeInt(b, dateKey(value, columns[i]))
+		case Float:
+			b = EncodeFloat(b, float64(value))
+		case Double:
+			b = EncodeFloat(b, value)
+		case Decimal:
+			// All values of a column have the same scale
+			b = EncodeInt(b, value.Unscaled)
+		case Boolean:
+			b = EncodeUint(b, uint64(boolToInt(value)))
+		case Enum:
+			b = EncodeUint(b, uint64(value.Index))
+		default:
+			panic(fmt.Sprintf("Unsupported key type %T", v))
+		}
+	}
+	return b
+}
+
+// dateKey returns the integer a Date is stored as in column c.
+func dateKey(v Date, c *Column) int64 {
+	switch c.Type {
+	case ColumnDateOnly, ColumnDatetime:
+		return v.WallMicros()
+	case ColumnTimestamp:
+		return v.Micros()
+	default:
+		return v.Timestamp()
+	}
+}
+
+// DecodeKey decodes values of key columns encoded by EncodeKey.
+func DecodeKey(b []byte, columns []*Column) ([]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		var err error
+		switch c.Type {
+		case ColumnInt32:
+			var v int64
+			b, v, err = DecodeInt(b)
+			values[i] = Int32(v)
+		case ColumnInt64:
+			b, values[i], err = DecodeInt(b)
+		case ColumnUInt32:
+			var v uint64
+			b, v, err = DecodeUint(b)
+			values[i] = UInt32(v)
+		case ColumnUInt64:
+			b, values[i], err = DecodeUint(b)
+		case ColumnFixedString, ColumnVarchar, ColumnText:
+			var v []byte
+			b, v, err = DecodeBytes(b)
+			values[i] = FixedString(v)
+		case ColumnBlob:
+			var v []byte
+			b, v, err = DecodeBytes(b)
+			values[i] = Blob(v)
+		case ColumnFloat:
+			var v float64
+			b, v, err = DecodeFloat(b)
+			values[i] = Float(v)
+		case ColumnDouble:
+			b, values[i], err = DecodeFloat(b)
+		case ColumnDecimal:
+			var v int64
+			b, v, err = DecodeInt(b)
+			values[i] = Decimal{Unscaled: v, Scale: c.Scale}
+		case ColumnDate:
+			var v int64
+			b, v, err = DecodeInt(b)
+			values[i] = Date(time.Unix(v, 0))
+		case ColumnDateOnly, ColumnDatetime:
+			var v int64
+			b, v, err = DecodeInt(b)
+			values[i] = DateFromWallMicros(v)
+		case ColumnTimestamp:
+			var v int64
+			b, v, err = DecodeInt(b)
+			values[i] = DateFromMicros(v)
+		case ColumnBoolean:
+			var v uint64
+			b, v, err = DecodeUint(b)
+			values[i] = v != 0
+		case ColumnEnum:
+			var v uint64
+			b, v, err = DecodeUint(b)
+			values[i] = Enum{Index: int(v), Elems: c.Elems}
+		default:
+			return nil, fmt.Errorf("unsupported key type %s", c.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(b) != 0 {
+		return nil, errInvalidKey
+	}
+	return values, nil
+}
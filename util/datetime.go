@@ -0,0 +1,144 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxFsp is the max digits of fractional seconds, temporal values
+// are kept in microseconds.
+const MaxFsp = 6
+
+// TimeZone is the time zone DATETIME values are in. TIMESTAMP values
+// are stored in UTC and converted to it when read.
+var TimeZone = time.Local
+
+var offsetRegexp = regexp.MustCompile(`^([+-])(\d{1,2}):(\d{2})$`)
+
+// ParseTimeZone parses SYSTEM, an offset like +08:00 or a name of
+// IANA time zone database like Asia/Shanghai.
+func ParseTimeZone(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "SYSTEM") {
+		return time.Local, nil
+	}
+	if m := offsetRegexp.FindStringSubmatch(name); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3])
+		if h > 14 || min > 59 {
+			return nil, fmt.Errorf("unknown time zone %s", name)
+		}
+		offset := h*3600 + min*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	return loc, nil
+}
+
+// Date is an instant of time, it's the value of all temporal types.
+type Date time.Time
+
+func (v Date) TypeName() string {
+	return "Date"
+}
+
+// String formats dt in TimeZone, fractional seconds are shown if there are.
+func (dt Date) String() string {
+	return time.Time(dt).In(TimeZone).Format("2006-01-02 15:04:05.999999")
+}
+
+// Format formats dt as a value of column c.
+func (dt Date) Format(c *Column) string {
+	t := time.Time(dt).In(TimeZone)
+	switch c.Type {
+	case ColumnDateOnly:
+		return t.Format("2006-01-02")
+	case ColumnDatetime, ColumnTimestamp:
+		if c.Fsp > 0 {
+			return t.Format("2006-01-02 15:04:05." + strings.Repeat("0", c.Fsp))
+		}
+		return t.Format("2006-01-02 15:04:05")
+	default:
+		return dt.String()
+	}
+}
+
+// Timestamp will return Unix seconds timestamp
+func (dt Date) Timestamp() int64 {
+	return time.Time(dt).Unix()
+}
+
+// Micros returns microseconds since Unix epoch.
+func (dt Date) Micros() int64 {
+	t := time.Time(dt)
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// WallMicros returns microseconds since Unix epoch of the wall clock
+// of dt in TimeZone, as if it was in UTC.
+func (dt Date) WallMicros() int64 {
+	t := time.Time(dt).In(TimeZone)
+	_, offset := t.Zone()
+	return Date(t.Add(time.Duration(offset) * time.Second)).Micros()
+}
+
+// DateFromMicros is the reverse of Date.Micros.
+func DateFromMicros(v int64) Date {
+	return Date(time.Unix(0, 0).Add(time.Duration(v) * time.Microsecond))
+}
+
+// DateFromWallMicros is the reverse of Date.WallMicros.
+func DateFromWallMicros(v int64) Date {
+	return FromWallClock(time.Time(DateFromMicros(v)).UTC(), TimeZone)
+}
+
+// FromWallClock returns the instant when the wall clock in loc shows
+// the same time as t.
+func FromWallClock(t time.Time, loc *time.Location) Date {
+	return Date(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc))
+}
+
+// Round rounds dt to fsp digits of fractional seconds.
+func (dt Date) Round(fsp int) Date {
+	d := time.Second
+	for i := 0; i < fsp; i++ {
+		d /= 10
+	}
+	return Date(time.Time(dt).Round(d))
+}
+
+// Day returns the midnight of the day of dt in TimeZone.
+func (dt Date) Day() Date {
+	y, m, d := time.Time(dt).In(TimeZone).Date()
+	return Date(time.Date(y, m, d, 0, 0, 0, 0, TimeZone))
+}
+
+// Fractional seconds may follow seconds in all these layouts.
+var dateLayouts = []string{
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseDate parses a date or datetime string in TimeZone unless it
+// has its own offset.
+func ParseDate(s string) (Date, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, TimeZone); err == nil {
+			return Date(t), nil
+		}
+	}
+	return Date{}, fmt.Errorf("incorrect datetime value '%s'", s)
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestDatetime(t *testing.T) {
+	assert := assert.New(t)
+	defer func(loc *time.Location) { TimeZone = loc }(TimeZone)
+	var err error
+	TimeZone, err = ParseTimeZone("+08:00")
+	assert.Equal(err, nil)
+	_, err = ParseTimeZone("+25:00")
+	assert.NEqual(err, nil)
+
+	dt, err := ParseDate("2024-01-31 10:20:30.1234567")
+	assert.Equal(err, nil)
+	assert.Equal(dt.String(), "2024-01-31 10:20:30.123456")
+	assert.Equal(dt.Round(3).Format(&Column{Type: ColumnDatetime, Fsp: 3}), "2024-01-31 10:20:30.123")
+	assert.Equal(dt.Format(&Column{Type: ColumnTimestamp}), "2024-01-31 10:20:30")
+	assert.Equal(dt.Day().Format(&Column{Type: ColumnDateOnly}), "2024-01-31")
+
+	// Offset in value overrides TimeZone
+	utc, err := ParseDate("2024-01-31T02:20:30.123456Z")
+	assert.Equal(err, nil)
+	assert.Equal(utc.Micros(), dt.Micros())
+	assert.Equal(dt.WallMicros()-dt.Micros(), int64(8*3600e6))
+	assert.Equal(DateFromWallMicros(dt.WallMicros()).Micros(), dt.Micros())
+	_, err = ParseDate("2024-13-01")
+	assert.NEqual(err, nil)
+
+	// DATETIME keeps the wall clock, TIMESTAMP keeps the instant
+	datetime := &Column{Type: ColumnDatetime, Fsp: 6}
+	timestamp := &Column{Type: ColumnTimestamp, Fsp: 6}
+	b := NewRawBuilder()
+	assert.Equal(b.AppendValue(datetime, dt), nil)
+	assert.Equal(b.AppendValue(timestamp, dt), nil)
+	raw := append([]byte{}, b.Spawn()...)
+	TimeZone = time.UTC
+	v, _, err := readColumn(RowFormatNullable, raw, 0, datetime)
+	assert.Equal(err, nil)
+	assert.Equal(v.(Date).String(), "2024-01-31 10:20:30.123456")
+	v, _, err = readColumn(RowFormatNullable, raw, 8, timestamp)
+	assert.Equal(err, nil)
+	assert.Equal(v.(Date).String(), "2024-01-31 02:20:30.123456")
+}
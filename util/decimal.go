@@ -0,0 +1,144 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxDecimalPrecision is the max number of digits of Decimal,
+// its unscaled value must fit in an int64.
+const MaxDecimalPrecision = 18
+
+var ErrDecimalOverflow = errors.New("decimal is out of range")
+
+// Decimal is an exact number of Unscaled / 10^Scale.
+type Decimal struct {
+	Unscaled int64
+	Scale    int
+}
+
+func (d Decimal) TypeName() string {
+	return "Decimal"
+}
+
+// ParseDecimal parses a number like -12.345, the scale of result
+// is the number of digits after the point.
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	parts := strings.SplitN(s, ".", 2)
+	digits := parts[0]
+	scale := 0
+	if len(parts) == 2 {
+		digits += parts[1]
+		scale = len(parts[1])
+	}
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	digits = strings.TrimLeft(digits, "0")
+	if len(digits) > MaxDecimalPrecision {
+		return Decimal{}, ErrDecimalOverflow
+	}
+	v, _ := strconv.ParseInt("0"+digits, 10, 64)
+	if neg {
+		v = -v
+	}
+	return Decimal{Unscaled: v, Scale: scale}, nil
+}
+
+// Rescale returns d with scale digits after the point, extra
+// digits are rounded half away from zero.
+func (d Decimal) Rescale(scale int) (Decimal, error) {
+	v := d.Unscaled
+	for s := d.Scale; s < scale; s++ {
+		if v > math.MaxInt64/10 || v < math.MinInt64/10 {
+			return Decimal{}, ErrDecimalOverflow
+		}
+		v *= 10
+	}
+	if d.Scale > scale {
+		// Round once by all dropped digits, a digit at a time would
+		// round 1.45 to 2. Truncating digits beyond int64 first
+		// doesn't change the result.
+		dropped := d.Scale - scale
+		for ; dropped > MaxDecimalPrecision; dropped-- {
+			v /= 10
+		}
+		div := int64(math.Pow10(dropped))
+		rem := v % div
+		v /= div
+		if rem >= (div+1)/2 && rem > 0 {
+			v++
+		} else if -rem >= (div+1)/2 && rem < 0 {
+			v--
+		}
+	}
+	return Decimal{Unscaled: v, Scale: scale}, nil
+}
+
+// Precision returns the number of digits of d, ignoring leading zeros
+// before the point.
+func (d Decimal) Precision() int {
+	digits := len(strconv.FormatUint(abs(d.Unscaled), 10))
+	if digits < d.Scale {
+		return d.Scale
+	}
+	return digits
+}
+
+// Cmp returns -1, 0 or 1 if d is less than, equal to or greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	scale := d.Scale
+	if o.Scale > scale {
+		scale = o.Scale
+	}
+	l, lerr := d.Rescale(scale)
+	r, rerr := o.Rescale(scale)
+	if lerr != nil || rerr != nil {
+		// Too large to align, they can't be equal
+		lf, rf := d.Float64(), o.Float64()
+		if lf < rf {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case l.Unscaled < r.Unscaled:
+		return -1
+	case l.Unscaled > r.Unscaled:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) Float64() float64 {
+	return float64(d.Unscaled) / math.Pow10(d.Scale)
+}
+
+func (d Decimal) String() string {
+	s := strconv.FormatUint(abs(d.Unscaled), 10)
+	if d.Scale > 0 {
+		if len(s) <= d.Scale {
+			s = strings.Repeat("0", d.Scale-len(s)+1) + s
+		}
+		s = s[:len(s)-d.Scale] + "." + s[len(s)-d.Scale:]
+	}
+	if d.Unscaled < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// abs returns |v| as an uint64, so MinInt64 doesn't overflow.
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-(v + 1)) + 1
+	}
+	return uint64(v)
+}
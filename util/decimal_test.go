@@ -0,0 +1,46 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestDecimal(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := ParseDecimal("-012.340")
+	assert.Equal(err, nil)
+	assert.Equal(d, Decimal{Unscaled: -12340, Scale: 3})
+	assert.Equal(d.String(), "-12.340")
+	assert.Equal(d.Precision(), 5)
+	assert.Equal(Decimal{Unscaled: 5, Scale: 3}.String(), "0.005")
+
+	_, err = ParseDecimal("1e5")
+	assert.NEqual(err, nil)
+	_, err = ParseDecimal("1234567890.1234567890")
+	assert.Equal(err, ErrDecimalOverflow)
+
+	cases := map[string]string{
+		"1.4999":                   "1",
+		"1.5":                      "2",
+		"-1.5":                     "-2",
+		"-1.4999":                  "-1",
+		"0.49":                     "0",
+		"0.0000000000000000000006": "0",
+	}
+	for s, expect := range cases {
+		d, err := ParseDecimal(s)
+		assert.Equal(err, nil)
+		r, err := d.Rescale(0)
+		assert.Equal(err, nil)
+		assert.Equal(r.String(), expect)
+	}
+	r, _ := Decimal{Unscaled: 15, Scale: 1}.Rescale(3)
+	assert.Equal(r.String(), "1.500")
+	_, err = Decimal{Unscaled: 1 << 62}.Rescale(2)
+	assert.Equal(err, ErrDecimalOverflow)
+
+	assert.Equal(Decimal{Unscaled: 15, Scale: 1}.Cmp(Decimal{Unscaled: 150, Scale: 2}), 0)
+	assert.Equal(Decimal{Unscaled: -15, Scale: 1}.Cmp(Decimal{Unscaled: -1}), -1)
+}
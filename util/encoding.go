@@ -3,48 +3,320 @@ package util
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
 	"time"
 )
 
-func ReadRow(row []byte, schema *Schema) *Row {
+// A row is encoded as:
+//
+//	[format][schema version][null bitmap][column 1]...[column N][checksum]
+//
+// format is one byte and schema version is an uvarint. Columns are
+// encoded by the layout of the version, in the way format defines.
+// Null bitmap has a bit for each column of the layout, NULL columns
+// take no space after it. Checksum is CRC-32 (IEEE) of all bytes
+// before it in big endian.
+const (
+	// RowFormatVarint put varint into fixed width slots, which truncates
+	// large values. It's only kept to read rows written by old versions.
+	RowFormatVarint byte = 1
+	// RowFormatFixed encodes integers and dates as big endian two's
+	// complement of their width, FixedString is padded with zero to Strlen.
+	// Varchar, Text and Blob are prefixed by uvarint of their length.
+	// Float and Double are IEEE 754 binary, Decimal is its unscaled
+	// value in the scale of column.
+	RowFormatFixed byte = 2
+	// RowFormatNullable is RowFormatFixed with a null bitmap after
+	// schema version.
+	RowFormatNullable byte = 3
+	// RowFormatChecksum is RowFormatNullable with a checksum at the end.
+	RowFormatChecksum byte = 4
+
+	// RowFormat is the format new rows are written in.
+	RowFormat = RowFormatChecksum
+)
+
+const checksumSize = 4
+
+var (
+	ErrTruncatedRow     = errors.New("row is truncated")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// CorruptedRowError is returned when a stored row can't be decoded.
+type CorruptedRowError struct {
+	Database string
+	Table    string
+	// Key is the primary key of row, or the raw key in hex if it
+	// can't be decoded either.
+	Key string
+	Err error
+}
+
+func (e *CorruptedRowError) Error() string {
+	return fmt.Sprintf("corrupted row of table %s.%s at key %s: %v", e.Database, e.Table, e.Key, e.Err)
+}
+
+func (e *CorruptedRowError) Unwrap() error {
+	return e.Err
+}
+
+// ReadRow decodes a row stored at key into the current version of
+// schema, errors are reported as *CorruptedRowError.
+// Rows written with an older version are converted: columns added
+// since then get their origin default and dropped ones are skipped.
+func ReadRow(key, row []byte, schema *Schema) (*Row, error) {
+	r, err := readRow(row, schema)
+	if err != nil {
+		return nil, &CorruptedRowError{
+			Database: schema.Database,
+			Table:    schema.TableName,
+			Key:      formatRowKey(key, schema),
+			Err:      err,
+		}
+	}
+	return r, nil
+}
+
+// formatRowKey formats primary key in key like (1, 'a').
+func formatRowKey(key []byte, schema *Schema) string {
+	prefix := RowPrefix(schema.Database, schema.TableName)
+	if !bytes.HasPrefix(key, prefix) {
+		return fmt.Sprintf("0x%X", key)
+	}
+	values, err := DecodeKey(key[len(prefix):], schema.ColumnsByName(schema.PrimaryKey))
+	if err != nil {
+		return fmt.Sprintf("0x%X", key)
+	}
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch v.(type) {
+		case Blob:
+			fmt.Fprintf(&b, "0x%X", v)
+		case string, Date, Enum:
+			fmt.Fprintf(&b, "'%s'", v)
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func readRow(row []byte, schema *Schema) (r *Row, err error) {
+	// Values are checked while being decoded, this is the last resort
+	defer func() {
+		if e := recover(); e != nil {
+			r, err = nil, fmt.Errorf("%v", e)
+		}
+	}()
+
+	format, version, offset, err := readRowHeader(row)
+	if err != nil {
+		return nil, err
+	}
+	if format >= RowFormatChecksum {
+		end := len(row) - checksumSize
+		if end < offset {
+			return nil, ErrTruncatedRow
+		}
+		if crc32.ChecksumIEEE(row[:end]) != binary.BigEndian.Uint32(row[end:]) {
+			return nil, ErrChecksumMismatch
+		}
+		row = row[:end]
+	}
+	layout := schema.ColumnsOfVersion(version)
+	if layout == nil {
+		return nil, fmt.Errorf("unknown version %d of table %s", version, schema.TableName)
+	}
+
+	var nulls []byte
+	if format >= RowFormatNullable {
+		size := nullBitmapSize(len(layout))
+		if offset+size > len(row) {
+			return nil, ErrTruncatedRow
+		}
+		nulls = row[offset : offset+size]
+		offset += size
+	}
+
+	values := make([]interface{}, len(layout))
+	for i, c := range layout {
+		if nulls != nil && nulls[i/8]&(1<<uint(i%8)) != 0 {
+			continue
+		}
+		values[i], offset, err = readColumn(format, row, offset, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if offset != len(row) {
+		return nil, fmt.Errorf("row has %d unknown trailing bytes", len(row)-offset)
+	}
+
+	if version == schema.Version {
+		return &Row{
+			Schema: schema,
+			Values: values,
+		}, nil
+	}
+
+	byID := make(map[int]interface{}, len(layout))
+	for i, c := range layout {
+		byID[c.ID] = values[i]
+	}
 	columns := make([]interface{}, len(schema.Columns))
-	var offset int
 	for i, c := range schema.Columns {
-		columns[i], offset = readOneColumn(row, offset, c)
+		v, ok := byID[c.ID]
+		if !ok {
+			v = c.originValue()
+		}
+		columns[i] = CastColumn(v, c)
 	}
 	return &Row{
 		Schema: schema,
 		Values: columns,
+	}, nil
+}
+
+// IsRowOutdated tells whether row is written with an older version
+// of schema or in an older format.
+func IsRowOutdated(row []byte, schema *Schema) bool {
+	format, version, _, err := readRowHeader(row)
+	return err == nil && (format != RowFormat || version != schema.Version)
+}
+
+// readRowHeader returns format and schema version of row, and offset of the first column.
+func readRowHeader(row []byte) (byte, uint32, int, error) {
+	if len(row) == 0 {
+		return 0, 0, 0, ErrTruncatedRow
+	}
+	format := row[0]
+	if format < RowFormatVarint || format > RowFormatChecksum {
+		return 0, 0, 0, fmt.Errorf("unknown row format %d", format)
+	}
+	version, n := binary.Uvarint(row[1:])
+	if n <= 0 || version > math.MaxUint32 {
+		return 0, 0, 0, errors.New("invalid row header")
 	}
+	return format, uint32(version), 1 + n, nil
 }
 
-func readOneColumn(row []byte, offset int, column *Column) (interface{}, int) {
+func nullBitmapSize(columns int) int {
+	return (columns + 7) / 8
+}
+
+// columnWidth returns number of bytes a column takes in a row.
+func columnWidth(column *Column) int {
+	switch column.Type {
+	case ColumnBoolean:
+		return 1
+	case ColumnEnum:
+		return 2
+	case ColumnInt32, ColumnUInt32, ColumnFloat:
+		return 4
+	case ColumnInt64, ColumnUInt64, ColumnDate, ColumnDouble, ColumnDecimal,
+		ColumnDateOnly, ColumnDatetime, ColumnTimestamp:
+		return 8
+	case ColumnFixedString:
+		return column.Strlen
+	default:
+		return 0
+	}
+}
+
+func readColumn(format byte, row []byte, offset int, column *Column) (interface{}, int, error) {
+	if column.Type.IsVariableLength() {
+		return readVariableLengthColumn(row, offset, column)
+	}
+	width := columnWidth(column)
+	if width == 0 && column.Type != ColumnFixedString {
+		return nil, 0, fmt.Errorf("unknown type of column %s", column.Name)
+	}
+	if offset+width > len(row) {
+		return nil, 0, ErrTruncatedRow
+	}
+	slice := row[offset : offset+width]
+	if format == RowFormatVarint {
+		return readVarintColumn(slice, column), offset + width, nil
+	}
+	switch column.Type {
+	case ColumnInt32:
+		return Int32(int32(binary.BigEndian.Uint32(slice))), offset + width, nil
+	case ColumnInt64:
+		return Int64(binary.BigEndian.Uint64(slice)), offset + width, nil
+	case ColumnUInt32:
+		return UInt32(binary.BigEndian.Uint32(slice)), offset + width, nil
+	case ColumnUInt64:
+		return UInt64(binary.BigEndian.Uint64(slice)), offset + width, nil
+	case ColumnFixedString:
+		return FixedString(bytes.TrimRight(slice, "\x00")), offset + width, nil
+	case ColumnFloat:
+		return math.Float32frombits(binary.BigEndian.Uint32(slice)), offset + width, nil
+	case ColumnDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(slice)), offset + width, nil
+	case ColumnDecimal:
+		return Decimal{Unscaled: int64(binary.BigEndian.Uint64(slice)), Scale: column.Scale}, offset + width, nil
+	case ColumnDateOnly, ColumnDatetime:
+		return DateFromWallMicros(int64(binary.BigEndian.Uint64(slice))), offset + width, nil
+	case ColumnTimestamp:
+		return DateFromMicros(int64(binary.BigEndian.Uint64(slice))), offset + width, nil
+	case ColumnBoolean:
+		return slice[0] != 0, offset + width, nil
+	case ColumnEnum:
+		index := int(binary.BigEndian.Uint16(slice))
+		if index < 1 || index > len(column.Elems) {
+			return nil, 0, fmt.Errorf("invalid index %d of column %s", index, column.Name)
+		}
+		return Enum{Index: index, Elems: column.Elems}, offset + width, nil
+	default:
+		return Date(time.Unix(int64(binary.BigEndian.Uint64(slice)), 0)), offset + width, nil
+	}
+}
+
+func readVariableLengthColumn(row []byte, offset int, column *Column) (interface{}, int, error) {
+	length, n := binary.Uvarint(row[offset:])
+	if n <= 0 || length > uint64(len(row)-offset-n) {
+		return nil, 0, ErrTruncatedRow
+	}
+	offset += n
+	slice := row[offset : offset+int(length)]
+	if column.Type == ColumnBlob {
+		return Blob(append([]byte{}, slice...)), offset + int(length), nil
+	}
+	if column.Type == ColumnJSON {
+		return JSON(slice), offset + int(length), nil
+	}
+	return string(slice), offset + int(length), nil
+}
+
+func readVarintColumn(slice []byte, column *Column) interface{} {
 	switch column.Type {
 	case ColumnInt32:
-		slice := row[offset : offset+4]
 		v, _ := binary.ReadVarint(bytes.NewReader(slice))
-		return Int32(v), offset + 4
+		return Int32(v)
 	case ColumnInt64:
-		slice := row[offset : offset+8]
 		v, _ := binary.ReadVarint(bytes.NewReader(slice))
-		return Int64(v), offset + 8
+		return Int64(v)
 	case ColumnUInt32:
-		slice := row[offset : offset+4]
 		v, _ := binary.ReadUvarint(bytes.NewReader(slice))
-		return UInt32(v), offset + 4
+		return UInt32(v)
 	case ColumnUInt64:
-		slice := row[offset : offset+8]
 		v, _ := binary.ReadUvarint(bytes.NewReader(slice))
-		return UInt64(v), offset + 8
+		return UInt64(v)
 	case ColumnFixedString:
-		slice := row[offset : offset+column.Strlen]
-		return FixedString(slice), offset + column.Strlen
-	case ColumnDate:
-		slice := row[offset : offset+8]
-		v, _ := binary.ReadVarint(bytes.NewReader(slice))
-		return Date(time.Unix(v, 0)), offset + 8
+		return FixedString(slice)
 	default:
-		return nil, 0
+		v, _ := binary.ReadVarint(bytes.NewReader(slice))
+		return Date(time.Unix(v, 0))
 	}
 }
 
@@ -62,42 +334,274 @@ func (b *RawBuilder) Reset() {
 	b.buff = bytes.NewBufferString("")
 }
 
+// AppendRow encodes the whole row, values must have been casted
+// to types of columns and nil stands for NULL.
+func (b *RawBuilder) AppendRow(row *Row) error {
+	nulls := make([]bool, len(row.Values))
+	for i, c := range row.Schema.Columns {
+		if row.Values[i] == nil {
+			if c.NotNull {
+				return fmt.Errorf("column %s cannot be null", c.Name)
+			}
+			nulls[i] = true
+		}
+	}
+	b.AppendRowHeader(row.Schema.Version, nulls)
+	for i, c := range row.Schema.Columns {
+		if nulls[i] {
+			continue
+		}
+		if err := b.AppendValue(c, row.Values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendRowHeader must be called before any column is appended,
+// nulls has an element for each column of the row. Values of NULL
+// columns must not be appended.
+func (b *RawBuilder) AppendRowHeader(version uint32, nulls []bool) {
+	buf := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(buf, uint64(version))
+	b.buff.WriteByte(RowFormat)
+	b.buff.Write(buf[:n])
+
+	bitmap := make([]byte, nullBitmapSize(len(nulls)))
+	for i, null := range nulls {
+		if null {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	b.buff.Write(bitmap)
+}
+
+// AppendValue appends v as a value of column c, and checks
+// whether c can hold it.
+func (b *RawBuilder) AppendValue(c *Column, v interface{}) error {
+	var err error
+	var ok bool
+	switch c.Type {
+	case ColumnInt32:
+		var value Int32
+		if value, ok = v.(Int32); ok && !c.holdsInteger(value) {
+			err = fmt.Errorf("value %d is out of range of %s", value, c.integerName())
+		} else if ok {
+			err = b.AppendInt32(value)
+		}
+	case ColumnInt64:
+		var value Int64
+		if value, ok = v.(Int64); ok {
+			b.AppendInt64(value)
+		}
+	case ColumnUInt32:
+		var value UInt32
+		if value, ok = v.(UInt32); ok && !c.holdsInteger(value) {
+			err = fmt.Errorf("value %d is out of range of %s", value, c.integerName())
+		} else if ok {
+			err = b.AppendUInt32(value)
+		}
+	case ColumnUInt64:
+		var value UInt64
+		if value, ok = v.(UInt64); ok {
+			b.AppendUInt64(value)
+		}
+	case ColumnFixedString:
+		var value FixedString
+		if value, ok = v.(FixedString); ok {
+			err = b.AppendFixedString(value, c.Strlen)
+		}
+	case ColumnDate:
+		var value Date
+		if value, ok = v.(Date); ok {
+			b.AppendDate(value)
+		}
+	case ColumnDateOnly, ColumnDatetime:
+		var value Date
+		if value, ok = v.(Date); ok {
+			b.AppendInt64(value.WallMicros())
+		}
+	case ColumnTimestamp:
+		var value Date
+		if value, ok = v.(Date); ok {
+			b.AppendInt64(value.Micros())
+		}
+	case ColumnVarchar, ColumnText:
+		var value string
+		if value, ok = v.(string); ok {
+			err = b.AppendBytes([]byte(value), c.Strlen)
+		}
+	case ColumnBlob:
+		var value Blob
+		if value, ok = v.(Blob); ok {
+			err = b.AppendBytes(value, c.Strlen)
+		}
+	case ColumnFloat:
+		var value Float
+		if value, ok = v.(Float); ok {
+			b.AppendUInt32(UInt32(math.Float32bits(value)))
+		}
+	case ColumnDouble:
+		var value Double
+		if value, ok = v.(Double); ok {
+			b.AppendUInt64(math.Float64bits(value))
+		}
+	case ColumnDecimal:
+		var value Decimal
+		if value, ok = v.(Decimal); ok {
+			err = b.AppendDecimal(value, c.Precision, c.Scale)
+		}
+	case ColumnBoolean:
+		var value Boolean
+		if value, ok = v.(Boolean); ok {
+			b.AppendBoolean(value)
+		}
+	case ColumnEnum:
+		var value Enum
+		if value, ok = v.(Enum); ok {
+			err = b.AppendEnum(value, c.Elems)
+		} else {
+			// Casting failed
+			ok = true
+			err = fmt.Errorf("invalid value '%v'", v)
+		}
+	case ColumnJSON:
+		switch value := v.(type) {
+		case JSON:
+			ok = true
+			err = b.AppendBytes([]byte(value), c.Strlen)
+		case string:
+			// Not casted yet, or not a JSON text
+			j, err := ParseJSON(value)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.Name, err)
+			}
+			return b.AppendValue(c, j)
+		}
+	}
+	if !ok && c.Type == ColumnFloat {
+		// Casting failed
+		ok = true
+		err = fmt.Errorf("value %v is out of range of %s", v, c.Type)
+	}
+	if !ok && c.Type.IsInteger() {
+		// Casting failed
+		switch value := v.(type) {
+		case string:
+			ok = true
+			err = fmt.Errorf("incorrect integer value '%s'", value)
+		case int, int64, uint, uint64, float32, float64, Decimal:
+			ok = true
+			err = fmt.Errorf("value %v is out of range of %s", value, c.integerName())
+		}
+	}
+	if s, isString := v.(string); !ok && isString && c.Type.IsTemporal() {
+		// Not casted yet, or not a date
+		d, err := ParseDate(s)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", c.Name, err)
+		}
+		return b.AppendValue(c, CastColumn(d, c))
+	}
+	if !ok {
+		return fmt.Errorf("can't store %T into column %s of %s", v, c.Name, c.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("column %s: %v", c.Name, err)
+	}
+	return nil
+}
+
 func (b *RawBuilder) AppendInt64(v Int64) {
 	buf := make([]byte, 8)
-	binary.PutVarint(buf, int64(v))
+	binary.BigEndian.PutUint64(buf, uint64(v))
 	b.buff.Write(buf)
 }
 
-func (b *RawBuilder) AppendInt32(v Int32) {
+func (b *RawBuilder) AppendInt32(v Int32) error {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return fmt.Errorf("value %d is out of range of Int32", v)
+	}
 	buf := make([]byte, 4)
-	binary.PutVarint(buf, int64(v))
+	binary.BigEndian.PutUint32(buf, uint32(int32(v)))
 	b.buff.Write(buf)
+	return nil
 }
 
 func (b *RawBuilder) AppendUInt64(v UInt64) {
 	buf := make([]byte, 8)
-	binary.PutUvarint(buf, uint64(v))
+	binary.BigEndian.PutUint64(buf, v)
 	b.buff.Write(buf)
 }
 
-func (b *RawBuilder) AppendUInt32(v UInt32) {
+func (b *RawBuilder) AppendUInt32(v UInt32) error {
+	if uint64(v) > math.MaxUint32 {
+		return fmt.Errorf("value %d is out of range of UInt32", v)
+	}
 	buf := make([]byte, 4)
-	binary.PutUvarint(buf, uint64(v))
+	binary.BigEndian.PutUint32(buf, uint32(v))
 	b.buff.Write(buf)
+	return nil
 }
 
-func (b *RawBuilder) AppendFixedString(v FixedString) {
-	b.buff.Write([]byte(v))
+// AppendFixedString pads v with zero to strlen bytes.
+func (b *RawBuilder) AppendFixedString(v FixedString, strlen int) error {
+	if len(v) > strlen {
+		return fmt.Errorf("data is too long, %d bytes at most", strlen)
+	}
+	b.buff.WriteString(v)
+	for i := len(v); i < strlen; i++ {
+		b.buff.WriteByte(0)
+	}
+	return nil
 }
 
-func (b *RawBuilder) AppendDate(v Date) {
-	buf := make([]byte, 8)
-	binary.PutVarint(buf, v.Timestamp())
+// AppendBytes appends v prefixed by its length, which must not exceed maxlen.
+func (b *RawBuilder) AppendBytes(v []byte, maxlen int) error {
+	if len(v) > maxlen {
+		return fmt.Errorf("data is too long, %d bytes at most", maxlen)
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(v)))
+	b.buff.Write(buf[:n])
+	b.buff.Write(v)
+	return nil
+}
+
+// AppendDecimal rounds v to scale, it must have no more than
+// precision digits after that.
+func (b *RawBuilder) AppendDecimal(v Decimal, precision, scale int) error {
+	r, err := v.Rescale(scale)
+	if err != nil || r.Precision()-scale > precision-scale {
+		return fmt.Errorf("value %s is out of range of Decimal(%d, %d)", v, precision, scale)
+	}
+	b.AppendInt64(r.Unscaled)
+	return nil
+}
+
+// AppendEnum appends index of v, which must be an element of elems.
+func (b *RawBuilder) AppendEnum(v Enum, elems []string) error {
+	if v.Index < 1 || v.Index > len(elems) || v.String() != elems[v.Index-1] {
+		return fmt.Errorf("invalid value '%s'", v)
+	}
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, uint16(v.Index))
 	b.buff.Write(buf)
+	return nil
+}
+
+func (b *RawBuilder) AppendBoolean(v Boolean) {
+	b.buff.WriteByte(byte(boolToInt(v)))
+}
+
+func (b *RawBuilder) AppendDate(v Date) {
+	b.AppendInt64(v.Timestamp())
 }
 
-// Spawn will return a slice of buffer held by RawBuilder.
-// The result can be correctly read only before RawBuilder reset.
+// Spawn will return the row in buffer followed by its checksum.
 func (b *RawBuilder) Spawn() []byte {
-	return b.buff.Bytes()
+	raw := make([]byte, b.buff.Len()+checksumSize)
+	n := copy(raw, b.buff.Bytes())
+	binary.BigEndian.PutUint32(raw[n:], crc32.ChecksumIEEE(raw[:n]))
+	return raw
 }
@@ -1,6 +1,10 @@
 package util
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,12 +14,13 @@ import (
 func TestEncoding(t *testing.T) {
 	assert := assert.New(t)
 	now := Date(time.Now())
-	b := &RawBuilder{}
+	b := NewRawBuilder()
+	b.AppendRowHeader(0, make([]bool, 6))
 	b.AppendInt64(123)
 	b.AppendInt32(-321)
 	b.AppendUInt32(1234)
 	b.AppendUInt64(4321)
-	b.AppendFixedString("1234")
+	b.AppendFixedString("1234", 4)
 	b.AppendDate(now)
 
 	buff := b.Spawn()
@@ -44,7 +49,8 @@ func TestEncoding(t *testing.T) {
 		},
 	}
 
-	row := ReadRow(buff, schema)
+	row, err := ReadRow(nil, buff, schema)
+	assert.Equal(err, nil)
 
 	assert.Equal(row.Values[0], Int64(123))
 	assert.Equal(row.Values[1], Int32(-321))
@@ -53,3 +59,253 @@ func TestEncoding(t *testing.T) {
 	assert.Equal(row.Values[4], FixedString("1234"))
 	assert.Equal(row.Values[5].(Date).Timestamp(), now.Timestamp())
 }
+
+func TestEncodingRange(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Columns: []*Column{
+			{Name: "i32", Type: ColumnInt32},
+			{Name: "i64", Type: ColumnInt64},
+			{Name: "u32", Type: ColumnUInt32},
+			{Name: "u64", Type: ColumnUInt64},
+			{Name: "str", Type: ColumnFixedString, Strlen: 5},
+			{Name: "date", Type: ColumnDate},
+		},
+	}
+
+	rows := [][]interface{}{
+		{Int32(math.MinInt32), Int64(math.MinInt64), UInt32(0), UInt64(0), FixedString(""), Date(time.Unix(math.MinInt32, 0))},
+		{Int32(math.MaxInt32), Int64(math.MaxInt64), UInt32(math.MaxUint32), UInt64(math.MaxUint64), FixedString("hello"), Date(time.Unix(math.MaxInt32*4, 0))},
+		{Int32(-1), Int64(-1), UInt32(1), UInt64(1), FixedString("hi"), Date(time.Unix(0, 0))},
+	}
+	for _, values := range rows {
+		b := NewRawBuilder()
+		assert.Equal(b.AppendRow(&Row{Schema: schema, Values: values}), nil)
+		row, err := ReadRow(nil, b.Spawn(), schema)
+		assert.Equal(err, nil)
+		for i := range values[:5] {
+			assert.Equal(row.Values[i], values[i])
+		}
+		assert.Equal(row.Values[5].(Date).Timestamp(), values[5].(Date).Timestamp())
+	}
+
+	// Values out of range of column are rejected
+	invalid := [][]interface{}{
+		{Int32(math.MaxInt32 + 1), Int64(0), UInt32(0), UInt64(0), FixedString(""), Date(time.Unix(0, 0))},
+		{Int32(0), Int64(0), UInt32(math.MaxUint32 + 1), UInt64(0), FixedString(""), Date(time.Unix(0, 0))},
+		{Int32(0), Int64(0), UInt32(0), UInt64(0), FixedString("hello!"), Date(time.Unix(0, 0))},
+		{"0", Int64(0), UInt32(0), UInt64(0), FixedString(""), Date(time.Unix(0, 0))},
+	}
+	for _, values := range invalid {
+		b := NewRawBuilder()
+		assert.NEqual(b.AppendRow(&Row{Schema: schema, Values: values}), nil)
+	}
+
+	// Truncated rows are reported instead of panic
+	b := NewRawBuilder()
+	b.AppendRow(&Row{Schema: schema, Values: rows[0]})
+	buff := b.Spawn()
+	for i := 0; i < len(buff); i++ {
+		_, err := ReadRow(nil, buff[:i], schema)
+		assert.NEqual(err, nil)
+	}
+}
+
+func TestReadVarintFormat(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Columns: []*Column{
+			{Type: ColumnInt64},
+			{Type: ColumnUInt32},
+			{Type: ColumnFixedString, Strlen: 3},
+		},
+	}
+
+	buff := []byte{RowFormatVarint, 0}
+	slot := make([]byte, 8)
+	binary.PutVarint(slot, -42)
+	buff = append(buff, slot...)
+	slot = make([]byte, 4)
+	binary.PutUvarint(slot, 42)
+	buff = append(buff, slot...)
+	buff = append(buff, "abc"...)
+
+	row, err := ReadRow(nil, buff, schema)
+	assert.Equal(err, nil)
+	assert.Equal(row.Values, []interface{}{Int64(-42), UInt32(42), FixedString("abc")})
+}
+
+func TestReadOldVersion(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Columns: []*Column{
+			{
+				ID:   1,
+				Type: ColumnInt64,
+				Name: "a",
+			},
+			{
+				ID:   2,
+				Type: ColumnInt32,
+				Name: "b",
+			},
+		},
+	}
+
+	b := NewRawBuilder()
+	b.AppendRowHeader(0, make([]bool, 2))
+	b.AppendInt64(1)
+	b.AppendInt32(2)
+	buff := b.Spawn()
+
+	// drop b, add c and change type of a
+	origin := "42"
+	next := schema.NextVersion()
+	next.Columns = next.Columns[:1]
+	next.Columns[0].Type = ColumnFixedString
+	next.Columns[0].Strlen = 4
+	next.Columns = append(next.Columns, &Column{
+		ID:            3,
+		Type:          ColumnUInt64,
+		Name:          "c",
+		OriginDefault: &origin,
+	})
+
+	row, err := ReadRow(nil, buff, next)
+	assert.Equal(err, nil)
+	assert.Equal(row.Values, []interface{}{"1", uint64(42)})
+	assert.True(IsRowOutdated(buff, next))
+	assert.False(IsRowOutdated(buff, schema))
+}
+
+func TestEncodingNull(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Columns: []*Column{
+			{ID: 1, Name: "a", Type: ColumnInt64, NotNull: true},
+			{ID: 2, Name: "b", Type: ColumnFixedString, Strlen: 4},
+			{ID: 3, Name: "c", Type: ColumnInt32},
+		},
+	}
+
+	b := NewRawBuilder()
+	err := b.AppendRow(&Row{Schema: schema, Values: []interface{}{Int64(1), nil, Int32(3)}})
+	assert.Equal(err, nil)
+	buff := b.Spawn()
+	// header, bitmap, a, c and checksum
+	assert.Equal(len(buff), 2+1+8+4+4)
+
+	row, err := ReadRow(nil, buff, schema)
+	assert.Equal(err, nil)
+	assert.Equal(row.Values, []interface{}{Int64(1), nil, Int32(3)})
+
+	b.Reset()
+	err = b.AppendRow(&Row{Schema: schema, Values: []interface{}{nil, "x", Int32(3)}})
+	assert.NEqual(err, nil)
+
+	// added nullable column without default is NULL in old rows
+	next := schema.NextVersion()
+	next.Columns = append(next.Columns, &Column{ID: 4, Name: "d", Type: ColumnInt32})
+	row, err = ReadRow(nil, buff, next)
+	assert.Equal(err, nil)
+	assert.Equal(row.Values, []interface{}{Int64(1), nil, Int32(3), nil})
+}
+
+func TestEncodingVariableLength(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Columns: []*Column{
+			{ID: 1, Name: "a", Type: ColumnVarchar, Strlen: 4},
+			{ID: 2, Name: "b", Type: ColumnText, Strlen: 1<<16 - 1},
+			{ID: 3, Name: "c", Type: ColumnBlob, Strlen: 1<<16 - 1},
+		},
+	}
+
+	long := strings.Repeat("x", 300)
+	b := NewRawBuilder()
+	err := b.AppendRow(&Row{Schema: schema, Values: []interface{}{"ab", long, Blob{0, 0xff}}})
+	assert.Equal(err, nil)
+	buff := b.Spawn()
+	// header, bitmap, a with 1 byte length, b with 2 bytes, c with 1 byte and checksum
+	assert.Equal(len(buff), 2+1+1+2+2+300+1+2+4)
+
+	row, err := ReadRow(nil, buff, schema)
+	assert.Equal(err, nil)
+	assert.Equal(row.Values, []interface{}{"ab", long, Blob{0, 0xff}})
+
+	_, err = ReadRow(nil, buff[:len(buff)-1], schema)
+	assert.True(errors.Is(err, ErrChecksumMismatch))
+
+	b.Reset()
+	err = b.AppendRow(&Row{Schema: schema, Values: []interface{}{"abcde", "", Blob{}}})
+	assert.NEqual(err, nil)
+	out := Prettify([]*Row{{Schema: schema, Values: []interface{}{"", "", Blob{0x0a, 0xff}}}})
+	assert.True(strings.Contains(out, " 0x0AFF "))
+}
+
+// TestAppendString checks strings not casted into values of columns
+// are encoded as the values they are parsed into.
+func TestAppendString(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []*Column{
+		{Name: "d", Type: ColumnDateOnly},
+		{Name: "dt", Type: ColumnDatetime, Fsp: 2},
+		{Name: "ts", Type: ColumnTimestamp},
+	} {
+		s := "2020-01-02 03:04:05.678"
+		b, casted := NewRawBuilder(), NewRawBuilder()
+		assert.Equal(b.AppendValue(c, s), nil)
+		assert.Equal(casted.AppendValue(c, CastColumn(s, c)), nil)
+		assert.Equal(b.Spawn(), casted.Spawn())
+		assert.NEqual(b.AppendValue(c, "2020-13-45"), nil)
+	}
+	c := &Column{Name: "j", Type: ColumnJSON, Strlen: 100}
+	b, casted := NewRawBuilder(), NewRawBuilder()
+	assert.Equal(b.AppendValue(c, `{"a": [1, 2]}`), nil)
+	assert.Equal(casted.AppendValue(c, CastColumn(`{"a": [1, 2]}`, c)), nil)
+	assert.Equal(b.Spawn(), casted.Spawn())
+	assert.NEqual(b.AppendValue(c, `{"a"`), nil)
+}
+
+func TestEncodingChecksum(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Database:   "db",
+		TableName:  "t",
+		PrimaryKey: []string{"a"},
+		Columns: []*Column{
+			{ID: 1, Name: "a", Type: ColumnInt64},
+			{ID: 2, Name: "b", Type: ColumnVarchar, Strlen: 10},
+			{ID: 3, Name: "c", Type: ColumnEnum, Elems: []string{"x", "y"}},
+		},
+	}
+	row := &Row{Schema: schema, Values: []interface{}{Int64(1), "hello", Enum{Index: 2, Elems: []string{"x", "y"}}}}
+	key := GenerateKey(schema, schema.PrimaryKeyValues(row))
+	b := NewRawBuilder()
+	assert.Equal(b.AppendRow(row), nil)
+	buff := b.Spawn()
+	assert.Equal(buff[0], RowFormatChecksum)
+	_, err := ReadRow(key, buff, schema)
+	assert.Equal(err, nil)
+
+	// Flipping any bit is detected
+	for i := 1; i < len(buff); i++ {
+		damaged := append([]byte{}, buff...)
+		damaged[i] ^= 0x10
+		_, err = ReadRow(key, damaged, schema)
+		corrupted, ok := err.(*CorruptedRowError)
+		assert.True(ok)
+		assert.Equal(corrupted.Table, "t")
+		assert.Equal(corrupted.Key, "(1)")
+	}
+	assert.Equal(err.Error(), "corrupted row of table db.t at key (1): checksum mismatch")
+
+	// Rows without checksum are still checked while being decoded
+	legacy := append([]byte{RowFormatNullable}, buff[1:len(buff)-4]...)
+	legacy[len(legacy)-1] = 3
+	_, err = ReadRow(key, legacy, schema)
+	assert.NEqual(err, nil)
+	legacy[len(legacy)-1] = 2
+	_, err = ReadRow(key, legacy, schema)
+	assert.Equal(err, nil)
+}
@@ -2,7 +2,10 @@ package util
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 func Prettify(rows []*Row) string {
@@ -27,6 +30,8 @@ func Prettify(rows []*Row) string {
 		var row []string
 		for i, c := range r.Values {
 			switch v := c.(type) {
+			case nil:
+				row = append(row, "NULL")
 			case Int32:
 				row = append(row, strconv.Itoa(v))
 			case Int64:
@@ -37,8 +42,22 @@ func Prettify(rows []*Row) string {
 				row = append(row, strconv.FormatUint(uint64(v), 10))
 			case FixedString:
 				row = append(row, v)
+			case Float:
+				row = append(row, strconv.FormatFloat(float64(v), 'g', -1, 32))
+			case Double:
+				row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+			case Blob:
+				row = append(row, "0x"+strings.ToUpper(hex.EncodeToString(v)))
+			case Date:
+				row = append(row, v.Format(r.Schema.Columns[i]))
+			case Boolean:
+				row = append(row, strconv.FormatBool(v))
+			case JSON:
+				row = append(row, string(v))
+			case Enum:
+				row = append(row, v.String())
 			default:
-				panic("Unknown type")
+				row = append(row, fmt.Sprint(v))
 			}
 			if len(row[i])+2 > align[i] {
 				align[i] = len(row[i]) + 2
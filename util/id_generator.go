@@ -1,20 +1,10 @@
 package util
 
+// IDGenerator hands out increasing IDs, it's safe for concurrent use.
 type IDGenerator interface {
-	Gen() Int64
-}
-
-type idGen struct {
-	current Int64
-}
-
-func (g *idGen) Gen() Int64 {
-	g.current++
-	return g.current
-}
-
-func NewIDGenerator(start Int64) IDGenerator {
-	return &idGen{
-		current: start,
-	}
+	// Gen returns the next ID.
+	Gen() (Int64, error)
+	// Rebase makes IDs generated later greater than id,
+	// it's called when an ID is given by user.
+	Rebase(id Int64) error
 }
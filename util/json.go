@@ -0,0 +1,257 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSON is a JSON text normalized by ParseJSON, members of objects
+// are sorted by their keys.
+type JSON string
+
+func (j JSON) TypeName() string {
+	return "JSON"
+}
+
+// ParseJSON validates and normalizes s.
+func ParseJSON(s string) (JSON, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return "", err
+	}
+	return NewJSON(v), nil
+}
+
+func decodeJSON(s string) (interface{}, error) {
+	if !json.Valid([]byte(s)) {
+		return nil, fmt.Errorf("invalid JSON text %q", s)
+	}
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var v interface{}
+	err := d.Decode(&v)
+	return v, err
+}
+
+// NewJSON encodes a value decoded from JSON, or made of maps, slices
+// and scalars of JSON.
+func NewJSON(v interface{}) JSON {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		panic(err)
+	}
+	return JSON(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// Value decodes j, numbers are json.Number.
+func (j JSON) Value() interface{} {
+	v, _ := decodeJSON(string(j))
+	return v
+}
+
+// Scalar returns the SQL value of a scalar j: a string, a bool, an
+// int64, a Decimal or a float64. JSON null is nil. ok is false for
+// objects and arrays.
+func (j JSON) Scalar() (v interface{}, ok bool) {
+	switch value := j.Value().(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, false
+	case json.Number:
+		return jsonNumber(value), true
+	default:
+		return value, true
+	}
+}
+
+func jsonNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if d, err := ParseDecimal(string(n)); err == nil {
+		return d
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// Type returns the type of j like MySQL's JSON_TYPE.
+func (j JSON) Type() string {
+	switch value := j.Value().(type) {
+	case map[string]interface{}:
+		return "OBJECT"
+	case []interface{}:
+		return "ARRAY"
+	case string:
+		return "STRING"
+	case bool:
+		return "BOOLEAN"
+	case json.Number:
+		switch jsonNumber(value).(type) {
+		case int64:
+			return "INTEGER"
+		case Decimal:
+			return "DECIMAL"
+		default:
+			return "DOUBLE"
+		}
+	default:
+		return "NULL"
+	}
+}
+
+// Unquote returns the string j holds, or the text of j if it's not a string.
+func (j JSON) Unquote() string {
+	if s, ok := j.Value().(string); ok {
+		return s
+	}
+	return string(j)
+}
+
+// JSONPath is a path like $.a[0].b, a leg of it is a member of object
+// or an element of array. Wildcards .* and [*] match all of them.
+type JSONPath []jsonPathLeg
+
+type jsonPathLeg struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// lastIndex is the index of [last].
+const lastIndex = -1
+
+// ParseJSONPath parses a path starting with $.
+func ParseJSONPath(s string) (JSONPath, error) {
+	invalid := fmt.Errorf("invalid JSON path expression '%s'", s)
+	p := strings.TrimSpace(s)
+	if !strings.HasPrefix(p, "$") {
+		return nil, invalid
+	}
+	p = p[1:]
+	var path JSONPath
+	for p = strings.TrimLeft(p, " "); p != ""; p = strings.TrimLeft(p, " ") {
+		var leg jsonPathLeg
+		switch p[0] {
+		case '.':
+			p = strings.TrimLeft(p[1:], " ")
+			switch {
+			case strings.HasPrefix(p, "*"):
+				leg.wildcard = true
+				p = p[1:]
+			case strings.HasPrefix(p, `"`):
+				end := 1
+				for ; end < len(p) && p[end] != '"'; end++ {
+					if p[end] == '\\' {
+						end++
+					}
+				}
+				if end >= len(p) {
+					return nil, invalid
+				}
+				key, err := strconv.Unquote(p[:end+1])
+				if err != nil {
+					return nil, invalid
+				}
+				leg.key = key
+				p = p[end+1:]
+			default:
+				end := strings.IndexAny(p, ".[ ")
+				if end < 0 {
+					end = len(p)
+				}
+				if end == 0 {
+					return nil, invalid
+				}
+				leg.key = p[:end]
+				p = p[end:]
+			}
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			leg.isIndex = true
+			switch inner := strings.TrimSpace(p[1:end]); inner {
+			case "*":
+				leg.wildcard = true
+			case "last":
+				leg.index = lastIndex
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, invalid
+				}
+				leg.index = i
+			}
+			p = p[end+1:]
+		default:
+			return nil, invalid
+		}
+		path = append(path, leg)
+	}
+	return path, nil
+}
+
+// HasWildcard tells whether path may match more than one value.
+func (path JSONPath) HasWildcard() bool {
+	for _, leg := range path {
+		if leg.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns values in v matched by path. A scalar is an array of
+// itself to index legs, so $[0] of 1 is 1.
+func (path JSONPath) Extract(v interface{}) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	leg, rest := path[0], path[1:]
+	var matches []interface{}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if leg.isIndex {
+			if !leg.wildcard && (leg.index == 0 || leg.index == lastIndex) {
+				matches = append(matches, rest.Extract(v)...)
+			}
+		} else if leg.wildcard {
+			keys := make([]string, 0, len(value))
+			for k := range value {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				matches = append(matches, rest.Extract(value[k])...)
+			}
+		} else if member, ok := value[leg.key]; ok {
+			matches = append(matches, rest.Extract(member)...)
+		}
+	case []interface{}:
+		if !leg.isIndex {
+			break
+		}
+		if leg.wildcard {
+			for _, e := range value {
+				matches = append(matches, rest.Extract(e)...)
+			}
+		} else if i := leg.index; i == lastIndex && len(value) > 0 {
+			matches = append(matches, rest.Extract(value[len(value)-1])...)
+		} else if i >= 0 && i < len(value) {
+			matches = append(matches, rest.Extract(value[i])...)
+		}
+	default:
+		if leg.isIndex && !leg.wildcard && (leg.index == 0 || leg.index == lastIndex) {
+			matches = append(matches, rest.Extract(v)...)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	j, err := ParseJSON(` {"b": [1, 2.50, "<x>"], "a": {"c": null}} `)
+	assert.Equal(err, nil)
+	assert.Equal(j, JSON(`{"a":{"c":null},"b":[1,2.50,"<x>"]}`))
+	assert.Equal(j.Type(), "OBJECT")
+	_, err = ParseJSON(`{"a": 1} x`)
+	assert.NEqual(err, nil)
+
+	scalar, ok := JSON("2.50").Scalar()
+	assert.True(ok)
+	assert.Equal(scalar, Decimal{Unscaled: 250, Scale: 2})
+	scalar, ok = JSON("1e3").Scalar()
+	assert.Equal(scalar, 1e3)
+	_, ok = JSON("[]").Scalar()
+	assert.False(ok)
+	assert.Equal(JSON(`"a\nb"`).Unquote(), "a\nb")
+
+	cases := map[string][]interface{}{
+		"$":           {j.Value()},
+		"$.b[2]":      {"<x>"},
+		"$.b[last]":   {"<x>"},
+		`$."a".c`:     {nil},
+		"$.a[0].c":    {nil},
+		"$.b[*]":      j.Value().(map[string]interface{})["b"].([]interface{}),
+		"$.*.c":       {nil},
+		"$.b[3]":      nil,
+		"$.missing.c": nil,
+	}
+	for s, expected := range cases {
+		path, err := ParseJSONPath(s)
+		assert.Equal(err, nil)
+		assert.Equal(path.Extract(j.Value()), expected)
+	}
+	for _, s := range []string{"a", "$.", "$[x]", "$[-1]", `$."a`, "$a"} {
+		_, err := ParseJSONPath(s)
+		assert.NEqual(err, nil)
+	}
+}
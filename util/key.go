@@ -1,8 +1,118 @@
 package util
 
-import "strconv"
+// Keys of system tables start with a zero byte, so they never
+// collide with keys of user tables.
+const (
+	catalogPrefix  = "\x00catalog:"
+	databasePrefix = "\x00database:"
+	metaPrefix     = "\x00meta:"
 
-func GenerateKey(pk Int64, table string) string {
-	key := table + ":" + strconv.FormatInt(pk, 10)
-	return key
+	dataPrefix  = 'd'
+	tablePrefix = 't'
+	rowPrefix   = 'r'
+	indexPrefix = 'i'
+	autoIDKey   = 'a'
+)
+
+// GenerateKey returns the key of row whose primary key is pk,
+// values in pk must have been casted to types of key columns.
+func GenerateKey(schema *Schema, pk []interface{}) []byte {
+	return EncodeKey(RowPrefix(schema.Database, schema.TableName), pk, schema.ColumnsByName(schema.PrimaryKey))
+}
+
+// IndexKey returns the key of an entry of unique index idx, values
+// in it must have been casted to types of index columns.
+func IndexKey(schema *Schema, idx *Index, values []interface{}) []byte {
+	return EncodeKey(IndexPrefix(schema.Database, schema.TableName, idx.ID), values, schema.ColumnsByName(idx.Columns))
+}
+
+// DatabasePrefix returns the prefix of all keys belonging to tables of db.
+func DatabasePrefix(db string) []byte {
+	return EncodeBytes([]byte{dataPrefix}, []byte(db))
+}
+
+// TablePrefix returns the prefix of all keys belonging to table:
+//
+//	d[database]t[table name]r[primary key] => row
+//	d[database]t[table name]i[index id][index columns] => row key
+//	d[database]t[table name]a => upper bound of allocated AUTO_INCREMENT IDs
+func TablePrefix(db, table string) []byte {
+	return EncodeBytes(append(DatabasePrefix(db), tablePrefix), []byte(table))
+}
+
+// RowPrefix returns the prefix of row keys of table.
+func RowPrefix(db, table string) []byte {
+	return append(TablePrefix(db, table), rowPrefix)
+}
+
+// IndexPrefix returns the prefix of entries of index id of table.
+func IndexPrefix(db, table string, id int) []byte {
+	return EncodeUint(append(TablePrefix(db, table), indexPrefix), uint64(id))
+}
+
+// AutoIDKey returns the key of AUTO_INCREMENT allocator of table.
+func AutoIDKey(db, table string) []byte {
+	return append(TablePrefix(db, table), autoIDKey)
+}
+
+// DatabaseRange returns the key range [low, up) holding all keys of tables of db.
+func DatabaseRange(db string) (low, up []byte) {
+	return prefixRange(DatabasePrefix(db))
+}
+
+// TableRange returns the key range [low, up) holding all keys of the table.
+func TableRange(db, table string) (low, up []byte) {
+	return prefixRange(TablePrefix(db, table))
+}
+
+// RowRange returns the key range [low, up) holding all rows of the table.
+func RowRange(db, table string) (low, up []byte) {
+	return prefixRange(RowPrefix(db, table))
+}
+
+// IndexRange returns the key range [low, up) holding all entries of the index.
+func IndexRange(db, table string, id int) (low, up []byte) {
+	return prefixRange(IndexPrefix(db, table, id))
+}
+
+// CatalogKey returns the key of table definition in catalog.
+func CatalogKey(db, table string) []byte {
+	return []byte(catalogPrefix + db + "\x00" + table)
+}
+
+// CatalogRange returns the key range [low, up) holding all table definitions.
+func CatalogRange() (low, up []byte) {
+	return prefixRange([]byte(catalogPrefix))
+}
+
+// DatabaseKey returns the key marking existence of database db.
+func DatabaseKey(db string) []byte {
+	return []byte(databasePrefix + db)
+}
+
+// DatabaseKeyRange returns the key range [low, up) holding keys of all databases.
+func DatabaseKeyRange() (low, up []byte) {
+	return prefixRange([]byte(databasePrefix))
+}
+
+// MetaKey returns the key of a global metadata entry.
+func MetaKey(name string) []byte {
+	return []byte(metaPrefix + name)
+}
+
+func prefixRange(prefix []byte) (low, up []byte) {
+	return prefix, PrefixEnd(prefix)
+}
+
+// PrefixEnd returns the smallest key greater than all keys with prefix.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	// prefix is all 0xFF, there is no upper bound
+	return nil
 }
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/leiysky/go-utils/assert"
+)
+
+func TestGenerateKey(t *testing.T) {
+	assert := assert.New(t)
+	schema := &Schema{
+		Database:  "d",
+		TableName: "t",
+	}
+
+	assert.True(bytes.HasPrefix(GenerateKey(schema, []interface{}{Int64(10)}), RowPrefix("d", "t")))
+	assert.NEqual(
+		GenerateKey(schema, []interface{}{FixedString("a:b"), FixedString("c")}),
+		GenerateKey(schema, []interface{}{FixedString("a"), FixedString("b:c")}),
+	)
+
+	// Table `a` must not see keys of table `ab`
+	low, up := TableRange("d", "a")
+	key := GenerateKey(&Schema{Database: "d", TableName: "ab"}, []interface{}{Int64(1)})
+	assert.False(bytes.Compare(key, low) >= 0 && bytes.Compare(key, up) < 0)
+
+	// Database `d` sees keys of database `dd`
+	low, up = DatabaseRange("d")
+	key = GenerateKey(&Schema{Database: "dd", TableName: "t"}, []interface{}{Int64(10)})
+	assert.False(bytes.Compare(key, low) >= 0 && bytes.Compare(key, up) < 0)
+	assert.NEqual(key, GenerateKey(schema, []interface{}{Int64(10)}))
+
+	// Index entries belong to table but are not rows
+	entry := IndexKey(schema, &Index{ID: 1}, []interface{}{Int64(1)})
+	low, up = TableRange("d", "t")
+	assert.True(bytes.Compare(entry, low) >= 0 && bytes.Compare(entry, up) < 0)
+	low, up = RowRange("d", "t")
+	assert.False(bytes.Compare(entry, low) >= 0 && bytes.Compare(entry, up) < 0)
+	low, up = IndexRange("d", "t", 2)
+	assert.False(bytes.Compare(entry, low) >= 0 && bytes.Compare(entry, up) < 0)
+}
+
+func TestMemcomparable(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every list is in ascending order
+	cases := [][]interface{}{
+		{Int64(math.MinInt64), Int64(-10), Int64(-1), Int64(0), Int64(9), Int64(10), Int64(math.MaxInt64)},
+		{UInt64(0), UInt64(9), UInt64(10), UInt64(math.MaxUint64)},
+		{FixedString(""), FixedString("a"), FixedString("a\x00"), FixedString("aaaaaaaa"), FixedString("aaaaaaaaa"), FixedString("b")},
+		{Double(math.Inf(-1)), Double(-1e300), Double(-1.5), Double(-1e-300), Double(0), Double(1e-300), Double(2.5), Double(math.Inf(1))},
+		{DateFromMicros(-1), DateFromMicros(0), DateFromMicros(1), DateFromMicros(1e6)},
+	}
+	// Only keys of temporal types depend on the column
+	datetime := []*Column{{Type: ColumnDatetime, Fsp: 6}}
+	for _, c := range cases {
+		for i := 1; i < len(c); i++ {
+			l := EncodeKey(nil, c[i-1:i], datetime)
+			r := EncodeKey(nil, c[i:i+1], datetime)
+			assert.Equal(bytes.Compare(l, r), -1)
+		}
+	}
+
+	columns := []*Column{
+		{Type: ColumnInt32},
+		{Type: ColumnFixedString},
+		{Type: ColumnUInt64},
+		{Type: ColumnDouble},
+		{Type: ColumnDecimal, Scale: 2},
+		{Type: ColumnTimestamp, Fsp: 6},
+		{Type: ColumnBoolean},
+		{Type: ColumnEnum, Elems: []string{"a", "b"}},
+	}
+	values := []interface{}{Int32(-3), FixedString("hello, world"), UInt64(42), Double(-0.25), Decimal{Unscaled: -150, Scale: 2}, DateFromMicros(1500000000123456), Boolean(true), Enum{Index: 2, Elems: []string{"a", "b"}}}
+	decoded, err := DecodeKey(EncodeKey(nil, values, columns), columns)
+	assert.Equal(err, nil)
+	assert.Equal(decoded, values)
+}
@@ -3,24 +3,50 @@ package util
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
-	"time"
 )
 
+// DefaultDatabase always exists, tables created by older versions
+// are moved into it.
+const DefaultDatabase = "main"
+
+// TableDescription is the schema of rows returned by DESCRIBE.
 var TableDescription = &Schema{
 	TableName: "schema",
 	Columns: []*Column{
 		{
 			Type:   ColumnFixedString,
 			Name:   "field",
-			Strlen: 10,
+			Strlen: 64,
 		},
 		{
 			Type:   ColumnFixedString,
 			Name:   "type",
-			Strlen: 10,
+			Strlen: 64,
+		},
+		{
+			Type:   ColumnFixedString,
+			Name:   "null",
+			Strlen: 3,
+		},
+		{
+			Type:   ColumnFixedString,
+			Name:   "key",
+			Strlen: 3,
+		},
+		{
+			Type:   ColumnFixedString,
+			Name:   "default",
+			Strlen: 64,
+		},
+		{
+			Type:   ColumnFixedString,
+			Name:   "extra",
+			Strlen: 64,
 		},
 	},
 }
@@ -41,11 +67,51 @@ func (tp ColumnType) String() string {
 		return "FixedString"
 	case ColumnDate:
 		return "Date"
+	case ColumnVarchar:
+		return "Varchar"
+	case ColumnText:
+		return "Text"
+	case ColumnBlob:
+		return "Blob"
+	case ColumnFloat:
+		return "Float"
+	case ColumnDouble:
+		return "Double"
+	case ColumnDecimal:
+		return "Decimal"
+	case ColumnDateOnly:
+		return "DateOnly"
+	case ColumnDatetime:
+		return "Datetime"
+	case ColumnTimestamp:
+		return "Timestamp"
+	case ColumnBoolean:
+		return "Boolean"
+	case ColumnJSON:
+		return "JSON"
+	case ColumnEnum:
+		return "Enum"
 	default:
 		panic("Unknown ColumnType")
 	}
 }
 
+// IsInteger tells whether values of type are integers.
+func (tp ColumnType) IsInteger() bool {
+	return tp == ColumnInt32 || tp == ColumnInt64 || tp == ColumnUInt32 || tp == ColumnUInt64
+}
+
+// IsTemporal tells whether values of type are Date.
+func (tp ColumnType) IsTemporal() bool {
+	return tp == ColumnDate || tp == ColumnDateOnly || tp == ColumnDatetime || tp == ColumnTimestamp
+}
+
+// IsVariableLength tells whether values of type are stored with
+// a length prefix, Column.Strlen is the max length of them.
+func (tp ColumnType) IsVariableLength() bool {
+	return tp == ColumnVarchar || tp == ColumnText || tp == ColumnBlob || tp == ColumnJSON
+}
+
 const (
 	ColumnInt32 ColumnType = iota
 	ColumnInt64
@@ -53,6 +119,21 @@ const (
 	ColumnUInt64
 	ColumnFixedString
 	ColumnDate
+	ColumnVarchar
+	ColumnText
+	ColumnBlob
+	ColumnFloat
+	ColumnDouble
+	ColumnDecimal
+	// ColumnDate is kept for tables created by older versions, it's
+	// stored in Unix seconds. DATE, DATETIME and TIMESTAMP columns
+	// are stored in microseconds.
+	ColumnDateOnly
+	ColumnDatetime
+	ColumnTimestamp
+	ColumnBoolean
+	ColumnJSON
+	ColumnEnum
 )
 
 type Int32 = int
@@ -65,6 +146,50 @@ type UInt64 = uint64
 
 type FixedString = string
 
+type Varchar = string
+
+type Text = string
+
+// Blob is binary string, it's shown in hex.
+type Blob []byte
+
+type Float = float32
+
+type Double = float64
+
+// Boolean is shown as true or false, it's 1 or 0 as a number.
+type Boolean = bool
+
+// Enum is a value of Enum column, the Index-th of its elements
+// counting from 1. It's compared by Index, and shown as the element.
+type Enum struct {
+	Index int
+	Elems []string
+}
+
+func (e Enum) TypeName() string {
+	return "Enum"
+}
+
+func (e Enum) String() string {
+	if e.Index < 1 || e.Index > len(e.Elems) {
+		return ""
+	}
+	return e.Elems[e.Index-1]
+}
+
+// IndexOf returns the index of element s, 0 if there is no such one.
+// Elements are matched case-insensitively, trailing spaces are ignored.
+func (e Enum) IndexOf(s string) int {
+	s = strings.TrimRight(s, " ")
+	for i, elem := range e.Elems {
+		if strings.EqualFold(elem, s) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
 func WhichColumnType(tp string) ColumnType {
 	switch tp {
 	case "Int32":
@@ -79,29 +204,213 @@ func WhichColumnType(tp string) ColumnType {
 		return ColumnFixedString
 	case "Date":
 		return ColumnDate
+	case "Varchar":
+		return ColumnVarchar
+	case "Text":
+		return ColumnText
+	case "Blob":
+		return ColumnBlob
+	case "Float":
+		return ColumnFloat
+	case "Double":
+		return ColumnDouble
+	case "Decimal":
+		return ColumnDecimal
+	case "DateOnly":
+		return ColumnDateOnly
+	case "Datetime":
+		return ColumnDatetime
+	case "Timestamp":
+		return ColumnTimestamp
+	case "Boolean":
+		return ColumnBoolean
+	case "JSON":
+		return ColumnJSON
+	case "Enum":
+		return ColumnEnum
 	default:
 		panic("Unknown ColumnType")
 	}
 }
 
-type Date time.Time
+type Schema struct {
+	Database  string    `json:"database"`
+	TableName string    `json:"table_name"`
+	Columns   []*Column `json:"columns"`
 
-func (v Date) TypeName() string {
-	return "Date"
+	// Version is bumped by every ALTER TABLE, each row records
+	// the version it was written with.
+	Version uint32 `json:"version"`
+	// Names of primary key columns, rows are stored in order of them.
+	PrimaryKey []string `json:"primary_key"`
+	// History keeps the columns of older versions, so rows written
+	// before an ALTER TABLE can still be decoded.
+	History []*SchemaVersion `json:"history,omitempty"`
+	// Unique indexes of the table, primary key is not one of them.
+	Indexes []*Index `json:"indexes,omitempty"`
 }
 
-func (dt Date) String() string {
-	return time.Time(dt).Format(time.RFC3339)
+// Index is an UNIQUE constraint on columns, entries of it are
+// stored under their own key range.
+type Index struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
 }
 
-// Timestamp will return Unix seconds timestamp
-func (dt Date) Timestamp() int64 {
-	return time.Time(dt).Unix()
+// IndexValues picks values of index columns from row.
+func (s *Schema) IndexValues(idx *Index, row *Row) []interface{} {
+	values := make([]interface{}, len(idx.Columns))
+	for i, name := range idx.Columns {
+		_, offset := s.GetColumnByName(name)
+		values[i] = row.Values[offset]
+	}
+	return values
 }
 
-type Schema struct {
-	TableName string
-	Columns   []*Column
+// IndexesOfColumn returns indexes covering column.
+func (s *Schema) IndexesOfColumn(name string) []*Index {
+	var indexes []*Index
+	for _, idx := range s.Indexes {
+		for _, c := range idx.Columns {
+			if c == name {
+				indexes = append(indexes, idx)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// AddIndex gives idx an unused ID and name, and appends it to the schema.
+func (s *Schema) AddIndex(idx *Index) {
+	names := make(map[string]bool)
+	for _, i := range s.Indexes {
+		names[i.Name] = true
+		if i.ID >= idx.ID {
+			idx.ID = i.ID + 1
+		}
+	}
+	if idx.ID == 0 {
+		idx.ID = 1
+	}
+	if idx.Name == "" {
+		idx.Name = idx.Columns[0]
+		for n := 2; names[idx.Name]; n++ {
+			idx.Name = idx.Columns[0] + "_" + strconv.Itoa(n)
+		}
+	}
+	s.Indexes = append(s.Indexes, idx)
+}
+
+// AutoIncrementColumn returns offset of the AUTO_INCREMENT column, -1 if there is none.
+func (s *Schema) AutoIncrementColumn() int {
+	for i, c := range s.Columns {
+		if c.AutoIncrement {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsPrimaryKey tells whether column is part of primary key.
+func (s *Schema) IsPrimaryKey(name string) bool {
+	for _, pk := range s.PrimaryKey {
+		if pk == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryKeyValues picks values of primary key columns from row.
+func (s *Schema) PrimaryKeyValues(row *Row) []interface{} {
+	pk := make([]interface{}, len(s.PrimaryKey))
+	for i, name := range s.PrimaryKey {
+		_, offset := s.GetColumnByName(name)
+		pk[i] = row.Values[offset]
+	}
+	return pk
+}
+
+// ColumnsByName returns columns of names in the same order.
+func (s *Schema) ColumnsByName(names []string) []*Column {
+	columns := make([]*Column, len(names))
+	for i, name := range names {
+		_, offset := s.GetColumnByName(name)
+		columns[i] = s.Columns[offset]
+	}
+	return columns
+}
+
+type SchemaVersion struct {
+	Version uint32    `json:"version"`
+	Columns []*Column `json:"columns"`
+}
+
+// ColumnsOfVersion returns the columns a row of given version was written with.
+func (s *Schema) ColumnsOfVersion(version uint32) []*Column {
+	if version == s.Version {
+		return s.Columns
+	}
+	for _, h := range s.History {
+		if h.Version == version {
+			return h.Columns
+		}
+	}
+	return nil
+}
+
+// NextVersion returns a copy of schema with a bumped version,
+// the current columns are moved into history.
+func (s *Schema) NextVersion() *Schema {
+	next := &Schema{
+		Database:   s.Database,
+		TableName:  s.TableName,
+		Version:    s.Version + 1,
+		PrimaryKey: s.PrimaryKey,
+		Indexes:    append([]*Index{}, s.Indexes...),
+	}
+	for _, c := range s.Columns {
+		col := *c
+		next.Columns = append(next.Columns, &col)
+	}
+	next.History = append(next.History, s.History...)
+	next.History = append(next.History, &SchemaVersion{
+		Version: s.Version,
+		Columns: s.Columns,
+	})
+	return next
+}
+
+// AssignColumnIDs gives every column without an ID a new one.
+func (s *Schema) AssignColumnIDs() {
+	next := s.MaxColumnID() + 1
+	for _, c := range s.Columns {
+		if c.ID == 0 {
+			c.ID = next
+			next++
+		}
+	}
+}
+
+// MaxColumnID returns the largest column ID ever used by the table,
+// IDs of dropped columns are never reused.
+func (s *Schema) MaxColumnID() int {
+	var max int
+	for _, c := range s.Columns {
+		if c.ID > max {
+			max = c.ID
+		}
+	}
+	for _, h := range s.History {
+		for _, c := range h.Columns {
+			if c.ID > max {
+				max = c.ID
+			}
+		}
+	}
+	return max
 }
 
 // A schema file example: $PROJECT_ROOT/examples/schema/my_table
@@ -123,19 +432,105 @@ func (s *Schema) GetColumnByName(name string) (col Column, offset int) {
 }
 
 type Column struct {
-	Type ColumnType
+	// ID identifies the column across versions of schema
+	ID   int        `json:"id"`
+	Type ColumnType `json:"type"`
+
+	Name string `json:"name"`
+	// Length of FixedString, or max length in bytes of Varchar, Text and Blob
+	Strlen int `json:"strlen,omitempty"`
+	// Number of digits and digits after the point of Decimal
+	Precision int `json:"precision,omitempty"`
+	Scale     int `json:"scale,omitempty"`
+	// Digits of fractional seconds of Datetime and Timestamp
+	Fsp int `json:"fsp,omitempty"`
+	// Bytes of TINYINT, SMALLINT or MEDIUMINT stored as Int32 or UInt32,
+	// values are in range of it. 0 means INT.
+	Width int `json:"width,omitempty"`
+	// Elements of Enum in order of declaration
+	Elems []string `json:"elems,omitempty"`
+
+	NotNull bool `json:"not_null,omitempty"`
+	// AutoIncrement column gets a generated ID if no value is given
+	AutoIncrement bool `json:"auto_increment,omitempty"`
+	// Default is the SQL expression of DEFAULT value, nil if there
+	// is none or it's NULL.
+	Default *string `json:"default,omitempty"`
+
+	// OriginDefault is the value of column in rows written
+	// before it was added by ALTER TABLE, nil means NULL.
+	OriginDefault *string `json:"origin_default,omitempty"`
+}
 
-	Name string
-	// For FixedString
-	Strlen int
+// originValue returns the value of column in rows written before
+// it was added.
+func (c *Column) originValue() interface{} {
+	if c.OriginDefault != nil {
+		return *c.OriginDefault
+	}
+	return nil
+}
+
+// holdsInteger tells whether integer v of column type is in range of
+// the width of c.
+func (c *Column) holdsInteger(v interface{}) bool {
+	if c.Width == 0 {
+		return true
+	}
+	bits := uint(8 * c.Width)
+	switch value := v.(type) {
+	case Int32:
+		return value >= -1<<(bits-1) && value < 1<<(bits-1)
+	case UInt32:
+		return value < 1<<bits
+	default:
+		return true
+	}
+}
+
+// integerName names integer type of c in errors.
+func (c *Column) integerName() string {
+	switch {
+	case c.Width == 0:
+		return c.Type.String()
+	case c.Type == ColumnUInt32:
+		return fmt.Sprintf("%d-byte unsigned integer", c.Width)
+	default:
+		return fmt.Sprintf("%d-byte integer", c.Width)
+	}
+}
+
+// ZeroValue returns the value a NOT NULL column without DEFAULT
+// takes when it's missing, the zero value of its type.
+func (c *Column) ZeroValue() interface{} {
+	switch {
+	case c.Type == ColumnEnum:
+		return Enum{Index: 1, Elems: c.Elems}
+	case c.Type == ColumnJSON:
+		return JSON("null")
+	case isNumeric(c.Type) || c.Type.IsTemporal():
+		return CastColumn(0, c)
+	default:
+		return ""
+	}
 }
 
 func (c *Column) String() string {
 	b := strings.Builder{}
 	b.WriteString(c.Type.String() + " " + c.Name)
-	if c.Type == ColumnFixedString {
+	if c.Type == ColumnFixedString || c.Type.IsVariableLength() {
 		b.WriteString(" " + strconv.Itoa(c.Strlen))
 	}
+	if c.Type == ColumnDecimal {
+		b.WriteString(" " + strconv.Itoa(c.Precision) + " " + strconv.Itoa(c.Scale))
+	}
+	if c.Type == ColumnDatetime || c.Type == ColumnTimestamp {
+		b.WriteString(" " + strconv.Itoa(c.Fsp))
+	}
+	if c.Type == ColumnEnum {
+		elems, _ := json.Marshal(c.Elems)
+		b.WriteString(" " + string(elems))
+	}
 	return b.String()
 }
 
@@ -162,9 +557,21 @@ func NewColumnFromBytes(buf []byte) *Column {
 	c := &Column{}
 	c.Type = WhichColumnType(line[0])
 	c.Name = line[1]
-	if c.Type == ColumnFixedString {
+	if c.Type == ColumnFixedString || c.Type.IsVariableLength() {
 		c.Strlen, _ = strconv.Atoi(line[2])
 	}
+	if c.Type == ColumnDecimal {
+		c.Precision, _ = strconv.Atoi(line[2])
+		c.Scale, _ = strconv.Atoi(line[3])
+	}
+	if (c.Type == ColumnDatetime || c.Type == ColumnTimestamp) && len(line) > 2 {
+		c.Fsp, _ = strconv.Atoi(line[2])
+	}
+	if c.Type == ColumnEnum {
+		// Elements may have spaces
+		elems := strings.SplitN(string(buf), " ", 3)[2]
+		json.Unmarshal([]byte(elems), &c.Elems)
+	}
 	return c
 }
 